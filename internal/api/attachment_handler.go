@@ -0,0 +1,121 @@
+package api
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/chat-backend/internal/api/middleware"
+	"github.com/chat-backend/internal/repository"
+	"github.com/chat-backend/internal/service"
+)
+
+type AttachmentHandler struct {
+	attachmentService *service.AttachmentService
+}
+
+func NewAttachmentHandler(attachmentService *service.AttachmentService) *AttachmentHandler {
+	return &AttachmentHandler{
+		attachmentService: attachmentService,
+	}
+}
+
+// Upload stores the request body as a new attachment. The body is the raw
+// file; its name comes from the filename query parameter.
+func (h *AttachmentHandler) Upload(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	// Never read more than the limit, whatever the client claims
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.attachmentService.MaxSize())
+
+	attachment, err := h.attachmentService.Upload(c.Request.Context(), service.UploadInput{
+		OwnerID:     userID,
+		Filename:    c.Query("filename"),
+		ContentType: c.GetHeader("Content-Type"),
+		Size:        c.Request.ContentLength,
+		Body:        c.Request.Body,
+	})
+	switch {
+	case errors.Is(err, service.ErrLengthRequired):
+		c.JSON(http.StatusLengthRequired, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, service.ErrAttachmentTooLarge),
+		errors.Is(err, service.ErrQuotaExceeded):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// GetAttachment returns an attachment's metadata
+func (h *AttachmentHandler) GetAttachment(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	attachment, err := h.attachmentService.GetAttachment(c.Request.Context(), userID, c.Param("id"))
+	if respondAttachmentError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, attachment)
+}
+
+// Download streams an attachment's content
+func (h *AttachmentHandler) Download(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	attachment, content, err := h.attachmentService.OpenAttachment(c.Request.Context(), userID, c.Param("id"))
+	if respondAttachmentError(c, err) {
+		return
+	}
+	defer content.Close()
+
+	c.DataFromReader(http.StatusOK, attachment.Size, attachment.ContentType, content, map[string]string{
+		"Content-Disposition":    mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}),
+		"ETag":                   `"` + attachment.Checksum + `"`,
+		"X-Content-Type-Options": "nosniff",
+	})
+}
+
+// respondAttachmentError writes the response for a failed attachment lookup
+// and reports whether there was one
+func respondAttachmentError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, repository.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+	case errors.Is(err, service.ErrNotParticipant):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+	return true
+}
+
+// RegisterRoutes registers the attachment routes
+func (h *AttachmentHandler) RegisterRoutes(router *gin.RouterGroup) {
+	attachments := router.Group("/attachments")
+	{
+		attachments.POST("", h.Upload)
+		attachments.GET("/:id", h.GetAttachment)
+		attachments.GET("/:id/content", h.Download)
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/chat-backend/internal/api/middleware"
+	"github.com/chat-backend/internal/models"
+	"github.com/chat-backend/internal/repository"
+	"github.com/chat-backend/internal/service"
+)
+
+type ConversationHandler struct {
+	messageService *service.MessageService
+}
+
+func NewConversationHandler(messageService *service.MessageService) *ConversationHandler {
+	return &ConversationHandler{
+		messageService: messageService,
+	}
+}
+
+// GetConversations returns the caller's inbox, most recently active first
+func (h *ConversationHandler) GetConversations(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	page, err := h.messageService.GetConversations(c.Request.Context(), userID, c.Query("cursor"), limit)
+	if errors.Is(err, models.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// MarkRead moves the caller's read pointer in a conversation up to a message
+func (h *ConversationHandler) MarkRead(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var input struct {
+		MessageID string `json:"message_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	messageID, err := uuid.Parse(input.MessageID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message ID"})
+		return
+	}
+
+	state, err := h.messageService.MarkConversationRead(c.Request.Context(), userID, c.Param("id"), messageID)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	case errors.Is(err, service.ErrMessageNotInConversation):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, service.ErrNotParticipant):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, state)
+}
+
+// RegisterRoutes registers the conversation routes
+func (h *ConversationHandler) RegisterRoutes(router *gin.RouterGroup) {
+	conversations := router.Group("/conversations")
+	{
+		conversations.GET("", h.GetConversations)
+		conversations.POST("/:id/read", h.MarkRead)
+	}
+}
@@ -1,11 +1,15 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/chat-backend/internal/api/middleware"
+	"github.com/chat-backend/internal/models"
+	"github.com/chat-backend/internal/repository"
 	"github.com/chat-backend/internal/service"
 )
 
@@ -20,13 +24,25 @@ func NewMessageHandler(messageService *service.MessageService) *MessageHandler {
 }
 
 func (h *MessageHandler) SendMessage(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
 	var input service.SendMessageInput
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// Messages are always sent as the caller, whatever the body says
+	input.SenderID = userID.String()
 
 	message, err := h.messageService.SendMessage(c.Request.Context(), input)
+	if errors.Is(err, service.ErrMessageNotInConversation) || errors.Is(err, service.ErrInvalidAttachment) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -36,8 +52,9 @@ func (h *MessageHandler) SendMessage(c *gin.Context) {
 }
 
 func (h *MessageHandler) GetMessage(c *gin.Context) {
+	viewer, _ := middleware.CurrentUserID(c)
 	messageID := c.Param("id")
-	message, err := h.messageService.GetMessage(c.Request.Context(), messageID)
+	message, err := h.messageService.GetMessage(c.Request.Context(), viewer, messageID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
 		return
@@ -48,81 +65,316 @@ func (h *MessageHandler) GetMessage(c *gin.Context) {
 
 func (h *MessageHandler) GetUserMessages(c *gin.Context) {
 	userID := c.Param("id")
+	viewer, _ := middleware.CurrentUserID(c)
 
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
 
-	messages, err := h.messageService.GetUserMessages(c.Request.Context(), userID, limit, offset)
+	page, err := h.messageService.GetUserMessages(c.Request.Context(), viewer, userID, c.Query("before"), c.Query("after"), limit)
+	if errors.Is(err, models.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, messages)
+	c.JSON(http.StatusOK, page)
 }
 
 func (h *MessageHandler) GetGroupMessages(c *gin.Context) {
 	groupID := c.Param("id")
+	viewer, _ := middleware.CurrentUserID(c)
 
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
 
-	messages, err := h.messageService.GetGroupMessages(c.Request.Context(), groupID, limit, offset)
+	page, err := h.messageService.GetGroupMessages(c.Request.Context(), viewer, groupID, c.Query("before"), c.Query("after"), limit)
+	if errors.Is(err, models.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, messages)
+	c.JSON(http.StatusOK, page)
 }
 
 func (h *MessageHandler) GetConversation(c *gin.Context) {
 	user1ID := c.Param("user1_id")
 	user2ID := c.Param("user2_id")
+	viewer, _ := middleware.CurrentUserID(c)
 
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
 
-	messages, err := h.messageService.GetConversation(c.Request.Context(), user1ID, user2ID, limit, offset)
+	page, err := h.messageService.GetConversation(c.Request.Context(), viewer, user1ID, user2ID, c.Query("before"), c.Query("after"), limit)
+	if errors.Is(err, models.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, messages)
+	c.JSON(http.StatusOK, page)
 }
 
 func (h *MessageHandler) MarkAsRead(c *gin.Context) {
-	messageID := c.Param("id")
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	err := h.messageService.MarkAsRead(c.Request.Context(), c.Param("id"), userID)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	case errors.Is(err, service.ErrNotParticipant):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "marked as read"})
+}
 
-	var input struct {
-		UserID string `json:"user_id" binding:"required"`
+// EditMessage replaces the content of one of the caller's messages
+func (h *MessageHandler) EditMessage(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
 	}
 
+	var input service.EditMessageInput
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if err := h.messageService.MarkAsRead(c.Request.Context(), messageID, input.UserID); err != nil {
+	message, err := h.messageService.EditMessage(c.Request.Context(), c.Param("id"), userID, input)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	case errors.Is(err, service.ErrNotSender), errors.Is(err, service.ErrEditWindowExpired),
+		errors.Is(err, service.ErrMessageDeleted):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, message)
+}
+
+// GetHistory returns the earlier versions of a message
+func (h *MessageHandler) GetHistory(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	history, err := h.messageService.GetMessageHistory(c.Request.Context(), c.Param("id"), userID)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	case errors.Is(err, service.ErrNotParticipant):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case err != nil:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "marked as read"})
+	c.JSON(http.StatusOK, history)
+}
+
+// GetReceipts lists who a message was delivered to and who read it
+func (h *MessageHandler) GetReceipts(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	receipts, err := h.messageService.GetReceipts(c.Request.Context(), c.Param("id"), userID)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	case errors.Is(err, service.ErrNotParticipant):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, receipts)
 }
 
+// DeleteMessage deletes a message for the caller (?scope=me, the default) or
+// for everyone (?scope=everyone)
 func (h *MessageHandler) DeleteMessage(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
 	messageID := c.Param("id")
+	scope := c.DefaultQuery("scope", service.DeleteForMe)
 
-	if err := h.messageService.DeleteMessage(c.Request.Context(), messageID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	err := h.messageService.DeleteMessage(c.Request.Context(), messageID, userID, scope)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	case errors.Is(err, service.ErrNotSender), errors.Is(err, service.ErrNotParticipant),
+		errors.Is(err, service.ErrDeleteWindowExpired):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "message deleted"})
 }
 
+// GetThread returns a page of the replies to a message
+func (h *MessageHandler) GetThread(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+	page, err := h.messageService.GetThread(c.Request.Context(), userID, c.Param("id"), c.Query("before"), c.Query("after"), limit)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	case errors.Is(err, service.ErrNotParticipant):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, models.ErrInvalidCursor):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// MarkThreadRead clears the caller's unread count for a thread
+func (h *MessageHandler) MarkThreadRead(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	state, err := h.messageService.MarkThreadRead(c.Request.Context(), userID, c.Param("id"))
+	h.respondThreadState(c, state, err)
+}
+
+// FollowThread makes the caller follow a thread
+func (h *MessageHandler) FollowThread(c *gin.Context) {
+	h.setFollowing(c, true)
+}
+
+// UnfollowThread makes the caller stop following a thread
+func (h *MessageHandler) UnfollowThread(c *gin.Context) {
+	h.setFollowing(c, false)
+}
+
+func (h *MessageHandler) setFollowing(c *gin.Context, following bool) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	state, err := h.messageService.FollowThread(c.Request.Context(), userID, c.Param("id"), following)
+	h.respondThreadState(c, state, err)
+}
+
+func (h *MessageHandler) respondThreadState(c *gin.Context, state *models.ThreadState, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	case errors.Is(err, service.ErrNotParticipant):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, state)
+}
+
+// AddReaction reacts to a message with an emoji
+func (h *MessageHandler) AddReaction(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var input service.ReactionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	reactions, err := h.messageService.AddReaction(c.Request.Context(), c.Param("id"), userID, input.Emoji)
+	h.respondReactions(c, reactions, err)
+}
+
+// RemoveReaction takes back the caller's reaction with an emoji
+func (h *MessageHandler) RemoveReaction(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	reactions, err := h.messageService.RemoveReaction(c.Request.Context(), c.Param("id"), userID, c.Param("emoji"))
+	h.respondReactions(c, reactions, err)
+}
+
+func (h *MessageHandler) respondReactions(c *gin.Context, reactions *service.MessageReactions, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	case errors.Is(err, service.ErrNotParticipant), errors.Is(err, service.ErrMessageDeleted):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, reactions)
+}
+
 // RegisterRoutes registers the message routes
 func (h *MessageHandler) RegisterRoutes(router *gin.RouterGroup) {
 	messages := router.Group("/messages")
@@ -133,6 +385,15 @@ func (h *MessageHandler) RegisterRoutes(router *gin.RouterGroup) {
 		messages.GET("/group/:id", h.GetGroupMessages)
 		messages.GET("/conversation/:user1_id/:user2_id", h.GetConversation)
 		messages.POST("/:id/read", h.MarkAsRead)
+		messages.GET("/:id/receipts", h.GetReceipts)
+		messages.PATCH("/:id", h.EditMessage)
+		messages.GET("/:id/history", h.GetHistory)
 		messages.DELETE("/:id", h.DeleteMessage)
+		messages.POST("/:id/reactions", h.AddReaction)
+		messages.DELETE("/:id/reactions/:emoji", h.RemoveReaction)
+		messages.GET("/:id/thread", h.GetThread)
+		messages.POST("/:id/thread/read", h.MarkThreadRead)
+		messages.PUT("/:id/thread/follow", h.FollowThread)
+		messages.DELETE("/:id/thread/follow", h.UnfollowThread)
 	}
 }
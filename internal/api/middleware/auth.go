@@ -5,6 +5,7 @@ import (
 
 	"github.com/chat-backend/internal/service"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 type AuthMiddleware struct {
@@ -51,3 +52,13 @@ func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 func GetUserID(c *gin.Context) (interface{}, bool) {
 	return c.Get("user_id")
 }
+
+// CurrentUserID returns the authenticated user's ID set by RequireAuth
+func CurrentUserID(c *gin.Context) (uuid.UUID, bool) {
+	value, ok := c.Get("user_id")
+	if !ok {
+		return uuid.Nil, false
+	}
+	userID, ok := value.(uuid.UUID)
+	return userID, ok
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/chat-backend/internal/api/middleware"
+	"github.com/chat-backend/internal/models"
+	"github.com/chat-backend/internal/service"
+)
+
+type SearchHandler struct {
+	searchService *service.SearchService
+}
+
+func NewSearchHandler(searchService *service.SearchService) *SearchHandler {
+	return &SearchHandler{
+		searchService: searchService,
+	}
+}
+
+// SearchMessages searches the messages of the caller's conversations
+func (h *SearchHandler) SearchMessages(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var input service.SearchInput
+	if err := c.ShouldBindQuery(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := h.searchService.SearchMessages(c.Request.Context(), userID, input)
+	switch {
+	case errors.Is(err, service.ErrInvalidSearch), errors.Is(err, models.ErrInvalidCursor):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, service.ErrNotParticipant):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// RegisterRoutes registers the search routes
+func (h *SearchHandler) RegisterRoutes(router *gin.RouterGroup) {
+	search := router.Group("/search")
+	{
+		search.GET("/messages", h.SearchMessages)
+	}
+}
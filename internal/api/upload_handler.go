@@ -0,0 +1,246 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/chat-backend/internal/api/middleware"
+	"github.com/chat-backend/internal/models"
+	"github.com/chat-backend/internal/repository"
+	"github.com/chat-backend/internal/service"
+)
+
+// tusVersion is the version of the tus resumable upload protocol spoken by
+// the upload routes
+const tusVersion = "1.0.0"
+
+// tusExtensions lists the tus protocol extensions the upload routes support
+const tusExtensions = "creation,checksum,expiration,termination"
+
+// chunkContentType is the content type tus requires for chunk requests
+const chunkContentType = "application/offset+octet-stream"
+
+// statusChecksumMismatch is the status tus uses for a chunk that does not
+// match its checksum
+const statusChecksumMismatch = 460
+
+// UploadHandler serves resumable uploads over the tus protocol
+// (https://tus.io/protocols/resumable-upload), so stock tus clients can use it
+type UploadHandler struct {
+	uploadService *service.UploadService
+}
+
+func NewUploadHandler(uploadService *service.UploadService) *UploadHandler {
+	return &UploadHandler{
+		uploadService: uploadService,
+	}
+}
+
+// Options describes the server's tus support
+func (h *UploadHandler) Options(c *gin.Context) {
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(h.uploadService.MaxSize(), 10))
+	c.Header("Tus-Checksum-Algorithm", strings.Join(service.ChecksumAlgorithms, ","))
+	c.Status(http.StatusNoContent)
+}
+
+// CreateUpload starts an upload of Upload-Length bytes. The filename and
+// type come from the filename and filetype keys of Upload-Metadata.
+func (h *UploadHandler) CreateUpload(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	size, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Upload-Length"})
+		return
+	}
+	metadata, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	upload, err := h.uploadService.CreateUpload(c.Request.Context(), service.CreateUploadInput{
+		OwnerID:     userID,
+		Filename:    firstNonEmpty(metadata["filename"], metadata["name"]),
+		ContentType: firstNonEmpty(metadata["filetype"], metadata["type"]),
+		Size:        size,
+	})
+	if respondUploadError(c, err) {
+		return
+	}
+
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+upload.ID.String())
+	setUploadHeaders(c, upload)
+	c.JSON(http.StatusCreated, upload)
+}
+
+// GetUpload reports how much of an upload has been received
+func (h *UploadHandler) GetUpload(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	upload, err := h.uploadService.GetUpload(c.Request.Context(), userID, c.Param("id"))
+	if respondUploadError(c, err) {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	setUploadHeaders(c, upload)
+	c.Status(http.StatusOK)
+}
+
+// WriteChunk appends the request body to an upload at Upload-Offset
+func (h *UploadHandler) WriteChunk(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	if c.ContentType() != chunkContentType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "chunks must be sent as " + chunkContentType})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Upload-Offset"})
+		return
+	}
+
+	// Never read more than the largest upload, whatever the client claims
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.uploadService.MaxSize())
+
+	upload, err := h.uploadService.WriteChunk(c.Request.Context(), userID, c.Param("id"), service.ChunkInput{
+		Offset:   offset,
+		Size:     c.Request.ContentLength,
+		Checksum: c.GetHeader("Upload-Checksum"),
+		Body:     c.Request.Body,
+	})
+	if respondUploadError(c, err) {
+		return
+	}
+
+	setUploadHeaders(c, upload)
+	c.Status(http.StatusNoContent)
+}
+
+// DeleteUpload abandons an upload
+func (h *UploadHandler) DeleteUpload(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	if respondUploadError(c, h.uploadService.DeleteUpload(c.Request.Context(), userID, c.Param("id"))) {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// setUploadHeaders reports an upload's progress in tus headers
+func setUploadHeaders(c *gin.Context, upload *models.Upload) {
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	if !upload.ExpiresAt.IsZero() {
+		c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// respondUploadError writes the response for a failed upload request and
+// reports whether there was one
+func respondUploadError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, repository.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload not found"})
+	case errors.Is(err, service.ErrOffsetMismatch):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrChecksumMismatch):
+		c.JSON(statusChecksumMismatch, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrAttachmentTooLarge),
+		errors.Is(err, service.ErrChunkTooLarge),
+		errors.Is(err, service.ErrQuotaExceeded):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrLengthRequired),
+		errors.Is(err, service.ErrInvalidChecksum):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+	return true
+}
+
+// parseUploadMetadata decodes a tus Upload-Metadata header: comma-separated
+// pairs of a key and a base64-encoded value
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("invalid Upload-Metadata")
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// firstNonEmpty returns the first of values that is not empty
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// tusResumable checks the protocol version of tus requests and stamps it on
+// every response
+func tusResumable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", tusVersion)
+		if c.Request.Method != http.MethodOptions {
+			if version := c.GetHeader("Tus-Resumable"); version != "" && version != tusVersion {
+				c.Header("Tus-Version", tusVersion)
+				c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "unsupported tus version"})
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// RegisterRoutes registers the resumable upload routes
+func (h *UploadHandler) RegisterRoutes(router *gin.RouterGroup) {
+	uploads := router.Group("/attachments/uploads")
+	uploads.Use(tusResumable())
+	{
+		uploads.OPTIONS("", h.Options)
+		uploads.POST("", h.CreateUpload)
+		uploads.HEAD("/:id", h.GetUpload)
+		uploads.PATCH("/:id", h.WriteChunk)
+		uploads.DELETE("/:id", h.DeleteUpload)
+	}
+}
@@ -1,11 +1,11 @@
 package api
 
 import (
-	"context"
 	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
 
@@ -17,6 +17,7 @@ type WebSocketHandler struct {
 	wsManager      *wsmanager.Manager
 	userService    *service.UserService
 	messageService *service.MessageService
+	upgrader       websocket.Upgrader
 }
 
 func NewWebSocketHandler(
@@ -24,22 +25,22 @@ func NewWebSocketHandler(
 	userService *service.UserService,
 	messageService *service.MessageService,
 ) *WebSocketHandler {
+	config := wsManager.Config()
 	return &WebSocketHandler{
 		wsManager:      wsManager,
 		userService:    userService,
 		messageService: messageService,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  config.ReadBufferSize,
+			WriteBufferSize: config.WriteBufferSize,
+			CheckOrigin: func(r *http.Request) bool {
+				// TODO: Implement proper origin check
+				return true
+			},
+		},
 	}
 }
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		// TODO: Implement proper origin check
-		return true
-	},
-}
-
 func (h *WebSocketHandler) HandleConnection(c *gin.Context) {
 	// Get user ID from auth token
 	userID, err := h.getUserIDFromToken(c)
@@ -53,48 +54,43 @@ func (h *WebSocketHandler) HandleConnection(c *gin.Context) {
 	}).Info("WebSocket connection request received")
 
 	// Upgrade HTTP connection to WebSocket
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		logrus.WithError(err).Error("Error upgrading connection")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not upgrade connection"})
 		return
 	}
 
-	// Create new client
+	// Create new client, resuming from the optional since cursor
 	client := &wsmanager.Client{
-		ID:      userID,
-		Conn:    conn,
-		Send:    make(chan []byte, 256),
-		Manager: h.wsManager,
+		ID:       userID,
+		ConnID:   uuid.New().String(),
+		DeviceID: c.Query("device_id"),
+		Conn:     conn,
+		Send:     make(chan []byte, 256),
+		Manager:  h.wsManager,
+		Since:    c.Query("since"),
 	}
 
 	// Set connection close handler
 	conn.SetCloseHandler(func(code int, text string) error {
 		logrus.WithFields(logrus.Fields{
 			"user_id": userID,
+			"conn_id": client.ConnID,
 			"code":    code,
 			"text":    text,
 		}).Info("WebSocket connection closed by client")
-
-		// Update user status to offline
-		bgCtx := context.Background()
-		if err := h.userService.UpdateStatus(bgCtx, userID, "offline"); err != nil {
-			logrus.WithError(err).Error("Failed to update user status to offline")
-		}
 		return nil
 	})
 
-	// Register client and start handlers
+	// Register client and start handlers. The manager marks the user online
+	// on their first connection and offline when the last one closes.
 	h.wsManager.HandleClient(client)
 
-	// Update user status to online
-	bgCtx := context.Background()
-	if err := h.userService.UpdateStatus(bgCtx, userID, "online"); err != nil {
-		logrus.WithError(err).Error("Failed to update user status to online")
-	}
-
 	logrus.WithFields(logrus.Fields{
-		"user_id": userID,
+		"user_id":   userID,
+		"conn_id":   client.ConnID,
+		"device_id": client.DeviceID,
 	}).Info("WebSocket connection established successfully")
 }
 
@@ -8,8 +8,10 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/gocql/gocql"
 	"github.com/sirupsen/logrus"
 	"github.com/sourcegraph/conc"
+	"github.com/spf13/viper"
 
 	"github.com/chat-backend/internal/migrations"
 )
@@ -56,14 +58,43 @@ func New() (*App, error) {
 		return nil, err
 	}
 
+	// Cassandra is only used when it stores messages
+	var cassandraConfig *migrations.CassandraConfig
+	if viper.GetString("messages.backend") == "cassandra" {
+		cluster, err := newCassandraCluster()
+		if err != nil {
+			return nil, err
+		}
+		replication, err := cassandraReplication()
+		if err != nil {
+			return nil, err
+		}
+		cassandraConfig = &migrations.CassandraConfig{
+			Cluster:     cluster,
+			Replication: replication,
+		}
+	}
+
 	// Run migrations
-	migrator := migrations.NewMigrator(logger, db)
+	migrator := migrations.NewMigrator(logger, db, cassandraConfig)
 	if err := migrator.RunMigrations(context.Background()); err != nil {
 		return nil, fmt.Errorf("failed to run migrations: %w", err)
 	}
 
+	// Connect to the keyspace once migrations have created it
+	var cassandraSession *gocql.Session
+	if cassandraConfig != nil {
+		cassandraSession, err = cassandraConfig.Cluster.CreateSession()
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to cassandra: %w", err)
+		}
+	}
+
 	// Initialize repositories
-	repos := initRepositories(db, redisClient)
+	repos, err := initRepositories(db, redisClient, cassandraSession)
+	if err != nil {
+		return nil, err
+	}
 
 	// Initialize services
 	services, err := initServices(repos, logger, rabbitmqChan, firebaseApp, redisClient)
@@ -80,6 +111,10 @@ func New() (*App, error) {
 		handlers.userHandler,
 		handlers.groupHandler,
 		handlers.messageHandler,
+		handlers.conversationHandler,
+		handlers.searchHandler,
+		handlers.attachmentHandler,
+		handlers.uploadHandler,
 		handlers.wsHandler,
 		handlers.authMiddleware,
 		handlers.healthHandler,
@@ -111,6 +146,12 @@ func (a *App) Start() error {
 		}
 	})
 
+	// Start upload janitor
+	a.wg.Go(func() {
+		a.logger.Info("Starting upload janitor")
+		a.services.uploadService.RunJanitor(ctx)
+	})
+
 	// Start HTTP server
 	a.wg.Go(func() {
 		a.logger.Info("Starting HTTP server")
@@ -12,6 +12,16 @@ func loadConfig() error {
 	// Set defaults
 	viper.SetDefault("server.port", "8080")
 	viper.SetDefault("server.shutdown_timeout", "30s")
+	viper.SetDefault("messages.backend", "postgres")
+	viper.SetDefault("messages.edit_window", "15m")
+	viper.SetDefault("messages.delete_window", "1h")
+	viper.SetDefault("attachments.backend", "filesystem")
+	viper.SetDefault("attachments.max_size", 25<<20)
+	viper.SetDefault("attachments.filesystem.root", "data/attachments")
+	viper.SetDefault("attachments.user_quota", 2<<30)
+	viper.SetDefault("attachments.uploads.max_size", 1<<30)
+	viper.SetDefault("attachments.uploads.expiry", "24h")
+	viper.SetDefault("attachments.uploads.janitor_interval", "10m")
 
 	return viper.ReadInConfig()
 }
@@ -1,12 +1,17 @@
 package app
 
 import (
+	"context"
 	"fmt"
+	"strconv"
 
+	"github.com/gocql/gocql"
 	redis "github.com/redis/go-redis/v9"
 	"github.com/spf13/viper"
 	pgdriver "gorm.io/driver/postgres"
 	"gorm.io/gorm"
+
+	"github.com/chat-backend/internal/repository/mongodb"
 )
 
 func initPostgres() (*gorm.DB, error) {
@@ -44,3 +49,63 @@ func initRedis() *redis.Client {
 		PoolSize: viper.GetInt("redis.pool_size"),
 	})
 }
+
+func newCassandraCluster() (*gocql.ClusterConfig, error) {
+	cluster := gocql.NewCluster(viper.GetStringSlice("cassandra.hosts")...)
+	cluster.Keyspace = viper.GetString("cassandra.keyspace")
+	cluster.Timeout = viper.GetDuration("cassandra.timeout")
+	cluster.ConnectTimeout = viper.GetDuration("cassandra.connect_timeout")
+
+	if consistency := viper.GetString("cassandra.consistency"); consistency != "" {
+		level, err := gocql.ParseConsistencyWrapper(consistency)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cassandra consistency: %w", err)
+		}
+		cluster.Consistency = level
+	}
+
+	cluster.RetryPolicy = &gocql.ExponentialBackoffRetryPolicy{
+		NumRetries: viper.GetInt("cassandra.retry_policy.num_retries"),
+		Min:        viper.GetDuration("cassandra.retry_policy.min_duration"),
+		Max:        viper.GetDuration("cassandra.retry_policy.max_duration"),
+	}
+
+	return cluster, nil
+}
+
+// cassandraReplication builds the keyspace replication map from config.
+// SimpleStrategy uses replication_factor; NetworkTopologyStrategy lists a
+// replication factor per datacenter.
+func cassandraReplication() (map[string]string, error) {
+	class := viper.GetString("cassandra.replication.class")
+	replication := map[string]string{"class": class}
+
+	switch class {
+	case "SimpleStrategy":
+		replication["replication_factor"] = strconv.Itoa(viper.GetInt("cassandra.replication.replication_factor"))
+
+	case "NetworkTopologyStrategy":
+		var datacenters []struct {
+			Name              string `mapstructure:"name"`
+			ReplicationFactor int    `mapstructure:"replication_factor"`
+		}
+		if err := viper.UnmarshalKey("cassandra.replication.datacenters", &datacenters); err != nil {
+			return nil, fmt.Errorf("invalid cassandra datacenters: %w", err)
+		}
+		if len(datacenters) == 0 {
+			return nil, fmt.Errorf("cassandra.replication.datacenters is required for NetworkTopologyStrategy")
+		}
+		for _, dc := range datacenters {
+			replication[dc.Name] = strconv.Itoa(dc.ReplicationFactor)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported cassandra replication class %q", class)
+	}
+
+	return replication, nil
+}
+
+func initMongo(ctx context.Context) (*mongodb.DB, error) {
+	return mongodb.NewDB(ctx, viper.GetString("mongodb.uri"), viper.GetString("mongodb.database"))
+}
@@ -6,21 +6,29 @@ import (
 )
 
 type handlers struct {
-	userHandler    *api.UserHandler
-	groupHandler   *api.GroupHandler
-	messageHandler *api.MessageHandler
-	wsHandler      *api.WebSocketHandler
-	authMiddleware *middleware.AuthMiddleware
-	healthHandler  *api.HealthHandler
+	userHandler         *api.UserHandler
+	groupHandler        *api.GroupHandler
+	messageHandler      *api.MessageHandler
+	conversationHandler *api.ConversationHandler
+	searchHandler       *api.SearchHandler
+	attachmentHandler   *api.AttachmentHandler
+	uploadHandler       *api.UploadHandler
+	wsHandler           *api.WebSocketHandler
+	authMiddleware      *middleware.AuthMiddleware
+	healthHandler       *api.HealthHandler
 }
 
 func initHandlers(services *services) *handlers {
 	return &handlers{
-		userHandler:    api.NewUserHandler(services.userService),
-		groupHandler:   api.NewGroupHandler(services.groupService),
-		messageHandler: api.NewMessageHandler(services.messageService),
-		wsHandler:      api.NewWebSocketHandler(services.wsManager, services.userService, services.messageService),
-		authMiddleware: middleware.NewAuthMiddleware(services.userService),
-		healthHandler:  api.NewHealthHandler(),
+		userHandler:         api.NewUserHandler(services.userService),
+		groupHandler:        api.NewGroupHandler(services.groupService),
+		messageHandler:      api.NewMessageHandler(services.messageService),
+		conversationHandler: api.NewConversationHandler(services.messageService),
+		searchHandler:       api.NewSearchHandler(services.searchService),
+		attachmentHandler:   api.NewAttachmentHandler(services.attachmentService),
+		uploadHandler:       api.NewUploadHandler(services.uploadService),
+		wsHandler:           api.NewWebSocketHandler(services.wsManager, services.userService, services.messageService),
+		authMiddleware:      middleware.NewAuthMiddleware(services.userService),
+		healthHandler:       api.NewHealthHandler(),
 	}
 }
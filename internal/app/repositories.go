@@ -1,26 +1,120 @@
 package app
 
 import (
+	"context"
+	"fmt"
+
+	"github.com/gocql/gocql"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
 	"gorm.io/gorm"
 
 	"github.com/chat-backend/internal/repository"
+	"github.com/chat-backend/internal/repository/cassandra"
+	"github.com/chat-backend/internal/repository/filesystem"
+	"github.com/chat-backend/internal/repository/mongodb"
 	"github.com/chat-backend/internal/repository/postgres"
 	redisrepo "github.com/chat-backend/internal/repository/redis"
+	"github.com/chat-backend/internal/repository/s3"
 )
 
 type repositories struct {
-	userRepo    repository.UserRepository
-	groupRepo   repository.GroupRepository
-	messageRepo repository.MessageRepository
-	statusRepo  repository.StatusRepository
+	userRepo         repository.UserRepository
+	groupRepo        repository.GroupRepository
+	messageRepo      repository.MessageRepository
+	conversationRepo repository.ConversationRepository
+	unreadRepo       repository.UnreadRepository
+	revisionRepo     repository.RevisionRepository
+	reactionRepo     repository.ReactionRepository
+	threadRepo       repository.ThreadRepository
+	searchIndex      repository.SearchIndex
+	attachmentRepo   repository.AttachmentRepository
+	uploadRepo       repository.UploadRepository
+	blobStore        repository.BlobStore
+	statusRepo       repository.StatusRepository
 }
 
-func initRepositories(db *gorm.DB, redisClient *redis.Client) *repositories {
+func initRepositories(db *gorm.DB, redisClient *redis.Client, cassandraSession *gocql.Session) (*repositories, error) {
+	messageRepo, err := initMessageRepository(db, cassandraSession)
+	if err != nil {
+		return nil, err
+	}
+	blobStore, err := initBlobStore()
+	if err != nil {
+		return nil, err
+	}
+
 	return &repositories{
-		userRepo:    postgres.NewUserRepository(db),
-		groupRepo:   postgres.NewGroupRepository(db),
-		messageRepo: postgres.NewMessageRepository(db),
-		statusRepo:  redisrepo.NewStatusRepository(redisClient),
+		userRepo:         postgres.NewUserRepository(db),
+		groupRepo:        redisrepo.NewCachedGroupRepository(postgres.NewGroupRepository(db), redisClient),
+		messageRepo:      messageRepo,
+		conversationRepo: postgres.NewConversationRepository(db),
+		unreadRepo:       redisrepo.NewUnreadRepository(redisClient),
+		revisionRepo:     postgres.NewRevisionRepository(db),
+		reactionRepo:     postgres.NewReactionRepository(db),
+		threadRepo:       postgres.NewThreadRepository(db),
+		searchIndex:      postgres.NewSearchIndex(db),
+		attachmentRepo:   postgres.NewAttachmentRepository(db),
+		uploadRepo:       postgres.NewUploadRepository(db),
+		blobStore:        blobStore,
+		statusRepo:       redisrepo.NewStatusRepository(redisClient, viper.GetDuration("presence.status_ttl")),
+	}, nil
+}
+
+// initMessageRepository connects the message store selected by messages.backend
+func initMessageRepository(db *gorm.DB, cassandraSession *gocql.Session) (repository.MessageRepository, error) {
+	switch backend := viper.GetString("messages.backend"); backend {
+	case "postgres":
+		return postgres.NewMessageRepository(db), nil
+
+	case "cassandra":
+		return cassandra.NewMessageRepository(cassandraSession), nil
+
+	case "mongodb":
+		ctx := context.Background()
+		mongoDB, err := initMongo(ctx)
+		if err != nil {
+			return nil, err
+		}
+		messageRepo := mongodb.NewMessageRepository(mongoDB.GetDatabase())
+		if err := messageRepo.EnsureIndexes(ctx); err != nil {
+			return nil, err
+		}
+		return messageRepo, nil
+
+	default:
+		return nil, fmt.Errorf("unknown messages.backend %q", backend)
+	}
+}
+
+// initBlobStore opens the attachment store selected by attachments.backend
+func initBlobStore() (repository.BlobStore, error) {
+	switch backend := viper.GetString("attachments.backend"); backend {
+	case "filesystem":
+		return filesystem.NewBlobStore(viper.GetString("attachments.filesystem.root")), nil
+
+	case "s3":
+		client, err := minio.New(viper.GetString("attachments.s3.endpoint"), &minio.Options{
+			Creds: credentials.NewStaticV4(
+				viper.GetString("attachments.s3.access_key"),
+				viper.GetString("attachments.s3.secret_key"),
+				"",
+			),
+			Secure: viper.GetBool("attachments.s3.use_ssl"),
+			Region: viper.GetString("attachments.s3.region"),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create S3 client: %w", err)
+		}
+		blobStore := s3.NewBlobStore(client, viper.GetString("attachments.s3.bucket"))
+		if err := blobStore.EnsureBucket(context.Background(), viper.GetString("attachments.s3.region")); err != nil {
+			return nil, err
+		}
+		return blobStore, nil
+
+	default:
+		return nil, fmt.Errorf("unknown attachments.backend %q", backend)
 	}
 }
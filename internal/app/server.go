@@ -23,6 +23,10 @@ func NewServer(
 	userHandler *api.UserHandler,
 	groupHandler *api.GroupHandler,
 	messageHandler *api.MessageHandler,
+	conversationHandler *api.ConversationHandler,
+	searchHandler *api.SearchHandler,
+	attachmentHandler *api.AttachmentHandler,
+	uploadHandler *api.UploadHandler,
 	wsHandler *api.WebSocketHandler,
 	authMiddleware *middleware.AuthMiddleware,
 	healthHandler *api.HealthHandler,
@@ -42,6 +46,10 @@ func NewServer(
 		{
 			groupHandler.RegisterRoutes(protected)
 			messageHandler.RegisterRoutes(protected)
+			conversationHandler.RegisterRoutes(protected)
+			searchHandler.RegisterRoutes(protected)
+			attachmentHandler.RegisterRoutes(protected)
+			uploadHandler.RegisterRoutes(protected)
 			wsHandler.RegisterRoutes(protected)
 		}
 	}
@@ -15,16 +15,69 @@ type services struct {
 	userService         *service.UserService
 	groupService        *service.GroupService
 	messageService      *service.MessageService
+	searchService       *service.SearchService
+	attachmentService   *service.AttachmentService
+	uploadService       *service.UploadService
 	notificationService *service.NotificationService
 	wsManager           *websocket.Manager
 }
 
 func initServices(repos *repositories, logger *logrus.Logger, rabbitmqChan *amqp.Channel, firebaseApp *firebase.App, redisClient *redis.Client) (*services, error) {
-	wsManager := websocket.NewManager(logger, redisClient)
+	wsManager := websocket.NewManager(logger, redisClient, repos.groupRepo, websocket.Config{
+		ReadBufferSize:  viper.GetInt("websocket.read_buffer_size"),
+		WriteBufferSize: viper.GetInt("websocket.write_buffer_size"),
+		PingPeriod:      viper.GetDuration("websocket.ping_period"),
+		PongWait:        viper.GetDuration("websocket.pong_wait"),
+		WriteWait:       viper.GetDuration("websocket.write_wait"),
+		MaxMessageSize:  viper.GetInt64("websocket.max_message_size"),
+		TypingTimeout:   viper.GetDuration("websocket.typing_timeout"),
+		AwayAfter:       viper.GetDuration("presence.away_after"),
+	})
 
 	userService := service.NewUserService(repos.userRepo, repos.statusRepo, viper.GetString("jwt.secret"))
+	wsManager.SetPresenceHandler(userService)
 	groupService := service.NewGroupService(repos.groupRepo, repos.userRepo)
-	messageService := service.NewMessageService(repos.messageRepo, repos.userRepo, repos.groupRepo, wsManager)
+	messageService := service.NewMessageService(
+		repos.messageRepo,
+		repos.userRepo,
+		repos.groupRepo,
+		repos.conversationRepo,
+		repos.unreadRepo,
+		repos.revisionRepo,
+		repos.reactionRepo,
+		repos.attachmentRepo,
+		repos.threadRepo,
+		repos.searchIndex,
+		wsManager,
+		service.MessageConfig{
+			EditWindow:   viper.GetDuration("messages.edit_window"),
+			DeleteWindow: viper.GetDuration("messages.delete_window"),
+		},
+	)
+	wsManager.SetMessageHandler(messageService)
+	searchService := service.NewSearchService(repos.searchIndex, repos.groupRepo)
+	attachmentService := service.NewAttachmentService(
+		repos.attachmentRepo,
+		repos.uploadRepo,
+		repos.blobStore,
+		repos.messageRepo,
+		repos.groupRepo,
+		service.AttachmentConfig{
+			MaxSize:   viper.GetInt64("attachments.max_size"),
+			UserQuota: viper.GetInt64("attachments.user_quota"),
+		},
+	)
+	uploadService := service.NewUploadService(
+		repos.uploadRepo,
+		repos.attachmentRepo,
+		repos.blobStore,
+		attachmentService,
+		service.UploadConfig{
+			MaxSize:         viper.GetInt64("attachments.uploads.max_size"),
+			Expiry:          viper.GetDuration("attachments.uploads.expiry"),
+			JanitorInterval: viper.GetDuration("attachments.uploads.janitor_interval"),
+		},
+	)
 
 	notificationService, err := service.NewNotificationService(
 		firebaseApp,
@@ -41,6 +94,9 @@ func initServices(repos *repositories, logger *logrus.Logger, rabbitmqChan *amqp
 		userService:         userService,
 		groupService:        groupService,
 		messageService:      messageService,
+		searchService:       searchService,
+		attachmentService:   attachmentService,
+		uploadService:       uploadService,
 		notificationService: notificationService,
 		wsManager:           wsManager,
 	}, nil
@@ -0,0 +1,380 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+
+	schema "github.com/chat-backend/migrations"
+)
+
+// How long to wait for another instance to finish applying a migration
+const cassandraLockWait = 2 * time.Minute
+
+var (
+	cassandraMigrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.up\.cql$`)
+	cqlIdentifier          = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// CassandraConfig describes the keyspace the Cassandra migrations manage
+type CassandraConfig struct {
+	// Cluster is used to connect; its Keyspace is the one created and migrated
+	Cluster *gocql.ClusterConfig
+	// Replication is the keyspace replication map, e.g.
+	// {"class": "NetworkTopologyStrategy", "dc1": "3"}
+	Replication map[string]string
+	// Migrations holds the *.up.cql files; nil uses the ones built into the binary
+	Migrations fs.FS
+}
+
+type cassandraMigration struct {
+	version int64
+	name    string
+	file    string
+}
+
+// runCassandraMigrations creates or updates the keyspace, then applies every
+// migration newer than the version recorded in schema_version
+func (m *Migrator) runCassandraMigrations(ctx context.Context) error {
+	m.logger.Info("Running Cassandra migrations")
+
+	keyspace := m.cassandra.Cluster.Keyspace
+	if !cqlIdentifier.MatchString(keyspace) {
+		return fmt.Errorf("invalid keyspace name %q", keyspace)
+	}
+
+	// The keyspace may not exist yet, so create it without using one
+	if err := m.ensureKeyspace(ctx, keyspace); err != nil {
+		return err
+	}
+
+	session, err := m.cassandra.Cluster.CreateSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	if err := session.Query(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version bigint PRIMARY KEY,
+			name text,
+			dirty boolean,
+			applied_at timestamp
+		)`).WithContext(ctx).Exec(); err != nil {
+		return fmt.Errorf("failed to create schema_version: %w", err)
+	}
+
+	files := m.cassandra.Migrations
+	if files == nil {
+		if files, err = fs.Sub(schema.Cassandra, "cassandra"); err != nil {
+			return err
+		}
+	}
+	migrations, err := loadCassandraMigrations(files)
+	if err != nil {
+		return err
+	}
+
+	applied, err := m.appliedVersions(ctx, session)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		dirty, ok := applied[migration.version]
+		if ok && !dirty {
+			continue
+		}
+		if ok && dirty {
+			// Another instance may be applying it right now
+			if err := m.waitForMigration(ctx, session, migration); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := m.applyMigration(ctx, session, files, migration); err != nil {
+			return err
+		}
+	}
+
+	m.logger.Info("Cassandra migrations completed")
+	return nil
+}
+
+// ensureKeyspace creates the keyspace with the configured replication, or
+// alters an existing keyspace whose replication no longer matches
+func (m *Migrator) ensureKeyspace(ctx context.Context, keyspace string) error {
+	replication, err := replicationCQL(m.cassandra.Replication)
+	if err != nil {
+		return err
+	}
+
+	cluster := *m.cassandra.Cluster
+	cluster.Keyspace = ""
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	var current map[string]string
+	err = session.Query(`SELECT replication FROM system_schema.keyspaces WHERE keyspace_name = ?`, keyspace).
+		WithContext(ctx).Scan(&current)
+	if err == gocql.ErrNotFound {
+		m.logger.WithField("keyspace", keyspace).Info("Creating Cassandra keyspace")
+		return session.Query(`CREATE KEYSPACE IF NOT EXISTS ` + keyspace + ` WITH replication = ` + replication).
+			WithContext(ctx).Exec()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read keyspace: %w", err)
+	}
+
+	if sameReplication(current, m.cassandra.Replication) {
+		return nil
+	}
+
+	m.logger.WithField("keyspace", keyspace).Warn("Changing Cassandra keyspace replication; run a full repair afterwards")
+	return session.Query(`ALTER KEYSPACE ` + keyspace + ` WITH replication = ` + replication).
+		WithContext(ctx).Exec()
+}
+
+func (m *Migrator) appliedVersions(ctx context.Context, session *gocql.Session) (map[int64]bool, error) {
+	iter := session.Query(`SELECT version, dirty FROM schema_version`).WithContext(ctx).Iter()
+
+	applied := make(map[int64]bool)
+	var version int64
+	var dirty bool
+	for iter.Scan(&version, &dirty) {
+		applied[version] = dirty
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to read schema_version: %w", err)
+	}
+	return applied, nil
+}
+
+// applyMigration claims the version with a lightweight transaction so that
+// only one instance runs it, then marks it clean once every statement succeeded
+func (m *Migrator) applyMigration(ctx context.Context, session *gocql.Session, files fs.FS, migration cassandraMigration) error {
+	claimed, err := session.Query(`
+		INSERT INTO schema_version (version, name, dirty, applied_at)
+		VALUES (?, ?, true, ?)
+		IF NOT EXISTS`,
+		migration.version, migration.name, time.Now(),
+	).WithContext(ctx).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return fmt.Errorf("failed to claim migration %d: %w", migration.version, err)
+	}
+	if !claimed {
+		return m.waitForMigration(ctx, session, migration)
+	}
+
+	m.logger.WithField("version", migration.version).Infof("Applying Cassandra migration %s", migration.name)
+
+	data, err := fs.ReadFile(files, migration.file)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range splitCQL(string(data)) {
+		if err := session.Query(stmt).WithContext(ctx).Exec(); err != nil {
+			return fmt.Errorf("migration %d (%s) left dirty: %w", migration.version, migration.name, err)
+		}
+	}
+
+	return session.Query(`
+		UPDATE schema_version
+		SET dirty = false, applied_at = ?
+		WHERE version = ?`,
+		time.Now(), migration.version,
+	).WithContext(ctx).Exec()
+}
+
+// waitForMigration waits for a migration another instance is applying. A
+// migration that stays dirty failed part way and has to be fixed by hand.
+func (m *Migrator) waitForMigration(ctx context.Context, session *gocql.Session, migration cassandraMigration) error {
+	deadline := time.Now().Add(cassandraLockWait)
+	for {
+		var dirty bool
+		err := session.Query(`SELECT dirty FROM schema_version WHERE version = ?`, migration.version).
+			WithContext(ctx).Scan(&dirty)
+		if err != nil {
+			return fmt.Errorf("failed to check migration %d: %w", migration.version, err)
+		}
+		if !dirty {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("migration %d (%s) is dirty; fix the schema and clear the flag in schema_version", migration.version, migration.name)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// loadCassandraMigrations lists the up migrations ordered by version
+func loadCassandraMigrations(files fs.FS) ([]cassandraMigration, error) {
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []cassandraMigration
+	seen := make(map[int64]string)
+	for _, entry := range entries {
+		match := cassandraMigrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("duplicate migration version %d: %s and %s", version, other, entry.Name())
+		}
+		seen[version] = entry.Name()
+
+		migrations = append(migrations, cassandraMigration{
+			version: version,
+			name:    match[2],
+			file:    entry.Name(),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+// splitCQL splits a migration file into statements and drops the comments.
+// Semicolons inside string literals, quoted identifiers and comments do not
+// end a statement.
+func splitCQL(script string) []string {
+	var stmts []string
+	var stmt strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(stmt.String()); s != "" {
+			stmts = append(stmts, s)
+		}
+		stmt.Reset()
+	}
+
+	for i := 0; i < len(script); {
+		rest := script[i:]
+		switch {
+		case strings.HasPrefix(rest, "--"), strings.HasPrefix(rest, "//"):
+			// The line break is kept so the next line is not glued on
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			i += end
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += 4
+			}
+			stmt.WriteByte(' ')
+			i += end
+		case strings.HasPrefix(rest, "$$"):
+			end := strings.Index(rest[2:], "$$")
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += 4
+			}
+			stmt.WriteString(rest[:end])
+			i += end
+		case rest[0] == '\'' || rest[0] == '"':
+			end := quotedLen(rest)
+			stmt.WriteString(rest[:end])
+			i += end
+		case rest[0] == ';':
+			flush()
+			i++
+		default:
+			stmt.WriteByte(rest[0])
+			i++
+		}
+	}
+	flush()
+	return stmts
+}
+
+// quotedLen returns the length of the string literal or quoted identifier
+// at the start of s; a doubled quote inside it is an escaped quote
+func quotedLen(s string) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		if s[i] != quote {
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == quote {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return len(s)
+}
+
+// replicationCQL renders a replication map as a CQL map literal
+func replicationCQL(replication map[string]string) (string, error) {
+	if replication["class"] == "" {
+		return "", errors.New("keyspace replication class is required")
+	}
+
+	keys := make([]string, 0, len(replication))
+	for key := range replication {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := make([]string, len(keys))
+	for i, key := range keys {
+		entries[i] = fmt.Sprintf("%s: %s", quoteCQL(key), quoteCQL(replication[key]))
+	}
+	return "{" + strings.Join(entries, ", ") + "}", nil
+}
+
+func quoteCQL(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// sameReplication compares replication maps, ignoring the package prefix
+// Cassandra adds to the strategy class
+func sameReplication(current, wanted map[string]string) bool {
+	if len(current) != len(wanted) {
+		return false
+	}
+	for key, value := range wanted {
+		got, ok := current[key]
+		if !ok {
+			return false
+		}
+		if key == "class" {
+			got = got[strings.LastIndex(got, ".")+1:]
+			value = value[strings.LastIndex(value, ".")+1:]
+		}
+		if got != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,196 @@
+package migrations
+
+import (
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	schema "github.com/chat-backend/migrations"
+)
+
+func TestSplitCQL(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{name: "empty", script: "", want: nil},
+		{
+			name:   "statements",
+			script: "CREATE TABLE a (id int PRIMARY KEY);\nCREATE TABLE b (id int PRIMARY KEY);\n",
+			want:   []string{"CREATE TABLE a (id int PRIMARY KEY)", "CREATE TABLE b (id int PRIMARY KEY)"},
+		},
+		{
+			name:   "no trailing semicolon",
+			script: "DROP TABLE a;\nDROP TABLE b",
+			want:   []string{"DROP TABLE a", "DROP TABLE b"},
+		},
+		{
+			name:   "comment lines",
+			script: "-- first; not a statement\nDROP TABLE a;\n// second; also not\nDROP TABLE b;",
+			want:   []string{"DROP TABLE a", "DROP TABLE b"},
+		},
+		{
+			name:   "trailing comment",
+			script: "DROP TABLE a; -- drops a; and nothing else\nDROP TABLE b;",
+			want:   []string{"DROP TABLE a", "DROP TABLE b"},
+		},
+		{
+			name:   "block comment",
+			script: "DROP /* a; b */TABLE a;",
+			want:   []string{"DROP  TABLE a"},
+		},
+		{
+			name:   "semicolon in string",
+			script: "INSERT INTO a (id, v) VALUES (1, 'x;y');DROP TABLE b;",
+			want:   []string{"INSERT INTO a (id, v) VALUES (1, 'x;y')", "DROP TABLE b"},
+		},
+		{
+			name:   "escaped quote in string",
+			script: "INSERT INTO a (v) VALUES ('it''s; -- fine');",
+			want:   []string{"INSERT INTO a (v) VALUES ('it''s; -- fine')"},
+		},
+		{
+			name:   "comment markers in string",
+			script: "INSERT INTO a (v) VALUES ('http://x/*y');",
+			want:   []string{"INSERT INTO a (v) VALUES ('http://x/*y')"},
+		},
+		{
+			name:   "dollar string",
+			script: "CREATE FUNCTION f() RETURNS NULL ON NULL INPUT RETURNS int LANGUAGE java AS $$ return 1; $$;",
+			want:   []string{"CREATE FUNCTION f() RETURNS NULL ON NULL INPUT RETURNS int LANGUAGE java AS $$ return 1; $$"},
+		},
+		{
+			name:   "quoted identifier",
+			script: `CREATE TABLE "odd;name" (id int PRIMARY KEY);`,
+			want:   []string{`CREATE TABLE "odd;name" (id int PRIMARY KEY)`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitCQL(tt.script); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplicationCQL(t *testing.T) {
+	tests := []struct {
+		name        string
+		replication map[string]string
+		want        string
+		wantErr     bool
+	}{
+		{name: "no class", replication: map[string]string{"replication_factor": "1"}, wantErr: true},
+		{
+			name:        "simple",
+			replication: map[string]string{"class": "SimpleStrategy", "replication_factor": "1"},
+			want:        "{'class': 'SimpleStrategy', 'replication_factor': '1'}",
+		},
+		{
+			name:        "sorted datacenters",
+			replication: map[string]string{"dc2": "2", "class": "NetworkTopologyStrategy", "dc1": "3"},
+			want:        "{'class': 'NetworkTopologyStrategy', 'dc1': '3', 'dc2': '2'}",
+		},
+		{
+			name:        "quotes escaped",
+			replication: map[string]string{"class": "NetworkTopologyStrategy", "dc'1": "3"},
+			want:        "{'class': 'NetworkTopologyStrategy', 'dc''1': '3'}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := replicationCQL(tt.replication)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("replicationCQL: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSameReplication(t *testing.T) {
+	wanted := map[string]string{"class": "NetworkTopologyStrategy", "dc1": "3"}
+
+	tests := []struct {
+		name    string
+		current map[string]string
+		want    bool
+	}{
+		{name: "equal", current: map[string]string{"class": "NetworkTopologyStrategy", "dc1": "3"}, want: true},
+		{name: "class with package", current: map[string]string{"class": "org.apache.cassandra.locator.NetworkTopologyStrategy", "dc1": "3"}, want: true},
+		{name: "other class", current: map[string]string{"class": "org.apache.cassandra.locator.SimpleStrategy", "dc1": "3"}},
+		{name: "other factor", current: map[string]string{"class": "NetworkTopologyStrategy", "dc1": "1"}},
+		{name: "extra datacenter", current: map[string]string{"class": "NetworkTopologyStrategy", "dc1": "3", "dc2": "3"}},
+		{name: "other datacenter", current: map[string]string{"class": "NetworkTopologyStrategy", "dc2": "3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameReplication(tt.current, wanted); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadCassandraMigrations(t *testing.T) {
+	files := fstest.MapFS{
+		"000010_later.up.cql":   {Data: []byte("DROP TABLE b;")},
+		"000002_first.up.cql":   {Data: []byte("DROP TABLE a;")},
+		"000002_first.down.cql": {Data: []byte("CREATE TABLE a (id int PRIMARY KEY);")},
+		"README.md":             {Data: []byte("notes")},
+	}
+
+	migrations, err := loadCassandraMigrations(files)
+	if err != nil {
+		t.Fatalf("loadCassandraMigrations: %v", err)
+	}
+	want := []cassandraMigration{
+		{version: 2, name: "first", file: "000002_first.up.cql"},
+		{version: 10, name: "later", file: "000010_later.up.cql"},
+	}
+	if !reflect.DeepEqual(migrations, want) {
+		t.Fatalf("got %+v, want %+v", migrations, want)
+	}
+
+	files["2_again.up.cql"] = &fstest.MapFile{Data: []byte("DROP TABLE c;")}
+	if _, err := loadCassandraMigrations(files); err == nil {
+		t.Fatal("duplicate version: got nil error")
+	}
+}
+
+func TestEmbeddedCassandraMigrations(t *testing.T) {
+	files, err := fs.Sub(schema.Cassandra, "cassandra")
+	if err != nil {
+		t.Fatalf("fs.Sub: %v", err)
+	}
+	migrations, err := loadCassandraMigrations(files)
+	if err != nil {
+		t.Fatalf("loadCassandraMigrations: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations built into the binary")
+	}
+	for _, migration := range migrations {
+		data, err := fs.ReadFile(files, migration.file)
+		if err != nil {
+			t.Fatalf("read %s: %v", migration.file, err)
+		}
+		if len(splitCQL(string(data))) == 0 {
+			t.Errorf("%s has no statements", migration.file)
+		}
+	}
+}
@@ -6,20 +6,24 @@ import (
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
+
+	schema "github.com/chat-backend/migrations"
 )
 
 type Migrator struct {
 	logger     *logrus.Logger
 	postgresDB *gorm.DB
+	cassandra  *CassandraConfig // nil unless Cassandra stores messages
 }
 
-func NewMigrator(logger *logrus.Logger, postgresDB *gorm.DB) *Migrator {
+func NewMigrator(logger *logrus.Logger, postgresDB *gorm.DB, cassandra *CassandraConfig) *Migrator {
 	return &Migrator{
 		logger:     logger,
 		postgresDB: postgresDB,
+		cassandra:  cassandra,
 	}
 }
 
@@ -28,6 +32,12 @@ func (m *Migrator) RunMigrations(ctx context.Context) error {
 		return fmt.Errorf("postgres migrations failed: %w", err)
 	}
 
+	if m.cassandra != nil {
+		if err := m.runCassandraMigrations(ctx); err != nil {
+			return fmt.Errorf("cassandra migrations failed: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -44,11 +54,12 @@ func (m *Migrator) runPostgresMigrations() error {
 		return err
 	}
 
-	migrator, err := migrate.NewWithDatabaseInstance(
-		"file://migrations/postgres",
-		"postgres",
-		driver,
-	)
+	source, err := iofs.New(schema.Postgres, "postgres")
+	if err != nil {
+		return err
+	}
+
+	migrator, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
 	if err != nil {
 		return err
 	}
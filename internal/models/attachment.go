@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Attachment is an uploaded file. Its content lives in the blob store under
+// StorageKey; MessageID is set once the owner sends it with a message, after
+// which it cannot be attached to another one.
+type Attachment struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	OwnerID     uuid.UUID  `json:"owner_id" gorm:"type:uuid;not null"`
+	MessageID   *uuid.UUID `json:"message_id,omitempty" gorm:"type:uuid"`
+	Filename    string     `json:"filename" gorm:"not null"`
+	ContentType string     `json:"content_type" gorm:"not null"` // MIME type
+	Size        int64      `json:"size" gorm:"not null"`
+	Checksum    string     `json:"checksum" gorm:"not null"` // Hex-encoded SHA-256 of the content
+	StorageKey  string     `json:"-" gorm:"not null"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"not null"`
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Conversation types
+const (
+	ConversationTypeDirect = "direct"
+	ConversationTypeGroup  = "group"
+)
+
+// maxPreviewLength caps the last message text kept in an inbox entry
+const maxPreviewLength = 200
+
+// Conversation is one entry in a user's inbox: a direct conversation with
+// another user or a group, with its most recent message and how far the user
+// has read. UnreadCount is kept in Redis rather than in the table.
+type Conversation struct {
+	UserID            uuid.UUID  `json:"-" gorm:"type:uuid;primaryKey"`
+	ID                string     `json:"id" gorm:"column:conversation_id;primaryKey"`
+	Type              string     `json:"type" gorm:"not null"` // "direct" or "group"
+	PeerID            *uuid.UUID `json:"peer_id,omitempty" gorm:"type:uuid"`
+	GroupID           *uuid.UUID `json:"group_id,omitempty" gorm:"type:uuid"`
+	LastMessageID     *uuid.UUID `json:"last_message_id,omitempty" gorm:"type:uuid"`
+	LastSenderID      *uuid.UUID `json:"last_sender_id,omitempty" gorm:"type:uuid"`
+	LastMessage       string     `json:"last_message"`
+	LastContentType   string     `json:"last_content_type,omitempty"`
+	LastActivityAt    time.Time  `json:"last_activity_at" gorm:"not null"`
+	LastReadMessageID *uuid.UUID `json:"last_read_message_id,omitempty" gorm:"type:uuid"`
+	LastReadAt        *time.Time `json:"last_read_at,omitempty"` // Timestamp of the last read message
+	UnreadCount       int        `json:"unread_count" gorm:"-"`
+}
+
+func (Conversation) TableName() string {
+	return "user_conversations"
+}
+
+// NewConversationEntry builds the inbox entry of userID for a new message.
+// The message counts as unread for everyone but its sender, whose read
+// pointer moves up to it instead.
+func NewConversationEntry(userID uuid.UUID, message *Message) Conversation {
+	conversation := Conversation{
+		UserID:          userID,
+		ID:              message.ConversationID(),
+		LastMessageID:   &message.ID,
+		LastSenderID:    &message.SenderID,
+		LastMessage:     Preview(message),
+		LastContentType: message.ContentType,
+		LastActivityAt:  message.Timestamp,
+	}
+
+	if message.GroupID != nil {
+		conversation.Type = ConversationTypeGroup
+		conversation.GroupID = message.GroupID
+	} else {
+		conversation.Type = ConversationTypeDirect
+		peerID := *message.RecipientID
+		if userID == peerID {
+			peerID = message.SenderID
+		}
+		conversation.PeerID = &peerID
+	}
+
+	if userID == message.SenderID {
+		conversation.LastReadMessageID = &message.ID
+		conversation.LastReadAt = &message.Timestamp
+	} else {
+		conversation.UnreadCount = 1
+	}
+	return conversation
+}
+
+// Preview returns the inbox preview text of a message. Tombstones have none.
+func Preview(message *Message) string {
+	content := message.Content
+	if message.IsDeleted() || utf8.RuneCountInString(content) <= maxPreviewLength {
+		return content
+	}
+	runes := []rune(content)
+	return string(runes[:maxPreviewLength])
+}
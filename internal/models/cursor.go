@@ -0,0 +1,84 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks a position in a list ordered by timestamp, with the ID
+// breaking ties between rows that share a timestamp. Clients receive it as
+// an opaque string.
+type Cursor struct {
+	Timestamp time.Time
+	ID        string
+}
+
+// Encode returns the opaque form of the cursor
+func (c Cursor) Encode() string {
+	raw := strconv.FormatInt(c.Timestamp.UnixNano(), 10) + ":" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses a cursor produced by Encode. An empty string yields a
+// nil cursor.
+func DecodeCursor(value string) (*Cursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok || id == "" {
+		return nil, ErrInvalidCursor
+	}
+	unixNano, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &Cursor{
+		Timestamp: time.Unix(0, unixNano).UTC(),
+		ID:        id,
+	}, nil
+}
+
+// Page selects a slice of a history ordered by (timestamp, id). With Before
+// (or neither cursor) it selects the Limit rows immediately older than the
+// cursor; with After, the Limit rows immediately newer. Both bounds are
+// exclusive. Repositories always return the rows newest first.
+type Page struct {
+	Before *Cursor
+	After  *Cursor
+	Limit  int
+	Viewer uuid.UUID // When set, messages the viewer deleted for themselves are left out
+}
+
+// Forward reports whether the page reads towards newer rows
+func (p Page) Forward() bool {
+	return p.After != nil
+}
+
+// MessageCursor returns the cursor positioned at a message
+func MessageCursor(message *Message) Cursor {
+	return Cursor{Timestamp: message.Timestamp, ID: message.ID.String()}
+}
+
+// ReverseMessages reverses messages in place, turning rows read oldest first
+// for a forward page into the newest first order repositories return
+func ReverseMessages(messages []Message) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+}
@@ -7,44 +7,72 @@ import (
 	"github.com/lib/pq"
 )
 
+// Message is a direct or group message. DeliveredCount and ReadCount
+// summarise the message's receipts and are filled in by the repositories;
+// Reactions and the thread fields are filled in by the message service for
+// history pages.
 type Message struct {
-	ID            uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	SenderID      uuid.UUID      `json:"sender_id" gorm:"type:uuid;not null"`
-	RecipientID   *uuid.UUID     `json:"recipient_id,omitempty" gorm:"type:uuid"`
-	GroupID       *uuid.UUID     `json:"group_id,omitempty" gorm:"type:uuid"`
-	Content       string         `json:"content" gorm:"not null"`
-	ContentType   string         `json:"content_type" gorm:"not null"` // "text", "image", etc.
-	Timestamp     time.Time      `json:"timestamp" gorm:"not null;default:CURRENT_TIMESTAMP"`
-	ReadBy        pq.StringArray `json:"read_by" gorm:"type:text[]"`
-	DeliveredTo   pq.StringArray `json:"delivered_to" gorm:"type:text[]"`
-	ReplyToID     *uuid.UUID     `json:"reply_to_id,omitempty" gorm:"type:uuid"`
-	Attachments   pq.StringArray `json:"attachments,omitempty" gorm:"type:text[]"`
-	IsEdited      bool           `json:"is_edited" gorm:"not null;default:false"`
-	EditTimestamp *time.Time     `json:"edit_timestamp,omitempty"`
+	ID            uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()" bson:"_id"`
+	SenderID      uuid.UUID      `json:"sender_id" gorm:"type:uuid;not null" bson:"sender_id"`
+	RecipientID   *uuid.UUID     `json:"recipient_id,omitempty" gorm:"type:uuid" bson:"recipient_id,omitempty"`
+	GroupID       *uuid.UUID     `json:"group_id,omitempty" gorm:"type:uuid" bson:"group_id,omitempty"`
+	Content       string         `json:"content" gorm:"not null" bson:"content"`
+	ContentType   string         `json:"content_type" gorm:"not null" bson:"content_type"` // "text", "image", etc.
+	Timestamp     time.Time      `json:"timestamp" gorm:"not null;default:CURRENT_TIMESTAMP" bson:"timestamp"`
+	ReplyToID     *uuid.UUID     `json:"reply_to_id,omitempty" gorm:"type:uuid" bson:"reply_to_id,omitempty"`
+	Attachments   pq.StringArray `json:"attachments,omitempty" gorm:"type:text[]" bson:"attachments,omitempty"`
+	IsEdited      bool           `json:"is_edited" gorm:"not null;default:false" bson:"is_edited"`
+	EditTimestamp *time.Time     `json:"edit_timestamp,omitempty" bson:"edit_timestamp,omitempty"`
+	DeletedAt     *time.Time     `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"` // Set on tombstones
+
+	DeliveredCount int `json:"delivered_count" gorm:"-" bson:"-"`
+	ReadCount      int `json:"read_count" gorm:"-" bson:"-"`
+
+	Reactions []ReactionSummary `json:"reactions,omitempty" gorm:"-" bson:"-"`
+
+	ReplyCount  int          `json:"reply_count,omitempty" gorm:"-" bson:"-"`
+	LastReplyAt *time.Time   `json:"last_reply_at,omitempty" gorm:"-" bson:"-"`
+	ThreadState *ThreadState `json:"thread_state,omitempty" gorm:"-" bson:"-"` // Set when the viewer follows the thread
+}
+
+// DirectConversationID identifies the conversation between two users. The
+// same ID is returned whichever order the users are given in.
+func DirectConversationID(userID1, userID2 uuid.UUID) string {
+	a, b := userID1.String(), userID2.String()
+	if b < a {
+		a, b = b, a
+	}
+	return "dm:" + a + ":" + b
+}
+
+// GroupConversationID identifies a group's conversation
+func GroupConversationID(groupID uuid.UUID) string {
+	return "group:" + groupID.String()
 }
 
-// CQL table creation statement
-const MessageTableCQL = `
-CREATE TABLE IF NOT EXISTS messages (
-    id uuid,
-    sender_id uuid,
-    recipient_id uuid,
-    group_id uuid,
-    content text,
-    content_type text,
-    timestamp timestamp,
-    read_by list<uuid>,
-    delivered_to list<uuid>,
-    reply_to_id uuid,
-    attachments list<text>,
-    is_edited boolean,
-    edit_timestamp timestamp,
-    PRIMARY KEY ((recipient_id, group_id), timestamp, id)
-) WITH CLUSTERING ORDER BY (timestamp DESC);
+// ConversationID identifies the conversation the message belongs to
+func (m *Message) ConversationID() string {
+	if m.GroupID != nil {
+		return GroupConversationID(*m.GroupID)
+	}
+	if m.RecipientID != nil {
+		return DirectConversationID(m.SenderID, *m.RecipientID)
+	}
+	return ""
+}
 
-CREATE INDEX IF NOT EXISTS idx_messages_sender ON messages (sender_id);
-CREATE INDEX IF NOT EXISTS idx_messages_group ON messages (group_id);
-`
+// IsDeleted reports whether the message was deleted for everyone
+func (m *Message) IsDeleted() bool {
+	return m.DeletedAt != nil
+}
+
+// Tombstone turns the message into a tombstone: its content is cleared but
+// the row stays so that replies still point at it
+func (m *Message) Tombstone(at time.Time) {
+	m.Content = ""
+	m.Attachments = nil
+	m.DeletedAt = &at
+}
 
 // Message status constants
 const (
@@ -63,10 +91,8 @@ const (
 // NewMessage creates a new message with a generated UUID and current timestamp
 func NewMessage() *Message {
 	return &Message{
-		ID:          uuid.New(),
-		Timestamp:   time.Now(),
-		ReadBy:      make(pq.StringArray, 0),
-		DeliveredTo: make(pq.StringArray, 0),
-		IsEdited:    false,
+		ID:        uuid.New(),
+		Timestamp: time.Now(),
+		IsEdited:  false,
 	}
 }
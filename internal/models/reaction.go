@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Reaction is one user's emoji reaction to a message. A user may react to a
+// message with several different emoji, but with each one only once.
+type Reaction struct {
+	MessageID uuid.UUID `json:"message_id" gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `json:"user_id" gorm:"type:uuid;primaryKey"`
+	Emoji     string    `json:"emoji" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at" gorm:"not null"`
+}
+
+func (Reaction) TableName() string {
+	return "message_reactions"
+}
+
+// ReactionSummary counts the reactions to a message with one emoji, as seen
+// by one user
+type ReactionSummary struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Receipt records when a message reached a user and when they read it. The
+// sender of a message has no receipt for it.
+type Receipt struct {
+	MessageID   uuid.UUID  `json:"-" gorm:"type:uuid;primaryKey" bson:"message_id"`
+	UserID      uuid.UUID  `json:"user_id" gorm:"type:uuid;primaryKey" bson:"user_id"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+	ReadAt      *time.Time `json:"read_at,omitempty" bson:"read_at,omitempty"`
+}
+
+func (Receipt) TableName() string {
+	return "message_receipts"
+}
+
+// CountReceipts fills in the delivered and read counts of a message from
+// its receipts
+func (m *Message) CountReceipts(receipts []Receipt) {
+	m.DeliveredCount, m.ReadCount = 0, 0
+	for _, receipt := range receipts {
+		if receipt.DeliveredAt != nil {
+			m.DeliveredCount++
+		}
+		if receipt.ReadAt != nil {
+			m.ReadCount++
+		}
+	}
+}
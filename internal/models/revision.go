@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MessageRevision is an earlier version of an edited message. CreatedAt is
+// when the version was written, either the message's timestamp or an
+// earlier edit; ReplacedAt is when the edit replaced it.
+type MessageRevision struct {
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	MessageID   uuid.UUID `json:"message_id" gorm:"type:uuid;not null"`
+	Content     string    `json:"content" gorm:"not null"`
+	ContentType string    `json:"content_type" gorm:"not null"`
+	CreatedAt   time.Time `json:"created_at" gorm:"not null"`
+	ReplacedAt  time.Time `json:"replaced_at" gorm:"not null"`
+}
+
+// NewMessageRevision captures the current version of a message before it is
+// edited at replacedAt
+func NewMessageRevision(message *Message, replacedAt time.Time) *MessageRevision {
+	createdAt := message.Timestamp
+	if message.EditTimestamp != nil {
+		createdAt = *message.EditTimestamp
+	}
+	return &MessageRevision{
+		ID:          uuid.New(),
+		MessageID:   message.ID,
+		Content:     message.Content,
+		ContentType: message.ContentType,
+		CreatedAt:   createdAt,
+		ReplacedAt:  replacedAt,
+	}
+}
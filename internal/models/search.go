@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Markers a SearchIndex puts around the matched terms of a snippet. They are
+// private-use characters so they cannot be confused with message text.
+const (
+	HighlightStart = "\ue000"
+	HighlightEnd   = "\ue001"
+)
+
+// SearchQuery selects messages for a full-text search, newest first. Only
+// direct messages to or from UserID (when IncludeDirect is set) and messages
+// in GroupIDs are searched; messages UserID deleted for themselves are not.
+type SearchQuery struct {
+	Text          string
+	UserID        uuid.UUID
+	IncludeDirect bool
+	GroupIDs      []uuid.UUID
+	SenderID      *uuid.UUID
+	ContentType   string
+	From          *time.Time // Inclusive
+	To            *time.Time // Exclusive
+	Before        *Cursor
+	Limit         int
+}
+
+// SearchHit is a message matching a search, with a snippet of its content
+// in which the matched terms are wrapped in HighlightStart and HighlightEnd
+type SearchHit struct {
+	MessageID      uuid.UUID  `json:"message_id"`
+	ConversationID string     `json:"conversation_id"`
+	SenderID       uuid.UUID  `json:"sender_id"`
+	RecipientID    *uuid.UUID `json:"recipient_id,omitempty"`
+	GroupID        *uuid.UUID `json:"group_id,omitempty"`
+	ContentType    string     `json:"content_type"`
+	Timestamp      time.Time  `json:"timestamp"`
+	Snippet        string     `json:"snippet"`
+}
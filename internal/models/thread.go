@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Thread summarises the replies to a message. Replies to a reply join the
+// thread of the message it replied to, so threads are one level deep.
+type Thread struct {
+	ParentID    uuid.UUID `json:"parent_id" gorm:"type:uuid;primaryKey"`
+	ReplyCount  int       `json:"reply_count" gorm:"not null;default:0"`
+	LastReplyID uuid.UUID `json:"last_reply_id" gorm:"type:uuid;not null"`
+	LastReplyAt time.Time `json:"last_reply_at" gorm:"not null"`
+}
+
+func (Thread) TableName() string {
+	return "message_threads"
+}
+
+// ThreadFollower records whether a user follows a thread. Following is
+// false once the user unfollowed it.
+type ThreadFollower struct {
+	ParentID  uuid.UUID `json:"parent_id" gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `json:"user_id" gorm:"type:uuid;primaryKey"`
+	Following bool      `json:"following" gorm:"not null;default:true"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"not null"`
+}
+
+func (ThreadFollower) TableName() string {
+	return "thread_followers"
+}
+
+// ThreadState is one user's view of a thread
+type ThreadState struct {
+	Following   bool `json:"following"`
+	UnreadCount int  `json:"unread_count"`
+}
+
+// ThreadUnreadKey identifies a thread among a user's unread counts, which
+// are otherwise kept per conversation
+func ThreadUnreadKey(parentID uuid.UUID) string {
+	return "thread:" + parentID.String()
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Upload is a resumable upload in progress. Its content arrives in chunks,
+// each kept as its own blob, until Offset reaches Size; the upload then
+// becomes the attachment with the same ID.
+type Upload struct {
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	OwnerID     uuid.UUID `json:"owner_id" gorm:"type:uuid;not null"`
+	Filename    string    `json:"filename" gorm:"not null"`
+	ContentType string    `json:"content_type,omitempty"` // MIME type declared by the client
+	Size        int64     `json:"size" gorm:"not null"`
+	Offset      int64     `json:"offset" gorm:"column:upload_offset;not null"` // Bytes received so far
+	ExpiresAt   time.Time `json:"expires_at" gorm:"not null"`
+	CreatedAt   time.Time `json:"created_at" gorm:"not null"`
+	UpdatedAt   time.Time `json:"updated_at" gorm:"not null"`
+}
+
+// Complete reports whether all of the upload's content has arrived
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Size
+}
+
+// UploadChunk is one received piece of an upload, starting at Offset
+type UploadChunk struct {
+	UploadID   uuid.UUID `gorm:"type:uuid;primary_key"`
+	Offset     int64     `gorm:"column:chunk_offset;primary_key"`
+	Size       int64     `gorm:"not null"`
+	StorageKey string    `gorm:"not null"`
+	CreatedAt  time.Time `gorm:"not null"`
+}
@@ -2,13 +2,24 @@ package cassandra
 
 import (
 	"context"
+	"errors"
+	"sort"
 	"time"
 
 	"github.com/chat-backend/internal/models"
+	"github.com/chat-backend/internal/repository"
 	"github.com/gocql/gocql"
 	"github.com/google/uuid"
 )
 
+// Columns shared by messages_by_id, messages_by_conversation, messages_by_user
+// and messages_by_thread
+const messageColumns = `id, sender_id, recipient_id, group_id, content, content_type,
+	timestamp, reply_to_id, attachments, is_edited, edit_timestamp, deleted_at`
+
+// farFuture bounds history queries that start from the newest message
+var farFuture = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
 type messageRepository struct {
 	session *gocql.Session
 }
@@ -17,215 +28,645 @@ func NewMessageRepository(session *gocql.Session) *messageRepository {
 	return &messageRepository{session: session}
 }
 
+// bucketOf returns the daily partition bucket (yyyymmdd) for a timestamp
+func bucketOf(t time.Time) int {
+	year, month, day := t.UTC().Date()
+	return year*10000 + int(month)*100 + day
+}
+
+func conversationBucketKey(conversationID string) string {
+	return "conversation:" + conversationID
+}
+
+func userBucketKey(userID uuid.UUID) string {
+	return "user:" + userID.String()
+}
+
+func threadBucketKey(parentID uuid.UUID) string {
+	return "thread:" + parentID.String()
+}
+
+// participants returns the users whose messages_by_user partitions hold the message
+func participants(message *models.Message) []uuid.UUID {
+	users := []uuid.UUID{message.SenderID}
+	if message.GroupID == nil && message.RecipientID != nil && *message.RecipientID != message.SenderID {
+		users = append(users, *message.RecipientID)
+	}
+	return users
+}
+
+func nullableUUID(id *uuid.UUID) interface{} {
+	if id == nil {
+		return nil
+	}
+	return gocql.UUID(*id)
+}
+
+func optionalUUID(id gocql.UUID) *uuid.UUID {
+	if id == (gocql.UUID{}) {
+		return nil
+	}
+	value := uuid.UUID(id)
+	return &value
+}
+
+// messageScan holds the destinations for one row of messageColumns
+type messageScan struct {
+	id, senderID, recipientID, groupID, replyToID gocql.UUID
+	editTimestamp, deletedAt                      time.Time
+	message                                       models.Message
+}
+
+func (s *messageScan) dest() []interface{} {
+	return []interface{}{
+		&s.id,
+		&s.senderID,
+		&s.recipientID,
+		&s.groupID,
+		&s.message.Content,
+		&s.message.ContentType,
+		&s.message.Timestamp,
+		&s.replyToID,
+		&s.message.Attachments,
+		&s.message.IsEdited,
+		&s.editTimestamp,
+		&s.deletedAt,
+	}
+}
+
+func (s *messageScan) result() models.Message {
+	message := s.message
+	message.ID = uuid.UUID(s.id)
+	message.SenderID = uuid.UUID(s.senderID)
+	message.RecipientID = optionalUUID(s.recipientID)
+	message.GroupID = optionalUUID(s.groupID)
+	message.ReplyToID = optionalUUID(s.replyToID)
+	if !s.editTimestamp.IsZero() {
+		editTimestamp := s.editTimestamp
+		message.EditTimestamp = &editTimestamp
+	}
+	if !s.deletedAt.IsZero() {
+		deletedAt := s.deletedAt
+		message.DeletedAt = &deletedAt
+	}
+	return message
+}
+
 func (r *messageRepository) Create(ctx context.Context, message *models.Message) error {
-	return r.session.Query(`
-		INSERT INTO messages (
-			id, sender_id, recipient_id, group_id, content, content_type,
-			timestamp, read_by, delivered_to, reply_to_id, attachments,
-			is_edited, edit_timestamp
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		message.ID,
-		message.SenderID,
-		message.RecipientID,
-		message.GroupID,
+	conversationID := message.ConversationID()
+	if conversationID == "" {
+		return errors.New("message has neither a recipient nor a group")
+	}
+	bucket := bucketOf(message.Timestamp)
+
+	values := []interface{}{
+		gocql.UUID(message.ID),
+		gocql.UUID(message.SenderID),
+		nullableUUID(message.RecipientID),
+		nullableUUID(message.GroupID),
 		message.Content,
 		message.ContentType,
 		message.Timestamp,
-		message.ReadBy,
-		message.DeliveredTo,
-		message.ReplyToID,
-		message.Attachments,
+		nullableUUID(message.ReplyToID),
+		[]string(message.Attachments),
 		message.IsEdited,
 		message.EditTimestamp,
-	).WithContext(ctx).Exec()
+		message.DeletedAt,
+	}
+
+	batch := r.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	batch.Query(`
+		INSERT INTO messages_by_id (`+messageColumns+`, conversation_id, bucket)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		append(values, conversationID, bucket)...,
+	)
+	batch.Query(`
+		INSERT INTO messages_by_conversation (`+messageColumns+`, conversation_id, bucket)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		append(values, conversationID, bucket)...,
+	)
+	batch.Query(`INSERT INTO message_buckets (partition_key, bucket) VALUES (?, ?)`,
+		conversationBucketKey(conversationID), bucket,
+	)
+	if message.ReplyToID != nil {
+		batch.Query(`
+			INSERT INTO messages_by_thread (`+messageColumns+`, conversation_id, bucket)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			append(values, conversationID, bucket)...,
+		)
+		batch.Query(`INSERT INTO message_buckets (partition_key, bucket) VALUES (?, ?)`,
+			threadBucketKey(*message.ReplyToID), bucket,
+		)
+	}
+	for _, userID := range participants(message) {
+		batch.Query(`
+			INSERT INTO messages_by_user (`+messageColumns+`, conversation_id, bucket, user_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			append(values, conversationID, bucket, gocql.UUID(userID))...,
+		)
+		batch.Query(`INSERT INTO message_buckets (partition_key, bucket) VALUES (?, ?)`,
+			userBucketKey(userID), bucket,
+		)
+	}
+
+	return r.session.ExecuteBatch(batch)
 }
 
 func (r *messageRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Message, error) {
-	var message models.Message
+	var row messageScan
 	err := r.session.Query(`
-		SELECT id, sender_id, recipient_id, group_id, content, content_type,
-		       timestamp, read_by, delivered_to, reply_to_id, attachments,
-		       is_edited, edit_timestamp
-		FROM messages
-		WHERE id = ?
-		LIMIT 1`,
-		id,
-	).WithContext(ctx).Scan(
-		&message.ID,
-		&message.SenderID,
-		&message.RecipientID,
-		&message.GroupID,
-		&message.Content,
-		&message.ContentType,
-		&message.Timestamp,
-		&message.ReadBy,
-		&message.DeliveredTo,
-		&message.ReplyToID,
-		&message.Attachments,
-		&message.IsEdited,
-		&message.EditTimestamp,
-	)
+		SELECT `+messageColumns+`
+		FROM messages_by_id
+		WHERE id = ?`,
+		gocql.UUID(id),
+	).WithContext(ctx).Scan(row.dest()...)
+	if err == gocql.ErrNotFound {
+		return nil, repository.ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &message, nil
+
+	messages := []models.Message{row.result()}
+	if err := r.loadReceipts(ctx, messages); err != nil {
+		return nil, err
+	}
+	return &messages[0], nil
 }
 
-func (r *messageRepository) GetUserMessages(ctx context.Context, userID uuid.UUID, limit int, offset int) ([]models.Message, error) {
-	var messages []models.Message
-	iter := r.session.Query(`
-		SELECT id, sender_id, recipient_id, group_id, content, content_type,
-		       timestamp, read_by, delivered_to, reply_to_id, attachments,
-		       is_edited, edit_timestamp
-		FROM messages
-		WHERE recipient_id = ?
-		ORDER BY timestamp DESC
-		LIMIT ?`,
-		userID, limit,
-	).WithContext(ctx).PageSize(limit).PageState([]byte{}).Iter()
-
-	var message models.Message
-	for iter.Scan(
-		&message.ID,
-		&message.SenderID,
-		&message.RecipientID,
-		&message.GroupID,
-		&message.Content,
-		&message.ContentType,
-		&message.Timestamp,
-		&message.ReadBy,
-		&message.DeliveredTo,
-		&message.ReplyToID,
-		&message.Attachments,
-		&message.IsEdited,
-		&message.EditTimestamp,
-	) {
-		messages = append(messages, message)
+func (r *messageRepository) GetUserMessages(ctx context.Context, userID uuid.UUID, page models.Page) ([]models.Message, error) {
+	return r.findPage(ctx, historyQuery{
+		table:     "messages_by_user",
+		keyColumn: "user_id",
+		key:       gocql.UUID(userID),
+		bucketKey: userBucketKey(userID),
+	}, page)
+}
+
+func (r *messageRepository) GetGroupMessages(ctx context.Context, groupID uuid.UUID, page models.Page) ([]models.Message, error) {
+	conversationID := models.GroupConversationID(groupID)
+	return r.findPage(ctx, historyQuery{
+		table:     "messages_by_conversation",
+		keyColumn: "conversation_id",
+		key:       conversationID,
+		bucketKey: conversationBucketKey(conversationID),
+	}, page)
+}
+
+func (r *messageRepository) GetMessagesBetween(ctx context.Context, userID1, userID2 uuid.UUID, page models.Page) ([]models.Message, error) {
+	conversationID := models.DirectConversationID(userID1, userID2)
+	return r.findPage(ctx, historyQuery{
+		table:     "messages_by_conversation",
+		keyColumn: "conversation_id",
+		key:       conversationID,
+		bucketKey: conversationBucketKey(conversationID),
+	}, page)
+}
+
+func (r *messageRepository) GetReplies(ctx context.Context, parentID uuid.UUID, page models.Page) ([]models.Message, error) {
+	return r.findPage(ctx, historyQuery{
+		table:     "messages_by_thread",
+		keyColumn: "reply_to_id",
+		key:       gocql.UUID(parentID),
+		bucketKey: threadBucketKey(parentID),
+	}, page)
+}
+
+// findPage bounds q by a history page and returns the messages newest first
+func (r *messageRepository) findPage(ctx context.Context, q historyQuery, page models.Page) ([]models.Message, error) {
+	var err error
+	q.bound = farFuture
+	q.limit = page.Limit
+
+	cursor := page.Before
+	if page.Forward() {
+		cursor = page.After
+		q.ascending = true
 	}
-	if err := iter.Close(); err != nil {
+	if cursor != nil {
+		id, err := gocql.ParseUUID(cursor.ID)
+		if err != nil {
+			return nil, models.ErrInvalidCursor
+		}
+		q.bound = cursor.Timestamp
+		q.boundID = &id
+	}
+
+	if q.keep, err = r.notHiddenFor(ctx, page.Viewer); err != nil {
 		return nil, err
 	}
-	return messages, nil
-}
 
-func (r *messageRepository) GetGroupMessages(ctx context.Context, groupID uuid.UUID, limit int, offset int) ([]models.Message, error) {
-	var messages []models.Message
-	iter := r.session.Query(`
-		SELECT id, sender_id, recipient_id, group_id, content, content_type,
-		       timestamp, read_by, delivered_to, reply_to_id, attachments,
-		       is_edited, edit_timestamp
-		FROM messages
-		WHERE group_id = ?
-		ORDER BY timestamp DESC
-		LIMIT ?`,
-		groupID, limit,
-	).WithContext(ctx).PageSize(limit).PageState([]byte{}).Iter()
-
-	var message models.Message
-	for iter.Scan(
-		&message.ID,
-		&message.SenderID,
-		&message.RecipientID,
-		&message.GroupID,
-		&message.Content,
-		&message.ContentType,
-		&message.Timestamp,
-		&message.ReadBy,
-		&message.DeliveredTo,
-		&message.ReplyToID,
-		&message.Attachments,
-		&message.IsEdited,
-		&message.EditTimestamp,
-	) {
-		messages = append(messages, message)
+	messages, err := r.walk(ctx, q)
+	if err != nil {
+		return nil, err
 	}
-	if err := iter.Close(); err != nil {
+	if page.Forward() {
+		models.ReverseMessages(messages)
+	}
+	if err := r.loadReceipts(ctx, messages); err != nil {
 		return nil, err
 	}
 	return messages, nil
 }
 
-func (r *messageRepository) GetConversation(ctx context.Context, user1ID, user2ID uuid.UUID, limit int, offset int) ([]models.Message, error) {
-	var messages []models.Message
-	iter := r.session.Query(`
-		SELECT id, sender_id, recipient_id, group_id, content, content_type,
-		       timestamp, read_by, delivered_to, reply_to_id, attachments,
-		       is_edited, edit_timestamp
-		FROM messages
-		WHERE (sender_id = ? AND recipient_id = ?) OR (sender_id = ? AND recipient_id = ?)
-		ORDER BY timestamp DESC
-		LIMIT ?`,
-		user1ID, user2ID, user2ID, user1ID, limit,
-	).WithContext(ctx).PageSize(limit).PageState([]byte{}).Iter()
-
-	var message models.Message
-	for iter.Scan(
-		&message.ID,
-		&message.SenderID,
-		&message.RecipientID,
-		&message.GroupID,
-		&message.Content,
-		&message.ContentType,
-		&message.Timestamp,
-		&message.ReadBy,
-		&message.DeliveredTo,
-		&message.ReplyToID,
-		&message.Attachments,
-		&message.IsEdited,
-		&message.EditTimestamp,
-	) {
-		messages = append(messages, message)
+func (r *messageRepository) GetUserMessagesSince(ctx context.Context, userID uuid.UUID, groupIDs []uuid.UUID, since models.Cursor, limit int) ([]models.Message, error) {
+	sinceID, err := gocql.ParseUUID(since.ID)
+	if err != nil {
+		return nil, models.ErrInvalidCursor
 	}
-	if err := iter.Close(); err != nil {
+	visible, err := r.notHiddenFor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Direct messages come from the user's partition; group messages the
+	// user sent are skipped there and read from the group instead
+	messages, err := r.walk(ctx, historyQuery{
+		table:     "messages_by_user",
+		keyColumn: "user_id",
+		key:       gocql.UUID(userID),
+		bucketKey: userBucketKey(userID),
+		bound:     since.Timestamp,
+		boundID:   &sinceID,
+		ascending: true,
+		limit:     limit,
+		keep: func(message *models.Message) bool {
+			return message.GroupID == nil && visible(message)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, groupID := range groupIDs {
+		conversationID := models.GroupConversationID(groupID)
+		groupMessages, err := r.walk(ctx, historyQuery{
+			table:     "messages_by_conversation",
+			keyColumn: "conversation_id",
+			key:       conversationID,
+			bucketKey: conversationBucketKey(conversationID),
+			bound:     since.Timestamp,
+			boundID:   &sinceID,
+			ascending: true,
+			limit:     limit,
+			keep:      visible,
+		})
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, groupMessages...)
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		if !messages[i].Timestamp.Equal(messages[j].Timestamp) {
+			return messages[i].Timestamp.Before(messages[j].Timestamp)
+		}
+		return messages[i].ID.String() < messages[j].ID.String()
+	})
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+
+	if err := r.loadReceipts(ctx, messages); err != nil {
 		return nil, err
 	}
 	return messages, nil
 }
 
-func (r *messageRepository) MarkAsRead(ctx context.Context, messageID uuid.UUID, userID uuid.UUID) error {
+func (r *messageRepository) MarkAsRead(ctx context.Context, messageIDs []uuid.UUID, userID uuid.UUID) error {
+	now := time.Now()
+	for _, messageID := range messageIDs {
+		if err := r.recordReceipt(ctx, messageID, userID, now, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *messageRepository) MarkAsDelivered(ctx context.Context, messageID uuid.UUID, userID uuid.UUID) error {
+	return r.recordReceipt(ctx, messageID, userID, time.Now(), false)
+}
+
+// recordReceipt sets the receipt times that are still missing. Concurrent
+// writers for the same user may each set a time; either one is acceptable.
+func (r *messageRepository) recordReceipt(ctx context.Context, messageID, userID uuid.UUID, at time.Time, read bool) error {
+	var deliveredAt, readAt time.Time
+	err := r.session.Query(`
+		SELECT delivered_at, read_at
+		FROM message_receipts
+		WHERE message_id = ? AND user_id = ?`,
+		gocql.UUID(messageID), gocql.UUID(userID),
+	).WithContext(ctx).Scan(&deliveredAt, &readAt)
+	if err != nil && err != gocql.ErrNotFound {
+		return err
+	}
+
+	if !deliveredAt.IsZero() && (!read || !readAt.IsZero()) {
+		return nil
+	}
+	if deliveredAt.IsZero() {
+		deliveredAt = at
+	}
+	var readValue interface{}
+	if !readAt.IsZero() {
+		readValue = readAt
+	} else if read {
+		readValue = at
+	}
+
 	return r.session.Query(`
-		UPDATE messages
-		SET read_by = read_by + ?
-		WHERE id = ?`,
-		[]uuid.UUID{userID},
-		messageID,
+		UPDATE message_receipts
+		SET delivered_at = ?, read_at = ?
+		WHERE message_id = ? AND user_id = ?`,
+		deliveredAt, readValue, gocql.UUID(messageID), gocql.UUID(userID),
 	).WithContext(ctx).Exec()
 }
 
-func (r *messageRepository) MarkAsDelivered(ctx context.Context, messageID uuid.UUID, userID uuid.UUID) error {
+func (r *messageRepository) HideMessage(ctx context.Context, messageID uuid.UUID, userID uuid.UUID) error {
 	return r.session.Query(`
-		UPDATE messages
-		SET delivered_to = delivered_to + ?
-		WHERE id = ?`,
-		[]uuid.UUID{userID},
-		messageID,
+		INSERT INTO hidden_messages (user_id, message_id, hidden_at)
+		VALUES (?, ?, ?)`,
+		gocql.UUID(userID), gocql.UUID(messageID), time.Now(),
 	).WithContext(ctx).Exec()
 }
 
+func (r *messageRepository) IsHidden(ctx context.Context, messageID uuid.UUID, userID uuid.UUID) (bool, error) {
+	var id gocql.UUID
+	err := r.session.Query(`SELECT message_id FROM hidden_messages WHERE user_id = ? AND message_id = ?`,
+		gocql.UUID(userID), gocql.UUID(messageID),
+	).WithContext(ctx).Scan(&id)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// notHiddenFor returns a history filter that drops the messages userID hid.
+// Users hide few messages, so the whole partition is read up front.
+func (r *messageRepository) notHiddenFor(ctx context.Context, userID uuid.UUID) (func(*models.Message) bool, error) {
+	keepAll := func(*models.Message) bool { return true }
+	if userID == uuid.Nil {
+		return keepAll, nil
+	}
+
+	iter := r.session.Query(`SELECT message_id FROM hidden_messages WHERE user_id = ?`,
+		gocql.UUID(userID),
+	).WithContext(ctx).Iter()
+
+	hidden := make(map[uuid.UUID]bool)
+	var id gocql.UUID
+	for iter.Scan(&id) {
+		hidden[uuid.UUID(id)] = true
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	if len(hidden) == 0 {
+		return keepAll, nil
+	}
+	return func(message *models.Message) bool {
+		return !hidden[message.ID]
+	}, nil
+}
+
+func (r *messageRepository) GetReceipts(ctx context.Context, messageID uuid.UUID) ([]models.Receipt, error) {
+	iter := r.session.Query(`
+		SELECT user_id, delivered_at, read_at
+		FROM message_receipts
+		WHERE message_id = ?`,
+		gocql.UUID(messageID),
+	).WithContext(ctx).Iter()
+
+	var receipts []models.Receipt
+	var userID gocql.UUID
+	var deliveredAt, readAt time.Time
+	for iter.Scan(&userID, &deliveredAt, &readAt) {
+		receipts = append(receipts, newReceipt(messageID, uuid.UUID(userID), deliveredAt, readAt))
+		deliveredAt, readAt = time.Time{}, time.Time{}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return receipts, nil
+}
+
+func newReceipt(messageID, userID uuid.UUID, deliveredAt, readAt time.Time) models.Receipt {
+	receipt := models.Receipt{MessageID: messageID, UserID: userID}
+	if !deliveredAt.IsZero() {
+		receipt.DeliveredAt = &deliveredAt
+	}
+	if !readAt.IsZero() {
+		receipt.ReadAt = &readAt
+	}
+	return receipt
+}
+
 func (r *messageRepository) Update(ctx context.Context, message *models.Message) error {
-	message.IsEdited = true
-	message.EditTimestamp = &time.Time{}
-	*message.EditTimestamp = time.Now()
+	// The stored row decides which partitions hold copies of the message
+	existing, err := r.GetByID(ctx, message.ID)
+	if err != nil {
+		return err
+	}
+	conversationID := existing.ConversationID()
+	bucket := bucketOf(existing.Timestamp)
 
-	return r.session.Query(`
-		UPDATE messages
-		SET content = ?,
-		    content_type = ?,
-		    is_edited = ?,
-		    edit_timestamp = ?
-		WHERE id = ?`,
+	values := []interface{}{
 		message.Content,
 		message.ContentType,
+		[]string(message.Attachments),
 		message.IsEdited,
 		message.EditTimestamp,
-		message.ID,
-	).WithContext(ctx).Exec()
+		message.DeletedAt,
+	}
+	const set = `SET content = ?, content_type = ?, attachments = ?, is_edited = ?, edit_timestamp = ?, deleted_at = ?`
+
+	batch := r.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	batch.Query(`UPDATE messages_by_id `+set+` WHERE id = ?`,
+		append(values, gocql.UUID(existing.ID))...,
+	)
+	batch.Query(`
+		UPDATE messages_by_conversation `+set+`
+		WHERE conversation_id = ? AND bucket = ? AND timestamp = ? AND id = ?`,
+		append(values, conversationID, bucket, existing.Timestamp, gocql.UUID(existing.ID))...,
+	)
+	if existing.ReplyToID != nil {
+		batch.Query(`
+			UPDATE messages_by_thread `+set+`
+			WHERE reply_to_id = ? AND bucket = ? AND timestamp = ? AND id = ?`,
+			append(values, gocql.UUID(*existing.ReplyToID), bucket, existing.Timestamp, gocql.UUID(existing.ID))...,
+		)
+	}
+	for _, userID := range participants(existing) {
+		batch.Query(`
+			UPDATE messages_by_user `+set+`
+			WHERE user_id = ? AND bucket = ? AND timestamp = ? AND id = ?`,
+			append(values, gocql.UUID(userID), bucket, existing.Timestamp, gocql.UUID(existing.ID))...,
+		)
+	}
+
+	return r.session.ExecuteBatch(batch)
 }
 
 func (r *messageRepository) Delete(ctx context.Context, id uuid.UUID) error {
-	return r.session.Query(`
-		DELETE FROM messages
-		WHERE id = ?`,
-		id,
-	).WithContext(ctx).Exec()
+	existing, err := r.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	conversationID := existing.ConversationID()
+	bucket := bucketOf(existing.Timestamp)
+
+	batch := r.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	batch.Query(`DELETE FROM messages_by_id WHERE id = ?`, gocql.UUID(id))
+	batch.Query(`
+		DELETE FROM messages_by_conversation
+		WHERE conversation_id = ? AND bucket = ? AND timestamp = ? AND id = ?`,
+		conversationID, bucket, existing.Timestamp, gocql.UUID(id),
+	)
+	if existing.ReplyToID != nil {
+		batch.Query(`
+			DELETE FROM messages_by_thread
+			WHERE reply_to_id = ? AND bucket = ? AND timestamp = ? AND id = ?`,
+			gocql.UUID(*existing.ReplyToID), bucket, existing.Timestamp, gocql.UUID(id),
+		)
+	}
+	for _, userID := range participants(existing) {
+		batch.Query(`
+			DELETE FROM messages_by_user
+			WHERE user_id = ? AND bucket = ? AND timestamp = ? AND id = ?`,
+			gocql.UUID(userID), bucket, existing.Timestamp, gocql.UUID(id),
+		)
+	}
+	batch.Query(`DELETE FROM message_receipts WHERE message_id = ?`, gocql.UUID(id))
+
+	return r.session.ExecuteBatch(batch)
+}
+
+// historyQuery describes a read from one of the bucketed history tables
+type historyQuery struct {
+	table     string // messages_by_conversation, messages_by_user or messages_by_thread
+	keyColumn string // conversation_id, user_id or reply_to_id
+	key       interface{}
+	bucketKey string
+	bound     time.Time   // Exclusive: read before it, or after it when ascending
+	boundID   *gocql.UUID // Breaks ties with rows at exactly bound
+	ascending bool
+	limit     int
+	keep      func(*models.Message) bool // Optional filter applied to each row
+}
+
+// walk reads a partition bucket by bucket, newest first (oldest first when
+// ascending), until limit messages have been collected
+func (r *messageRepository) walk(ctx context.Context, q historyQuery) ([]models.Message, error) {
+	if q.limit <= 0 {
+		return nil, nil
+	}
+
+	buckets, err := r.buckets(ctx, q.bucketKey, bucketOf(q.bound), q.ascending)
+	if err != nil {
+		return nil, err
+	}
+
+	op := "<"
+	if q.ascending {
+		op = ">"
+	}
+	restriction := `timestamp ` + op + ` ?`
+	bound := []interface{}{q.bound}
+	if q.boundID != nil {
+		restriction = `(timestamp, id) ` + op + ` (?, ?)`
+		bound = append(bound, *q.boundID)
+	}
+
+	stmt := `SELECT ` + messageColumns + ` FROM ` + q.table + `
+		WHERE ` + q.keyColumn + ` = ? AND bucket = ? AND ` + restriction
+	if q.ascending {
+		stmt += ` ORDER BY timestamp ASC, id ASC`
+	}
+
+	var messages []models.Message
+	for _, bucket := range buckets {
+		values := append([]interface{}{q.key, bucket}, bound...)
+		scanner := r.session.Query(stmt, values...).
+			WithContext(ctx).
+			PageSize(q.limit).
+			Iter().
+			Scanner()
+
+		for len(messages) < q.limit && scanner.Next() {
+			var row messageScan
+			if err := scanner.Scan(row.dest()...); err != nil {
+				return nil, err
+			}
+			message := row.result()
+			if q.keep == nil || q.keep(&message) {
+				messages = append(messages, message)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		if len(messages) >= q.limit {
+			break
+		}
+	}
+	return messages, nil
+}
+
+// buckets lists the buckets of a partition up to (or, when ascending, from)
+// the given bucket
+func (r *messageRepository) buckets(ctx context.Context, bucketKey string, from int, ascending bool) ([]int, error) {
+	stmt := `SELECT bucket FROM message_buckets WHERE partition_key = ? AND bucket <= ?`
+	if ascending {
+		stmt = `SELECT bucket FROM message_buckets WHERE partition_key = ? AND bucket >= ? ORDER BY bucket ASC`
+	}
+
+	iter := r.session.Query(stmt, bucketKey, from).WithContext(ctx).Iter()
+	var buckets []int
+	var bucket int
+	for iter.Scan(&bucket) {
+		buckets = append(buckets, bucket)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
+
+// loadReceipts fills in the receipt counts of messages from message_receipts
+func (r *messageRepository) loadReceipts(ctx context.Context, messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]gocql.UUID, len(messages))
+	receipts := make(map[uuid.UUID][]models.Receipt, len(messages))
+	for i := range messages {
+		ids[i] = gocql.UUID(messages[i].ID)
+	}
+
+	iter := r.session.Query(`
+		SELECT message_id, user_id, delivered_at, read_at
+		FROM message_receipts
+		WHERE message_id IN ?`,
+		ids,
+	).WithContext(ctx).Iter()
+
+	var messageID, userID gocql.UUID
+	var deliveredAt, readAt time.Time
+	for iter.Scan(&messageID, &userID, &deliveredAt, &readAt) {
+		id := uuid.UUID(messageID)
+		receipts[id] = append(receipts[id], newReceipt(id, uuid.UUID(userID), deliveredAt, readAt))
+		deliveredAt, readAt = time.Time{}, time.Time{}
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].CountReceipts(receipts[messages[i].ID])
+	}
+	return nil
 }
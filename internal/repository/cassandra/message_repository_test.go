@@ -0,0 +1,46 @@
+package cassandra
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+
+	"github.com/chat-backend/internal/repository/repotest"
+)
+
+// TestMessageRepository runs the shared conformance suite against the
+// migrated keyspace named by CASSANDRA_TEST_KEYSPACE (default chat) on the
+// comma-separated hosts in CASSANDRA_TEST_HOSTS
+func TestMessageRepository(t *testing.T) {
+	hosts := os.Getenv("CASSANDRA_TEST_HOSTS")
+	if hosts == "" {
+		t.Skip("CASSANDRA_TEST_HOSTS is not set")
+	}
+
+	cluster := gocql.NewCluster(strings.Split(hosts, ",")...)
+	cluster.Keyspace = os.Getenv("CASSANDRA_TEST_KEYSPACE")
+	if cluster.Keyspace == "" {
+		cluster.Keyspace = "chat"
+	}
+	cluster.Consistency = gocql.Quorum
+	session, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(session.Close)
+
+	// Users and groups live in Postgres, so any fresh IDs will do
+	fixtures := repotest.Fixtures{
+		UserA:   uuid.New(),
+		UserB:   uuid.New(),
+		UserC:   uuid.New(),
+		GroupID: uuid.New(),
+	}
+	if err := repotest.TestMessageRepository(context.Background(), NewMessageRepository(session), fixtures); err != nil {
+		t.Fatal(err)
+	}
+}
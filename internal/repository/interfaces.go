@@ -2,12 +2,18 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"io"
 	"time"
 
 	"github.com/chat-backend/internal/models"
 	"github.com/google/uuid"
 )
 
+// ErrNotFound is returned by every MessageRepository backend when a message
+// does not exist
+var ErrNotFound = errors.New("record not found")
+
 // UserRepository handles all user-related database operations
 type UserRepository interface {
 	Create(ctx context.Context, user *models.User) error
@@ -32,19 +38,168 @@ type GroupRepository interface {
 	UpdateMemberRole(ctx context.Context, groupID, userID uuid.UUID, role string) error
 }
 
-// MessageRepository handles all message-related database operations
+// MessageRepository handles all message-related database operations. Messages
+// it returns carry their receipt counts.
 type MessageRepository interface {
 	Create(ctx context.Context, message *models.Message) error
 	GetByID(ctx context.Context, id uuid.UUID) (*models.Message, error)
-	GetUserMessages(ctx context.Context, userID uuid.UUID, limit int, offset int) ([]models.Message, error)
-	GetGroupMessages(ctx context.Context, groupID uuid.UUID, limit int, offset int) ([]models.Message, error)
-	GetMessagesBetween(ctx context.Context, userID1, userID2 uuid.UUID, limit int64, before time.Time) ([]*models.Message, error)
-	MarkAsRead(ctx context.Context, messageID uuid.UUID, userID uuid.UUID) error
+	// History queries return the page selected by page, newest first.
+	// Tombstones are included; messages hidden by page.Viewer are not.
+	GetUserMessages(ctx context.Context, userID uuid.UUID, page models.Page) ([]models.Message, error)
+	GetGroupMessages(ctx context.Context, groupID uuid.UUID, page models.Page) ([]models.Message, error)
+	GetMessagesBetween(ctx context.Context, userID1, userID2 uuid.UUID, page models.Page) ([]models.Message, error)
+	// GetReplies returns the page of replies to a thread's parent message
+	GetReplies(ctx context.Context, parentID uuid.UUID, page models.Page) ([]models.Message, error)
+	// GetUserMessagesSince returns direct messages to or from the user and messages in the given groups
+	// that come after the since cursor in (timestamp, id) order, oldest first, leaving out messages the
+	// user hid
+	GetUserMessagesSince(ctx context.Context, userID uuid.UUID, groupIDs []uuid.UUID, since models.Cursor, limit int) ([]models.Message, error)
+	// MarkAsRead and MarkAsDelivered record a receipt for the user. The first
+	// recorded time is kept; reading a message also marks it delivered.
+	MarkAsRead(ctx context.Context, messageIDs []uuid.UUID, userID uuid.UUID) error
 	MarkAsDelivered(ctx context.Context, messageID uuid.UUID, userID uuid.UUID) error
+	// GetReceipts returns every receipt recorded for a message
+	GetReceipts(ctx context.Context, messageID uuid.UUID) ([]models.Receipt, error)
+	// HideMessage deletes a message for one user only
+	HideMessage(ctx context.Context, messageID uuid.UUID, userID uuid.UUID) error
+	// IsHidden reports whether the user deleted the message for themselves
+	IsHidden(ctx context.Context, messageID uuid.UUID, userID uuid.UUID) (bool, error)
 	Update(ctx context.Context, message *models.Message) error
+	// Delete removes a message for good; deleting for everyone goes through
+	// Update with a tombstone instead
 	Delete(ctx context.Context, id uuid.UUID) error
 }
 
+// ConversationRepository maintains each user's inbox of conversations
+type ConversationRepository interface {
+	// RecordMessage upserts inbox entries for a new message. An entry's read
+	// pointer only replaces the stored one when it is further ahead.
+	RecordMessage(ctx context.Context, entries []models.Conversation) error
+	// UpdatePreview refreshes the preview of every inbox entry whose last
+	// message is the given one, after it was edited or deleted
+	UpdatePreview(ctx context.Context, message *models.Message) error
+	// GetConversation returns the user's inbox entry for a conversation, or
+	// ErrNotFound when the user has none
+	GetConversation(ctx context.Context, userID uuid.UUID, conversationID string) (*models.Conversation, error)
+	// MarkRead moves the user's read pointer up to the given message and
+	// reports whether it moved; it never moves backwards
+	MarkRead(ctx context.Context, userID uuid.UUID, conversationID string, messageID uuid.UUID, timestamp time.Time) (bool, error)
+	// GetUserConversations returns the user's inbox, most recently active
+	// first, starting after the cursor when one is given
+	GetUserConversations(ctx context.Context, userID uuid.UUID, after *models.Cursor, limit int) ([]models.Conversation, error)
+}
+
+// RevisionRepository stores the earlier versions of edited messages
+type RevisionRepository interface {
+	Create(ctx context.Context, revision *models.MessageRevision) error
+	// GetByMessageID returns a message's revisions, oldest first
+	GetByMessageID(ctx context.Context, messageID uuid.UUID) ([]models.MessageRevision, error)
+	// DeleteByMessageID removes every revision of a message
+	DeleteByMessageID(ctx context.Context, messageID uuid.UUID) error
+}
+
+// ReactionRepository stores emoji reactions to messages
+type ReactionRepository interface {
+	// Add records the reaction and reports whether it was new
+	Add(ctx context.Context, reaction *models.Reaction) (bool, error)
+	// Remove deletes the user's reaction and reports whether there was one
+	Remove(ctx context.Context, messageID, userID uuid.UUID, emoji string) (bool, error)
+	// Summarize counts the reactions to each message per emoji, in the order
+	// each emoji was first used, flagging the ones viewer reacted with.
+	// Messages without reactions are left out.
+	Summarize(ctx context.Context, messageIDs []uuid.UUID, viewer uuid.UUID) (map[uuid.UUID][]models.ReactionSummary, error)
+}
+
+// ThreadRepository keeps reply counts and followers of message threads
+type ThreadRepository interface {
+	// RecordReply counts a new reply in its parent's thread
+	RecordReply(ctx context.Context, reply *models.Message) error
+	// GetThreads returns the threads of the given parents; messages without
+	// replies are left out
+	GetThreads(ctx context.Context, parentIDs []uuid.UUID) (map[uuid.UUID]models.Thread, error)
+	// Follow sets whether the user follows the thread
+	Follow(ctx context.Context, parentID, userID uuid.UUID, following bool) error
+	// FollowByDefault makes the users follow the thread unless they already
+	// follow or unfollowed it
+	FollowByDefault(ctx context.Context, parentID uuid.UUID, userIDs []uuid.UUID) error
+	// GetFollowers returns the users following the thread
+	GetFollowers(ctx context.Context, parentID uuid.UUID) ([]uuid.UUID, error)
+	// GetFollowed reports which of the given threads the user follows
+	GetFollowed(ctx context.Context, userID uuid.UUID, parentIDs []uuid.UUID) (map[uuid.UUID]bool, error)
+}
+
+// SearchIndex finds messages by their content. The message service keeps it
+// up to date as messages are sent, edited and deleted.
+type SearchIndex interface {
+	// Index adds a message to the index, or replaces its indexed content
+	Index(ctx context.Context, message *models.Message) error
+	// Remove drops a message from the index, for everyone
+	Remove(ctx context.Context, messageID uuid.UUID) error
+	// Hide drops a message from the user's search results only
+	Hide(ctx context.Context, messageID, userID uuid.UUID) error
+	// Search returns the page of matching messages selected by the query,
+	// newest first
+	Search(ctx context.Context, query models.SearchQuery) ([]models.SearchHit, error)
+}
+
+// AttachmentRepository stores the records of uploaded files
+type AttachmentRepository interface {
+	Create(ctx context.Context, attachment *models.Attachment) error
+	// GetByID returns ErrNotFound when the attachment does not exist
+	GetByID(ctx context.Context, id uuid.UUID) (*models.Attachment, error)
+	// Claim attaches the owner's unattached attachments to a message, all
+	// or none, and reports whether it did
+	Claim(ctx context.Context, ids []uuid.UUID, ownerID, messageID uuid.UUID) (bool, error)
+	// Release detaches the attachments of a message that was not stored
+	Release(ctx context.Context, messageID uuid.UUID) error
+	// TotalSize returns the combined size of the owner's attachments
+	TotalSize(ctx context.Context, ownerID uuid.UUID) (int64, error)
+}
+
+// UploadRepository tracks resumable uploads and the chunks received so far
+type UploadRepository interface {
+	Create(ctx context.Context, upload *models.Upload) error
+	// GetByID returns ErrNotFound when the upload does not exist
+	GetByID(ctx context.Context, id uuid.UUID) (*models.Upload, error)
+	// AppendChunk records a chunk starting at the upload's current offset,
+	// moves the offset past it and pushes the expiry back. It reports false,
+	// recording nothing, when the offset has moved on, the chunk runs past
+	// the end, or the upload has expired or is gone.
+	AppendChunk(ctx context.Context, chunk *models.UploadChunk, expiresAt time.Time) (bool, error)
+	// GetChunks returns the upload's chunks in offset order
+	GetChunks(ctx context.Context, uploadID uuid.UUID) ([]models.UploadChunk, error)
+	// Delete removes an upload and returns its chunks so that their blobs can
+	// be deleted, or returns ErrNotFound
+	Delete(ctx context.Context, id uuid.UUID) ([]models.UploadChunk, error)
+	// DeleteExpired removes up to limit uploads that expired before the given
+	// time and returns their chunks along with how many uploads it removed
+	DeleteExpired(ctx context.Context, before time.Time, limit int) ([]models.UploadChunk, int, error)
+	// PendingSize returns the combined declared size of the owner's uploads
+	// that have not expired
+	PendingSize(ctx context.Context, ownerID uuid.UUID) (int64, error)
+}
+
+// BlobStore holds the content of uploaded files
+type BlobStore interface {
+	// Put stores size bytes read from r under key
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the content stored under key, or returns ErrNotFound
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// UnreadRepository keeps per-user unread message counts for each conversation.
+// Threads are counted the same way under models.ThreadUnreadKey.
+type UnreadRepository interface {
+	// Increment adds one unread message in the conversation for each user
+	Increment(ctx context.Context, conversationID string, userIDs []uuid.UUID) error
+	// Set replaces the user's unread count for the conversation
+	Set(ctx context.Context, userID uuid.UUID, conversationID string, count int) error
+	// Get returns the user's unread counts for the given conversations;
+	// conversations without unread messages are left out
+	Get(ctx context.Context, userID uuid.UUID, conversationIDs []string) (map[string]int, error)
+}
+
 // StatusRepository handles all user status related operations
 type StatusRepository interface {
 	UpdateStatus(ctx context.Context, userID uuid.UUID, status string) error
@@ -0,0 +1,69 @@
+package mongodb
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+var uuidType = reflect.TypeOf(uuid.UUID{})
+
+// newRegistry returns the default BSON registry with uuid.UUID stored as its
+// string form, matching how IDs are stored in the other backends
+func newRegistry() *bsoncodec.Registry {
+	registry := bson.NewRegistry()
+	registry.RegisterTypeEncoder(uuidType, bsoncodec.ValueEncoderFunc(encodeUUID))
+	registry.RegisterTypeDecoder(uuidType, bsoncodec.ValueDecoderFunc(decodeUUID))
+	return registry
+}
+
+func encodeUUID(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	if !val.IsValid() || val.Type() != uuidType {
+		return bsoncodec.ValueEncoderError{Name: "UUIDEncodeValue", Types: []reflect.Type{uuidType}, Received: val}
+	}
+	return vw.WriteString(val.Interface().(uuid.UUID).String())
+}
+
+func decodeUUID(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	if !val.CanSet() || val.Type() != uuidType {
+		return bsoncodec.ValueDecoderError{Name: "UUIDDecodeValue", Types: []reflect.Type{uuidType}, Received: val}
+	}
+
+	switch vr.Type() {
+	case bsontype.Null:
+		val.Set(reflect.Zero(uuidType))
+		return vr.ReadNull()
+	case bsontype.String:
+		str, err := vr.ReadString()
+		if err != nil {
+			return err
+		}
+		id, err := uuid.Parse(str)
+		if err != nil {
+			return fmt.Errorf("invalid UUID %q: %w", str, err)
+		}
+		val.Set(reflect.ValueOf(id))
+		return nil
+	case bsontype.Binary:
+		data, subtype, err := vr.ReadBinary()
+		if err != nil {
+			return err
+		}
+		if subtype != bsontype.BinaryUUID && subtype != bsontype.BinaryUUIDOld {
+			return fmt.Errorf("unexpected binary subtype %d for UUID", subtype)
+		}
+		id, err := uuid.FromBytes(data)
+		if err != nil {
+			return err
+		}
+		val.Set(reflect.ValueOf(id))
+		return nil
+	default:
+		return fmt.Errorf("cannot decode %v into a UUID", vr.Type())
+	}
+}
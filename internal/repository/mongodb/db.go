@@ -20,7 +20,7 @@ func NewDB(ctx context.Context, uri string, dbName string) (*DB, error) {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetRegistry(newRegistry()))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to connect to MongoDB")
 	}
@@ -4,24 +4,60 @@ import (
 	"context"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/chat-backend/internal/models"
+	"github.com/chat-backend/internal/repository"
 )
 
 type MessageRepository struct {
 	collection *mongo.Collection
+	receipts   *mongo.Collection
+	hidden     *mongo.Collection
 }
 
 func NewMessageRepository(db *mongo.Database) *MessageRepository {
 	return &MessageRepository{
 		collection: db.Collection("messages"),
+		receipts:   db.Collection("message_receipts"),
+		hidden:     db.Collection("hidden_messages"),
 	}
 }
 
+// EnsureIndexes creates the indexes used by the message queries
+func (r *MessageRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "sender_id", Value: 1}, {Key: "recipient_id", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "recipient_id", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "group_id", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "reply_to_id", Value: 1}, {Key: "timestamp", Value: -1}}, Options: options.Index().SetSparse(true)},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create message indexes")
+	}
+
+	_, err = r.receipts.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "message_id", Value: 1}, {Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create receipt indexes")
+	}
+
+	_, err = r.hidden.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "message_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create hidden message indexes")
+	}
+	return nil
+}
+
 func (r *MessageRepository) Create(ctx context.Context, message *models.Message) error {
 	_, err := r.collection.InsertOne(ctx, message)
 	if err != nil {
@@ -30,41 +66,53 @@ func (r *MessageRepository) Create(ctx context.Context, message *models.Message)
 	return nil
 }
 
-func (r *MessageRepository) GetByID(ctx context.Context, id string) (*models.Message, error) {
+func (r *MessageRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Message, error) {
 	var message models.Message
-	err := r.collection.FindOne(ctx, bson.M{"id": id}).Decode(&message)
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&message)
 	if err == mongo.ErrNoDocuments {
-		return nil, nil
+		return nil, repository.ErrNotFound
 	}
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get message")
 	}
-	return &message, nil
+
+	messages := []models.Message{message}
+	if err := r.loadReceiptCounts(ctx, messages); err != nil {
+		return nil, err
+	}
+	return &messages[0], nil
 }
 
 func (r *MessageRepository) Update(ctx context.Context, message *models.Message) error {
-	result, err := r.collection.ReplaceOne(ctx, bson.M{"id": message.ID}, message)
+	result, err := r.collection.ReplaceOne(ctx, bson.M{"_id": message.ID}, message)
 	if err != nil {
 		return errors.Wrap(err, "failed to update message")
 	}
 	if result.MatchedCount == 0 {
-		return errors.New("message not found")
+		return repository.ErrNotFound
 	}
 	return nil
 }
 
-func (r *MessageRepository) Delete(ctx context.Context, id string) error {
-	result, err := r.collection.DeleteOne(ctx, bson.M{"id": id})
+func (r *MessageRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
 	if err != nil {
 		return errors.Wrap(err, "failed to delete message")
 	}
 	if result.DeletedCount == 0 {
-		return errors.New("message not found")
+		return repository.ErrNotFound
+	}
+
+	if _, err := r.receipts.DeleteMany(ctx, bson.M{"message_id": id}); err != nil {
+		return errors.Wrap(err, "failed to delete receipts")
+	}
+	if _, err := r.hidden.DeleteMany(ctx, bson.M{"message_id": id}); err != nil {
+		return errors.Wrap(err, "failed to delete hidden markers")
 	}
 	return nil
 }
 
-func (r *MessageRepository) GetMessagesBetween(ctx context.Context, userID1, userID2 string, limit int64, before time.Time) ([]*models.Message, error) {
+func (r *MessageRepository) GetMessagesBetween(ctx context.Context, userID1, userID2 uuid.UUID, page models.Page) ([]models.Message, error) {
 	filter := bson.M{
 		"$or": []bson.M{
 			{
@@ -76,103 +124,276 @@ func (r *MessageRepository) GetMessagesBetween(ctx context.Context, userID1, use
 				"recipient_id": userID1,
 			},
 		},
-		"timestamp": bson.M{"$lt": before},
+	}
+	return r.findPage(ctx, filter, page)
+}
+
+func (r *MessageRepository) GetUserMessagesSince(ctx context.Context, userID uuid.UUID, groupIDs []uuid.UUID, since models.Cursor, limit int) ([]models.Message, error) {
+	addressed := []bson.M{
+		{
+			"group_id": nil,
+			"$or": []bson.M{
+				{"sender_id": userID},
+				{"recipient_id": userID},
+			},
+		},
+	}
+	if len(groupIDs) > 0 {
+		addressed = append(addressed, bson.M{"group_id": bson.M{"$in": groupIDs}})
+	}
+
+	filter, err := r.withoutHidden(ctx, bson.M{
+		"$and": []bson.M{
+			{"$or": addressed},
+			{"$or": []bson.M{
+				{"timestamp": bson.M{"$gt": since.Timestamp}},
+				{"timestamp": since.Timestamp, "_id": bson.M{"$gt": since.ID}},
+			}},
+		},
+	}, userID)
+	if err != nil {
+		return nil, err
 	}
 
 	opts := options.Find().
-		SetSort(bson.D{bson.E{Key: "timestamp", Value: -1}}).
-		SetLimit(limit)
+		SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(limit))
 
 	cursor, err := r.collection.Find(ctx, filter, opts)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get messages")
+		return nil, errors.Wrap(err, "failed to get messages since")
 	}
 	defer cursor.Close(ctx)
 
-	var messages []*models.Message
+	var messages []models.Message
 	if err = cursor.All(ctx, &messages); err != nil {
 		return nil, errors.Wrap(err, "failed to decode messages")
 	}
+	if err := r.loadReceiptCounts(ctx, messages); err != nil {
+		return nil, err
+	}
 	return messages, nil
 }
 
-func (r *MessageRepository) GetGroupMessages(ctx context.Context, groupID string, limit int, offset int) ([]models.Message, error) {
+func (r *MessageRepository) GetGroupMessages(ctx context.Context, groupID uuid.UUID, page models.Page) ([]models.Message, error) {
 	filter := bson.M{
 		"group_id": groupID,
 	}
+	return r.findPage(ctx, filter, page)
+}
 
-	opts := options.Find().
-		SetSort(bson.D{bson.E{Key: "timestamp", Value: -1}}).
-		SetLimit(int64(limit)).
-		SetSkip(int64(offset))
+func (r *MessageRepository) GetReplies(ctx context.Context, parentID uuid.UUID, page models.Page) ([]models.Message, error) {
+	filter := bson.M{
+		"reply_to_id": parentID,
+	}
+	return r.findPage(ctx, filter, page)
+}
 
-	cursor, err := r.collection.Find(ctx, filter, opts)
+func (r *MessageRepository) MarkAsDelivered(ctx context.Context, messageID uuid.UUID, userID uuid.UUID) error {
+	now := time.Now()
+	_, err := r.receipts.UpdateOne(ctx,
+		bson.M{"message_id": messageID, "user_id": userID},
+		bson.M{"$min": bson.M{"delivered_at": now}},
+		options.Update().SetUpsert(true),
+	)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get group messages")
+		return errors.Wrap(err, "failed to mark message as delivered")
 	}
-	defer cursor.Close(ctx)
+	return nil
+}
 
-	var messages []models.Message
-	if err = cursor.All(ctx, &messages); err != nil {
-		return nil, errors.Wrap(err, "failed to decode messages")
+func (r *MessageRepository) MarkAsRead(ctx context.Context, messageIDs []uuid.UUID, userID uuid.UUID) error {
+	if len(messageIDs) == 0 {
+		return nil
 	}
-	return messages, nil
+
+	// $min keeps the first recorded time and sets it when missing
+	now := time.Now()
+	writes := make([]mongo.WriteModel, len(messageIDs))
+	for i, messageID := range messageIDs {
+		writes[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"message_id": messageID, "user_id": userID}).
+			SetUpdate(bson.M{"$min": bson.M{"delivered_at": now, "read_at": now}}).
+			SetUpsert(true)
+	}
+	if _, err := r.receipts.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return errors.Wrap(err, "failed to mark messages as read")
+	}
+	return nil
 }
 
-func (r *MessageRepository) MarkAsDelivered(ctx context.Context, messageID string, userID string) error {
-	update := bson.M{
-		"$addToSet": bson.M{
-			"delivered_to": userID,
-		},
+func (r *MessageRepository) HideMessage(ctx context.Context, messageID uuid.UUID, userID uuid.UUID) error {
+	_, err := r.hidden.UpdateOne(ctx,
+		bson.M{"user_id": userID, "message_id": messageID},
+		bson.M{"$setOnInsert": bson.M{"hidden_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to hide message")
 	}
-	result, err := r.collection.UpdateOne(ctx, bson.M{"id": messageID}, update)
+	return nil
+}
+
+func (r *MessageRepository) IsHidden(ctx context.Context, messageID uuid.UUID, userID uuid.UUID) (bool, error) {
+	count, err := r.hidden.CountDocuments(ctx,
+		bson.M{"user_id": userID, "message_id": messageID},
+		options.Count().SetLimit(1),
+	)
 	if err != nil {
-		return errors.Wrap(err, "failed to mark message as delivered")
+		return false, errors.Wrap(err, "failed to check hidden message")
 	}
-	if result.MatchedCount == 0 {
-		return errors.New("message not found")
+	return count > 0, nil
+}
+
+// withoutHidden narrows filter to leave out the messages userID hid. Users
+// hide few messages, so their IDs are loaded and excluded directly.
+func (r *MessageRepository) withoutHidden(ctx context.Context, filter bson.M, userID uuid.UUID) (bson.M, error) {
+	if userID == uuid.Nil {
+		return filter, nil
 	}
-	return nil
+
+	cursor, err := r.hidden.Find(ctx, bson.M{"user_id": userID},
+		options.Find().SetProjection(bson.M{"message_id": 1}))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get hidden messages")
+	}
+	defer cursor.Close(ctx)
+
+	var hidden []struct {
+		MessageID uuid.UUID `bson:"message_id"`
+	}
+	if err = cursor.All(ctx, &hidden); err != nil {
+		return nil, errors.Wrap(err, "failed to decode hidden messages")
+	}
+	if len(hidden) == 0 {
+		return filter, nil
+	}
+
+	ids := make([]uuid.UUID, len(hidden))
+	for i, h := range hidden {
+		ids[i] = h.MessageID
+	}
+	return bson.M{"$and": []bson.M{filter, {"_id": bson.M{"$nin": ids}}}}, nil
 }
 
-func (r *MessageRepository) MarkAsRead(ctx context.Context, messageID string, userID string) error {
-	update := bson.M{
-		"$addToSet": bson.M{
-			"read_by": userID,
-		},
+func (r *MessageRepository) GetReceipts(ctx context.Context, messageID uuid.UUID) ([]models.Receipt, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "read_at", Value: 1}, {Key: "delivered_at", Value: 1}})
+	cursor, err := r.receipts.Find(ctx, bson.M{"message_id": messageID}, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get receipts")
+	}
+	defer cursor.Close(ctx)
+
+	var receipts []models.Receipt
+	if err = cursor.All(ctx, &receipts); err != nil {
+		return nil, errors.Wrap(err, "failed to decode receipts")
+	}
+	return receipts, nil
+}
+
+// loadReceiptCounts fills in the delivered and read counts of messages
+func (r *MessageRepository) loadReceiptCounts(ctx context.Context, messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, len(messages))
+	index := make(map[uuid.UUID]int, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].ID
+		index[messages[i].ID] = i
+	}
+
+	counted := func(field string) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$ifNull": bson.A{"$" + field, false}}, 1, 0}}}
 	}
-	result, err := r.collection.UpdateOne(ctx, bson.M{"id": messageID}, update)
+	cursor, err := r.receipts.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"message_id": bson.M{"$in": ids}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       "$message_id",
+			"delivered": counted("delivered_at"),
+			"read":      counted("read_at"),
+		}}},
+	})
 	if err != nil {
-		return errors.Wrap(err, "failed to mark message as read")
+		return errors.Wrap(err, "failed to count receipts")
 	}
-	if result.MatchedCount == 0 {
-		return errors.New("message not found")
+	defer cursor.Close(ctx)
+
+	var counts []struct {
+		MessageID uuid.UUID `bson:"_id"`
+		Delivered int       `bson:"delivered"`
+		Read      int       `bson:"read"`
+	}
+	if err = cursor.All(ctx, &counts); err != nil {
+		return errors.Wrap(err, "failed to decode receipt counts")
+	}
+
+	for _, count := range counts {
+		if i, ok := index[count.MessageID]; ok {
+			messages[i].DeliveredCount = count.Delivered
+			messages[i].ReadCount = count.Read
+		}
 	}
 	return nil
 }
 
-func (r *MessageRepository) GetUserMessages(ctx context.Context, userID string, limit int, offset int) ([]models.Message, error) {
+func (r *MessageRepository) GetUserMessages(ctx context.Context, userID uuid.UUID, page models.Page) ([]models.Message, error) {
 	filter := bson.M{
 		"$or": []bson.M{
 			{"sender_id": userID},
 			{"recipient_id": userID},
 		},
 	}
+	return r.findPage(ctx, filter, page)
+}
+
+// findPage narrows filter to a history page and returns the messages newest first
+func (r *MessageRepository) findPage(ctx context.Context, filter bson.M, page models.Page) ([]models.Message, error) {
+	filter, err := r.withoutHidden(ctx, filter, page.Viewer)
+	if err != nil {
+		return nil, err
+	}
+
+	direction := -1
+	cursor, op := page.Before, "$lt"
+	if page.Forward() {
+		direction = 1
+		cursor, op = page.After, "$gt"
+	}
+
+	if cursor != nil {
+		filter = bson.M{
+			"$and": []bson.M{
+				filter,
+				{"$or": []bson.M{
+					{"timestamp": bson.M{op: cursor.Timestamp}},
+					{"timestamp": cursor.Timestamp, "_id": bson.M{op: cursor.ID}},
+				}},
+			},
+		}
+	}
 
 	opts := options.Find().
-		SetSort(bson.D{bson.E{Key: "timestamp", Value: -1}}).
-		SetLimit(int64(limit)).
-		SetSkip(int64(offset))
+		SetSort(bson.D{{Key: "timestamp", Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(int64(page.Limit))
 
-	cursor, err := r.collection.Find(ctx, filter, opts)
+	result, err := r.collection.Find(ctx, filter, opts)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get user messages")
+		return nil, errors.Wrap(err, "failed to get messages")
 	}
-	defer cursor.Close(ctx)
+	defer result.Close(ctx)
 
 	var messages []models.Message
-	if err = cursor.All(ctx, &messages); err != nil {
+	if err = result.All(ctx, &messages); err != nil {
 		return nil, errors.Wrap(err, "failed to decode messages")
 	}
+
+	if page.Forward() {
+		models.ReverseMessages(messages)
+	}
+	if err := r.loadReceiptCounts(ctx, messages); err != nil {
+		return nil, err
+	}
 	return messages, nil
 }
@@ -0,0 +1,48 @@
+package mongodb
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/chat-backend/internal/repository/repotest"
+)
+
+// TestMessageRepository runs the shared conformance suite against the
+// database named by MONGODB_TEST_DATABASE (default chat_test) at
+// MONGODB_TEST_URI
+func TestMessageRepository(t *testing.T) {
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI is not set")
+	}
+	dbName := os.Getenv("MONGODB_TEST_DATABASE")
+	if dbName == "" {
+		dbName = "chat_test"
+	}
+
+	ctx := context.Background()
+	db, err := NewDB(ctx, uri, dbName)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { db.Close(context.Background()) })
+
+	repo := NewMessageRepository(db.GetDatabase())
+	if err := repo.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("EnsureIndexes: %v", err)
+	}
+
+	// Users and groups live in Postgres, so any fresh IDs will do
+	fixtures := repotest.Fixtures{
+		UserA:   uuid.New(),
+		UserB:   uuid.New(),
+		UserC:   uuid.New(),
+		GroupID: uuid.New(),
+	}
+	if err := repotest.TestMessageRepository(ctx, repo, fixtures); err != nil {
+		t.Fatal(err)
+	}
+}
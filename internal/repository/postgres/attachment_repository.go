@@ -0,0 +1,77 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/chat-backend/internal/models"
+	"github.com/chat-backend/internal/repository"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type attachmentRepository struct {
+	db *gorm.DB
+}
+
+func NewAttachmentRepository(db *gorm.DB) *attachmentRepository {
+	return &attachmentRepository{db: db}
+}
+
+func (r *attachmentRepository) Create(ctx context.Context, attachment *models.Attachment) error {
+	return r.db.WithContext(ctx).Create(attachment).Error
+}
+
+func (r *attachmentRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Attachment, error) {
+	var attachment models.Attachment
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&attachment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+func (r *attachmentRepository) Claim(ctx context.Context, ids []uuid.UUID, ownerID, messageID uuid.UUID) (bool, error) {
+	claimed := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Attachment{}).
+			Where("id IN ? AND owner_id = ? AND message_id IS NULL", ids, ownerID).
+			Update("message_id", messageID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != int64(len(ids)) {
+			// Roll back the attachments that were claimed
+			return errNotClaimed
+		}
+		claimed = true
+		return nil
+	})
+	if errors.Is(err, errNotClaimed) {
+		return false, nil
+	}
+	return claimed, err
+}
+
+// errNotClaimed rolls back a partial Claim
+var errNotClaimed = errors.New("attachments not claimed")
+
+func (r *attachmentRepository) Release(ctx context.Context, messageID uuid.UUID) error {
+	return r.db.WithContext(ctx).
+		Model(&models.Attachment{}).
+		Where("message_id = ?", messageID).
+		Update("message_id", nil).Error
+}
+
+func (r *attachmentRepository) TotalSize(ctx context.Context, ownerID uuid.UUID) (int64, error) {
+	var total int64
+	err := r.db.WithContext(ctx).
+		Model(&models.Attachment{}).
+		Select("COALESCE(SUM(size), 0)").
+		Where("owner_id = ?", ownerID).
+		Scan(&total).Error
+	return total, err
+}
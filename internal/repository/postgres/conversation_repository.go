@@ -0,0 +1,108 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/chat-backend/internal/models"
+	"github.com/chat-backend/internal/repository"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type conversationRepository struct {
+	db *gorm.DB
+}
+
+func NewConversationRepository(db *gorm.DB) *conversationRepository {
+	return &conversationRepository{db: db}
+}
+
+// newer picks the incoming value only when the incoming message is at least
+// as recent as the stored one, so out of order writes keep the latest message
+func newer(column string) clause.Expr {
+	return gorm.Expr("CASE WHEN excluded.last_activity_at >= user_conversations.last_activity_at THEN excluded." +
+		column + " ELSE user_conversations." + column + " END")
+}
+
+// furtherRead picks the incoming read pointer only when it is ahead of the
+// stored one, so a read pointer never moves backwards
+func furtherRead(column string) clause.Expr {
+	return gorm.Expr("CASE WHEN excluded.last_read_at IS NOT NULL AND (user_conversations.last_read_at IS NULL OR " +
+		"(excluded.last_read_at, excluded.last_read_message_id) > (user_conversations.last_read_at, user_conversations.last_read_message_id)) " +
+		"THEN excluded." + column + " ELSE user_conversations." + column + " END")
+}
+
+func (r *conversationRepository) RecordMessage(ctx context.Context, entries []models.Conversation) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}, {Name: "conversation_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"last_message_id":      newer("last_message_id"),
+				"last_sender_id":       newer("last_sender_id"),
+				"last_message":         newer("last_message"),
+				"last_content_type":    newer("last_content_type"),
+				"last_activity_at":     gorm.Expr("GREATEST(user_conversations.last_activity_at, excluded.last_activity_at)"),
+				"last_read_message_id": furtherRead("last_read_message_id"),
+				"last_read_at":         furtherRead("last_read_at"),
+			}),
+		}).
+		Create(&entries).Error
+}
+
+func (r *conversationRepository) UpdatePreview(ctx context.Context, message *models.Message) error {
+	return r.db.WithContext(ctx).
+		Model(&models.Conversation{}).
+		Where("conversation_id = ? AND last_message_id = ?", message.ConversationID(), message.ID).
+		Update("last_message", models.Preview(message)).
+		Error
+}
+
+func (r *conversationRepository) GetConversation(ctx context.Context, userID uuid.UUID, conversationID string) (*models.Conversation, error) {
+	var conversation models.Conversation
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND conversation_id = ?", userID, conversationID).
+		First(&conversation).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+func (r *conversationRepository) MarkRead(ctx context.Context, userID uuid.UUID, conversationID string, messageID uuid.UUID, timestamp time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&models.Conversation{}).
+		Where("user_id = ? AND conversation_id = ?", userID, conversationID).
+		Where("last_read_at IS NULL OR (last_read_at, last_read_message_id) < (?, ?)", timestamp, messageID).
+		Updates(map[string]interface{}{
+			"last_read_message_id": messageID,
+			"last_read_at":         timestamp,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *conversationRepository) GetUserConversations(ctx context.Context, userID uuid.UUID, after *models.Cursor, limit int) ([]models.Conversation, error) {
+	query := r.db.WithContext(ctx).Where("user_id = ?", userID)
+	if after != nil {
+		query = query.Where("(last_activity_at, conversation_id) < (?, ?)", after.Timestamp, after.ID)
+	}
+
+	var conversations []models.Conversation
+	err := query.
+		Order("last_activity_at DESC, conversation_id DESC").
+		Limit(limit).
+		Find(&conversations).Error
+	return conversations, err
+}
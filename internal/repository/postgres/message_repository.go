@@ -2,13 +2,19 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/chat-backend/internal/models"
+	"github.com/chat-backend/internal/repository"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// notHiddenFor leaves out the messages a user deleted for themselves
+const notHiddenFor = "NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.message_id = messages.id AND h.user_id = ?)"
+
 type messageRepository struct {
 	db *gorm.DB
 }
@@ -24,64 +30,190 @@ func (r *messageRepository) Create(ctx context.Context, message *models.Message)
 func (r *messageRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Message, error) {
 	var message models.Message
 	err := r.db.WithContext(ctx).Where("id = ?", id).First(&message).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, repository.ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &message, nil
+
+	messages := []models.Message{message}
+	if err := r.loadReceiptCounts(ctx, messages); err != nil {
+		return nil, err
+	}
+	return &messages[0], nil
 }
 
-func (r *messageRepository) GetUserMessages(ctx context.Context, userID uuid.UUID, limit int, offset int) ([]models.Message, error) {
-	var messages []models.Message
-	err := r.db.WithContext(ctx).
-		Where("sender_id = ? OR recipient_id = ?", userID, userID).
-		Order("timestamp DESC").
-		Limit(limit).
-		Offset(offset).
-		Find(&messages).Error
-	return messages, err
+func (r *messageRepository) GetUserMessages(ctx context.Context, userID uuid.UUID, page models.Page) ([]models.Message, error) {
+	query := r.db.WithContext(ctx).Where("sender_id = ? OR recipient_id = ?", userID, userID)
+	return r.findPage(ctx, query, page)
 }
 
-func (r *messageRepository) GetGroupMessages(ctx context.Context, groupID uuid.UUID, limit int, offset int) ([]models.Message, error) {
-	var messages []models.Message
-	err := r.db.WithContext(ctx).
-		Where("group_id = ?", groupID).
-		Order("timestamp DESC").
-		Limit(limit).
-		Offset(offset).
-		Find(&messages).Error
-	return messages, err
+func (r *messageRepository) GetGroupMessages(ctx context.Context, groupID uuid.UUID, page models.Page) ([]models.Message, error) {
+	query := r.db.WithContext(ctx).Where("group_id = ?", groupID)
+	return r.findPage(ctx, query, page)
 }
 
-func (r *messageRepository) GetMessagesBetween(ctx context.Context, userID1, userID2 uuid.UUID, limit int64, before time.Time) ([]*models.Message, error) {
-	var messages []*models.Message
-	err := r.db.WithContext(ctx).
+func (r *messageRepository) GetMessagesBetween(ctx context.Context, userID1, userID2 uuid.UUID, page models.Page) ([]models.Message, error) {
+	query := r.db.WithContext(ctx).
 		Where(
 			r.db.Where("sender_id = ? AND recipient_id = ?", userID1, userID2).
 				Or("sender_id = ? AND recipient_id = ?", userID2, userID1),
-		).
-		Where("timestamp < ?", before).
-		Order("timestamp DESC").
-		Limit(int(limit)).
+		)
+	return r.findPage(ctx, query, page)
+}
+
+func (r *messageRepository) GetReplies(ctx context.Context, parentID uuid.UUID, page models.Page) ([]models.Message, error) {
+	query := r.db.WithContext(ctx).Where("reply_to_id = ?", parentID)
+	return r.findPage(ctx, query, page)
+}
+
+// findPage applies a history page to a query and returns the rows newest first
+func (r *messageRepository) findPage(ctx context.Context, query *gorm.DB, page models.Page) ([]models.Message, error) {
+	if page.Viewer != uuid.Nil {
+		query = query.Where(notHiddenFor, page.Viewer)
+	}
+
+	order := "timestamp DESC, id DESC"
+	switch {
+	case page.After != nil:
+		query = query.Where("(timestamp, id) > (?, ?)", page.After.Timestamp, page.After.ID)
+		order = "timestamp ASC, id ASC"
+	case page.Before != nil:
+		query = query.Where("(timestamp, id) < (?, ?)", page.Before.Timestamp, page.Before.ID)
+	}
+
+	var messages []models.Message
+	if err := query.Order(order).Limit(page.Limit).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+
+	if page.Forward() {
+		models.ReverseMessages(messages)
+	}
+	if err := r.loadReceiptCounts(ctx, messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (r *messageRepository) GetUserMessagesSince(ctx context.Context, userID uuid.UUID, groupIDs []uuid.UUID, since models.Cursor, limit int) ([]models.Message, error) {
+	addressed := r.db.Where("group_id IS NULL AND (sender_id = ? OR recipient_id = ?)", userID, userID)
+	if len(groupIDs) > 0 {
+		addressed = addressed.Or("group_id IN ?", groupIDs)
+	}
+
+	var messages []models.Message
+	err := r.db.WithContext(ctx).
+		Where(addressed).
+		Where("(timestamp, id) > (?, ?)", since.Timestamp, since.ID).
+		Where(notHiddenFor, userID).
+		Order("timestamp ASC, id ASC").
+		Limit(limit).
 		Find(&messages).Error
-	return messages, err
+	if err != nil {
+		return nil, err
+	}
+	if err := r.loadReceiptCounts(ctx, messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
 }
 
-func (r *messageRepository) MarkAsRead(ctx context.Context, messageID uuid.UUID, userID uuid.UUID) error {
+func (r *messageRepository) MarkAsRead(ctx context.Context, messageIDs []uuid.UUID, userID uuid.UUID) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	receipts := make([]models.Receipt, len(messageIDs))
+	for i, messageID := range messageIDs {
+		receipts[i] = models.Receipt{MessageID: messageID, UserID: userID, DeliveredAt: &now, ReadAt: &now}
+	}
 	return r.db.WithContext(ctx).
-		Model(&models.Message{}).
-		Where("id = ?", messageID).
-		Update("read_by", gorm.Expr("array_append(read_by, ?)", userID.String())).
-		Error
+		Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "message_id"}, {Name: "user_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"delivered_at": gorm.Expr("COALESCE(message_receipts.delivered_at, excluded.delivered_at)"),
+				"read_at":      gorm.Expr("COALESCE(message_receipts.read_at, excluded.read_at)"),
+			}),
+		}).
+		Create(&receipts).Error
 }
 
 func (r *messageRepository) MarkAsDelivered(ctx context.Context, messageID uuid.UUID, userID uuid.UUID) error {
+	now := time.Now()
+	receipt := models.Receipt{MessageID: messageID, UserID: userID, DeliveredAt: &now}
 	return r.db.WithContext(ctx).
-		Model(&models.Message{}).
-		Where("id = ?", messageID).
-		Update("delivered_to", gorm.Expr("array_append(delivered_to, ?)", userID.String())).
+		Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "message_id"}, {Name: "user_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"delivered_at": gorm.Expr("COALESCE(message_receipts.delivered_at, excluded.delivered_at)"),
+			}),
+		}).
+		Create(&receipt).Error
+}
+
+func (r *messageRepository) GetReceipts(ctx context.Context, messageID uuid.UUID) ([]models.Receipt, error) {
+	var receipts []models.Receipt
+	err := r.db.WithContext(ctx).
+		Where("message_id = ?", messageID).
+		Order("read_at ASC NULLS LAST, delivered_at ASC NULLS LAST, user_id ASC").
+		Find(&receipts).Error
+	return receipts, err
+}
+
+// loadReceiptCounts fills in the delivered and read counts of messages
+func (r *messageRepository) loadReceiptCounts(ctx context.Context, messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, len(messages))
+	index := make(map[uuid.UUID]int, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].ID
+		index[messages[i].ID] = i
+	}
+
+	var counts []struct {
+		MessageID      uuid.UUID
+		DeliveredCount int
+		ReadCount      int
+	}
+	err := r.db.WithContext(ctx).
+		Model(&models.Receipt{}).
+		Select("message_id, COUNT(delivered_at) AS delivered_count, COUNT(read_at) AS read_count").
+		Where("message_id IN ?", ids).
+		Group("message_id").
+		Scan(&counts).Error
+	if err != nil {
+		return err
+	}
+
+	for _, count := range counts {
+		if i, ok := index[count.MessageID]; ok {
+			messages[i].DeliveredCount = count.DeliveredCount
+			messages[i].ReadCount = count.ReadCount
+		}
+	}
+	return nil
+}
+
+func (r *messageRepository) HideMessage(ctx context.Context, messageID uuid.UUID, userID uuid.UUID) error {
+	return r.db.WithContext(ctx).
+		Exec("INSERT INTO hidden_messages (user_id, message_id) VALUES (?, ?) ON CONFLICT DO NOTHING", userID, messageID).
 		Error
 }
 
+func (r *messageRepository) IsHidden(ctx context.Context, messageID uuid.UUID, userID uuid.UUID) (bool, error) {
+	var hidden bool
+	err := r.db.WithContext(ctx).
+		Raw("SELECT EXISTS (SELECT 1 FROM hidden_messages WHERE user_id = ? AND message_id = ?)", userID, messageID).
+		Scan(&hidden).Error
+	return hidden, err
+}
+
 func (r *messageRepository) Update(ctx context.Context, message *models.Message) error {
 	return r.db.WithContext(ctx).Save(message).Error
 }
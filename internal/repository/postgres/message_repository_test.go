@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	pgdriver "gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/chat-backend/internal/models"
+	"github.com/chat-backend/internal/repository/repotest"
+)
+
+// TestMessageRepository runs the shared conformance suite against the
+// migrated database named by POSTGRES_TEST_DSN
+func TestMessageRepository(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+
+	db, err := gorm.Open(pgdriver.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	ctx := context.Background()
+
+	// Messages reference users and groups, so the fixtures must exist
+	users := make([]models.User, 3)
+	for i := range users {
+		name := "repotest-" + uuid.NewString()
+		users[i] = models.User{ID: uuid.New(), Username: name, Email: name + "@example.com", Password: "x"}
+		if err := db.WithContext(ctx).Create(&users[i]).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+	group := models.Group{ID: uuid.New(), Name: "repotest", CreatorID: users[0].ID}
+	if err := db.WithContext(ctx).Create(&group).Error; err != nil {
+		t.Fatalf("create group: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Delete(&group)
+		for i := range users {
+			db.Delete(&users[i])
+		}
+	})
+
+	fixtures := repotest.Fixtures{
+		UserA:   users[0].ID,
+		UserB:   users[1].ID,
+		UserC:   users[2].ID,
+		GroupID: group.ID,
+	}
+	if err := repotest.TestMessageRepository(ctx, NewMessageRepository(db), fixtures); err != nil {
+		t.Fatal(err)
+	}
+}
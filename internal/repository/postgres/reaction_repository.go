@@ -0,0 +1,65 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/chat-backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type reactionRepository struct {
+	db *gorm.DB
+}
+
+func NewReactionRepository(db *gorm.DB) *reactionRepository {
+	return &reactionRepository{db: db}
+}
+
+func (r *reactionRepository) Add(ctx context.Context, reaction *models.Reaction) (bool, error) {
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(reaction)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *reactionRepository) Remove(ctx context.Context, messageID, userID uuid.UUID, emoji string) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Where("message_id = ? AND user_id = ? AND emoji = ?", messageID, userID, emoji).
+		Delete(&models.Reaction{})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *reactionRepository) Summarize(ctx context.Context, messageIDs []uuid.UUID, viewer uuid.UUID) (map[uuid.UUID][]models.ReactionSummary, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+
+	var rows []struct {
+		MessageID   uuid.UUID
+		Emoji       string
+		Count       int
+		ReactedByMe bool
+		FirstAt     time.Time
+	}
+	err := r.db.WithContext(ctx).
+		Model(&models.Reaction{}).
+		Select("message_id, emoji, COUNT(*) AS count, BOOL_OR(user_id = ?) AS reacted_by_me, MIN(created_at) AS first_at", viewer).
+		Where("message_id IN ?", messageIDs).
+		Group("message_id, emoji").
+		Order("first_at ASC, emoji ASC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make(map[uuid.UUID][]models.ReactionSummary)
+	for _, row := range rows {
+		summaries[row.MessageID] = append(summaries[row.MessageID], models.ReactionSummary{
+			Emoji:       row.Emoji,
+			Count:       row.Count,
+			ReactedByMe: row.ReactedByMe,
+		})
+	}
+	return summaries, nil
+}
@@ -0,0 +1,36 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/chat-backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type revisionRepository struct {
+	db *gorm.DB
+}
+
+func NewRevisionRepository(db *gorm.DB) *revisionRepository {
+	return &revisionRepository{db: db}
+}
+
+func (r *revisionRepository) Create(ctx context.Context, revision *models.MessageRevision) error {
+	return r.db.WithContext(ctx).Create(revision).Error
+}
+
+func (r *revisionRepository) GetByMessageID(ctx context.Context, messageID uuid.UUID) ([]models.MessageRevision, error) {
+	var revisions []models.MessageRevision
+	err := r.db.WithContext(ctx).
+		Where("message_id = ?", messageID).
+		Order("created_at ASC, replaced_at ASC").
+		Find(&revisions).Error
+	return revisions, err
+}
+
+func (r *revisionRepository) DeleteByMessageID(ctx context.Context, messageID uuid.UUID) error {
+	return r.db.WithContext(ctx).
+		Where("message_id = ?", messageID).
+		Delete(&models.MessageRevision{}).Error
+}
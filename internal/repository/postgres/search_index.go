@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/chat-backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// searchConfig is the text search configuration of message_search. It does
+// no stemming, which suits chats in any language.
+const searchConfig = "simple"
+
+// headlineOptions shapes the snippets returned by Search
+const headlineOptions = "StartSel=" + models.HighlightStart + ", StopSel=" + models.HighlightEnd +
+	", MaxWords=30, MinWords=10, MaxFragments=2, FragmentDelimiter=\" … \""
+
+// searchEntry is a row of message_search; search_vector is generated
+type searchEntry struct {
+	MessageID      uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	ConversationID string     `gorm:"not null"`
+	SenderID       uuid.UUID  `gorm:"type:uuid;not null"`
+	RecipientID    *uuid.UUID `gorm:"type:uuid"`
+	GroupID        *uuid.UUID `gorm:"type:uuid"`
+	Content        string     `gorm:"not null"`
+	ContentType    string     `gorm:"not null"`
+	Timestamp      time.Time  `gorm:"not null"`
+}
+
+func (searchEntry) TableName() string {
+	return "message_search"
+}
+
+type searchIndex struct {
+	db *gorm.DB
+}
+
+func NewSearchIndex(db *gorm.DB) *searchIndex {
+	return &searchIndex{db: db}
+}
+
+func (r *searchIndex) Index(ctx context.Context, message *models.Message) error {
+	entry := searchEntry{
+		MessageID:      message.ID,
+		ConversationID: message.ConversationID(),
+		SenderID:       message.SenderID,
+		RecipientID:    message.RecipientID,
+		GroupID:        message.GroupID,
+		Content:        message.Content,
+		ContentType:    message.ContentType,
+		Timestamp:      message.Timestamp,
+	}
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "message_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"content", "content_type"}),
+		}).
+		Create(&entry).Error
+}
+
+func (r *searchIndex) Remove(ctx context.Context, messageID uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&searchEntry{}, "message_id = ?", messageID).Error
+}
+
+func (r *searchIndex) Hide(ctx context.Context, messageID, userID uuid.UUID) error {
+	return r.db.WithContext(ctx).
+		Model(&searchEntry{}).
+		Where("message_id = ? AND NOT (?::uuid = ANY(hidden_for))", messageID, userID).
+		Update("hidden_for", gorm.Expr("array_append(hidden_for, ?::uuid)", userID)).Error
+}
+
+func (r *searchIndex) Search(ctx context.Context, query models.SearchQuery) ([]models.SearchHit, error) {
+	scope := r.db.Where("FALSE")
+	if len(query.GroupIDs) > 0 {
+		scope = scope.Or("group_id IN ?", query.GroupIDs)
+	}
+	if query.IncludeDirect {
+		scope = scope.Or("group_id IS NULL AND (sender_id = ? OR recipient_id = ?)", query.UserID, query.UserID)
+	}
+
+	db := r.db.WithContext(ctx).
+		Table("message_search, websearch_to_tsquery(?, ?) AS q", searchConfig, query.Text).
+		Select(`message_id, conversation_id, sender_id, recipient_id, group_id, content_type, timestamp,
+			ts_headline(?, content, q, ?) AS snippet`, searchConfig, headlineOptions).
+		Where("search_vector @@ q").
+		Where(scope).
+		Where("NOT (?::uuid = ANY(hidden_for))", query.UserID)
+
+	if query.SenderID != nil {
+		db = db.Where("sender_id = ?", *query.SenderID)
+	}
+	if query.ContentType != "" {
+		db = db.Where("content_type = ?", query.ContentType)
+	}
+	if query.From != nil {
+		db = db.Where("timestamp >= ?", *query.From)
+	}
+	if query.To != nil {
+		db = db.Where("timestamp < ?", *query.To)
+	}
+	if query.Before != nil {
+		db = db.Where("(timestamp, message_id) < (?, ?)", query.Before.Timestamp, query.Before.ID)
+	}
+
+	var hits []models.SearchHit
+	err := db.Order("timestamp DESC, message_id DESC").Limit(query.Limit).Scan(&hits).Error
+	return hits, err
+}
@@ -0,0 +1,113 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/chat-backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type threadRepository struct {
+	db *gorm.DB
+}
+
+func NewThreadRepository(db *gorm.DB) *threadRepository {
+	return &threadRepository{db: db}
+}
+
+func (r *threadRepository) RecordReply(ctx context.Context, reply *models.Message) error {
+	thread := models.Thread{
+		ParentID:    *reply.ReplyToID,
+		ReplyCount:  1,
+		LastReplyID: reply.ID,
+		LastReplyAt: reply.Timestamp,
+	}
+	// A reply that arrives out of order still counts but does not become
+	// the last reply
+	newer := "excluded.last_reply_at > message_threads.last_reply_at"
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "parent_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"reply_count":   gorm.Expr("message_threads.reply_count + 1"),
+				"last_reply_id": gorm.Expr("CASE WHEN " + newer + " THEN excluded.last_reply_id ELSE message_threads.last_reply_id END"),
+				"last_reply_at": gorm.Expr("GREATEST(message_threads.last_reply_at, excluded.last_reply_at)"),
+			}),
+		}).
+		Create(&thread).Error
+}
+
+func (r *threadRepository) GetThreads(ctx context.Context, parentIDs []uuid.UUID) (map[uuid.UUID]models.Thread, error) {
+	if len(parentIDs) == 0 {
+		return nil, nil
+	}
+
+	var threads []models.Thread
+	if err := r.db.WithContext(ctx).Where("parent_id IN ?", parentIDs).Find(&threads).Error; err != nil {
+		return nil, err
+	}
+
+	result := make(map[uuid.UUID]models.Thread, len(threads))
+	for _, thread := range threads {
+		result[thread.ParentID] = thread
+	}
+	return result, nil
+}
+
+func (r *threadRepository) Follow(ctx context.Context, parentID, userID uuid.UUID, following bool) error {
+	follower := models.ThreadFollower{ParentID: parentID, UserID: userID, Following: following, UpdatedAt: time.Now()}
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "parent_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"following", "updated_at"}),
+		}).
+		Create(&follower).Error
+}
+
+func (r *threadRepository) FollowByDefault(ctx context.Context, parentID uuid.UUID, userIDs []uuid.UUID) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	followers := make([]models.ThreadFollower, len(userIDs))
+	for i, userID := range userIDs {
+		followers[i] = models.ThreadFollower{ParentID: parentID, UserID: userID, Following: true, UpdatedAt: now}
+	}
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&followers).Error
+}
+
+func (r *threadRepository) GetFollowers(ctx context.Context, parentID uuid.UUID) ([]uuid.UUID, error) {
+	var userIDs []uuid.UUID
+	err := r.db.WithContext(ctx).
+		Model(&models.ThreadFollower{}).
+		Where("parent_id = ? AND following", parentID).
+		Pluck("user_id", &userIDs).Error
+	return userIDs, err
+}
+
+func (r *threadRepository) GetFollowed(ctx context.Context, userID uuid.UUID, parentIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	if len(parentIDs) == 0 {
+		return nil, nil
+	}
+
+	var followed []uuid.UUID
+	err := r.db.WithContext(ctx).
+		Model(&models.ThreadFollower{}).
+		Where("user_id = ? AND parent_id IN ? AND following", userID, parentIDs).
+		Pluck("parent_id", &followed).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[uuid.UUID]bool, len(followed))
+	for _, parentID := range followed {
+		result[parentID] = true
+	}
+	return result, nil
+}
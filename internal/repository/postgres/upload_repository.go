@@ -0,0 +1,153 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/chat-backend/internal/models"
+	"github.com/chat-backend/internal/repository"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type uploadRepository struct {
+	db *gorm.DB
+}
+
+func NewUploadRepository(db *gorm.DB) *uploadRepository {
+	return &uploadRepository{db: db}
+}
+
+func (r *uploadRepository) Create(ctx context.Context, upload *models.Upload) error {
+	return r.db.WithContext(ctx).Create(upload).Error
+}
+
+func (r *uploadRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Upload, error) {
+	var upload models.Upload
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&upload).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (r *uploadRepository) AppendChunk(ctx context.Context, chunk *models.UploadChunk, expiresAt time.Time) (bool, error) {
+	appended := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// The conditional update locks the upload row, so concurrent chunks
+		// for the same offset and the janitor are serialised behind it
+		result := tx.Model(&models.Upload{}).
+			Where("id = ? AND upload_offset = ? AND upload_offset + ? <= size AND expires_at > ?",
+				chunk.UploadID, chunk.Offset, chunk.Size, time.Now()).
+			Updates(map[string]interface{}{
+				"upload_offset": gorm.Expr("upload_offset + ?", chunk.Size),
+				"expires_at":    expiresAt,
+				"updated_at":    time.Now(),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errNotAppended
+		}
+		if err := tx.Create(chunk).Error; err != nil {
+			return err
+		}
+		appended = true
+		return nil
+	})
+	if errors.Is(err, errNotAppended) {
+		return false, nil
+	}
+	return appended, err
+}
+
+// errNotAppended rolls back an AppendChunk whose offset did not match
+var errNotAppended = errors.New("chunk not appended")
+
+func (r *uploadRepository) GetChunks(ctx context.Context, uploadID uuid.UUID) ([]models.UploadChunk, error) {
+	var chunks []models.UploadChunk
+	err := r.db.WithContext(ctx).
+		Where("upload_id = ?", uploadID).
+		Order("chunk_offset").
+		Find(&chunks).Error
+	return chunks, err
+}
+
+func (r *uploadRepository) Delete(ctx context.Context, id uuid.UUID) ([]models.UploadChunk, error) {
+	var chunks []models.UploadChunk
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Wait for a chunk being appended, so its row is deleted with the rest
+		var locked []uuid.UUID
+		err := tx.Model(&models.Upload{}).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", id).
+			Pluck("id", &locked).Error
+		if err != nil {
+			return err
+		}
+		if len(locked) == 0 {
+			return repository.ErrNotFound
+		}
+		var deleted int64
+		chunks, deleted, err = r.deleteUploads(tx, locked)
+		if err == nil && deleted == 0 {
+			return repository.ErrNotFound
+		}
+		return err
+	})
+	return chunks, err
+}
+
+func (r *uploadRepository) DeleteExpired(ctx context.Context, before time.Time, limit int) ([]models.UploadChunk, int, error) {
+	var chunks []models.UploadChunk
+	var deleted int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Skip uploads a chunk is being appended to; they are no longer
+		// expired once it commits
+		var ids []uuid.UUID
+		err := tx.Model(&models.Upload{}).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("expires_at < ?", before).
+			Order("expires_at").
+			Limit(limit).
+			Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		chunks, deleted, err = r.deleteUploads(tx, ids)
+		return err
+	})
+	return chunks, int(deleted), err
+}
+
+// deleteUploads deletes uploads within a transaction and returns their
+// chunks along with how many uploads were deleted
+func (r *uploadRepository) deleteUploads(tx *gorm.DB, ids []uuid.UUID) ([]models.UploadChunk, int64, error) {
+	var chunks []models.UploadChunk
+	err := tx.Where("upload_id IN ?", ids).Order("upload_id, chunk_offset").Find(&chunks).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	// Chunks are removed by the foreign key cascade
+	result := tx.Where("id IN ?", ids).Delete(&models.Upload{})
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+	return chunks, result.RowsAffected, nil
+}
+
+func (r *uploadRepository) PendingSize(ctx context.Context, ownerID uuid.UUID) (int64, error) {
+	var total int64
+	err := r.db.WithContext(ctx).
+		Model(&models.Upload{}).
+		Select("COALESCE(SUM(size), 0)").
+		Where("owner_id = ? AND expires_at > ?", ownerID, time.Now()).
+		Scan(&total).Error
+	return total, err
+}
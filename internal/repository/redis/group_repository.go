@@ -0,0 +1,120 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"github.com/chat-backend/internal/models"
+	"github.com/chat-backend/internal/repository"
+)
+
+const (
+	groupMembersKeyPrefix        = "group:members:"
+	groupMembersVersionKeyPrefix = "group:members:ver:"
+	groupMembersTTL              = 10 * time.Minute
+)
+
+// cachedGroupRepository caches group member sets in Redis so that every
+// server instance sees the same membership. Cached sets are keyed by a
+// per-group version that is bumped after every membership change, so a read
+// that raced a change can only fill a key no one reads any more.
+type cachedGroupRepository struct {
+	repository.GroupRepository
+	client *redis.Client
+}
+
+func NewCachedGroupRepository(next repository.GroupRepository, client *redis.Client) *cachedGroupRepository {
+	return &cachedGroupRepository{
+		GroupRepository: next,
+		client:          client,
+	}
+}
+
+func groupMembersKey(groupID uuid.UUID, version int64) string {
+	return fmt.Sprintf("%s%s:%d", groupMembersKeyPrefix, groupID.String(), version)
+}
+
+func groupMembersVersionKey(groupID uuid.UUID) string {
+	return fmt.Sprintf("%s%s", groupMembersVersionKeyPrefix, groupID.String())
+}
+
+func (r *cachedGroupRepository) GetMembers(ctx context.Context, groupID uuid.UUID) ([]models.GroupMember, error) {
+	// The version is read before the database, so a change committed after
+	// the read below bumps it past the key filled here
+	version, err := r.client.Get(ctx, groupMembersVersionKey(groupID)).Int64()
+	if err != nil && err != redis.Nil {
+		logrus.WithError(err).Warn("Failed to read group members cache version")
+		return r.GroupRepository.GetMembers(ctx, groupID)
+	}
+	key := groupMembersKey(groupID, version)
+
+	data, err := r.client.Get(ctx, key).Bytes()
+	if err == nil {
+		var members []models.GroupMember
+		if err := json.Unmarshal(data, &members); err == nil {
+			return members, nil
+		}
+	} else if err != redis.Nil {
+		logrus.WithError(err).Warn("Failed to read cached group members")
+	}
+
+	members, err := r.GroupRepository.GetMembers(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err := json.Marshal(members); err == nil {
+		if err := r.client.Set(ctx, key, data, groupMembersTTL).Err(); err != nil {
+			logrus.WithError(err).Warn("Failed to cache group members")
+		}
+	}
+
+	return members, nil
+}
+
+func (r *cachedGroupRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	if err := r.GroupRepository.Delete(ctx, id); err != nil {
+		return err
+	}
+	r.invalidate(ctx, id)
+	return nil
+}
+
+func (r *cachedGroupRepository) AddMember(ctx context.Context, groupID, userID uuid.UUID, role string) error {
+	if err := r.GroupRepository.AddMember(ctx, groupID, userID, role); err != nil {
+		return err
+	}
+	r.invalidate(ctx, groupID)
+	return nil
+}
+
+func (r *cachedGroupRepository) RemoveMember(ctx context.Context, groupID, userID uuid.UUID) error {
+	if err := r.GroupRepository.RemoveMember(ctx, groupID, userID); err != nil {
+		return err
+	}
+	r.invalidate(ctx, groupID)
+	return nil
+}
+
+func (r *cachedGroupRepository) UpdateMemberRole(ctx context.Context, groupID, userID uuid.UUID, role string) error {
+	if err := r.GroupRepository.UpdateMemberRole(ctx, groupID, userID, role); err != nil {
+		return err
+	}
+	r.invalidate(ctx, groupID)
+	return nil
+}
+
+// invalidate bumps the group's cache version. The database change has
+// already committed, so a failure is logged rather than returned; the stale
+// set expires with groupMembersTTL.
+func (r *cachedGroupRepository) invalidate(ctx context.Context, groupID uuid.UUID) {
+	if err := r.client.Incr(ctx, groupMembersVersionKey(groupID)).Err(); err != nil {
+		logrus.WithError(err).WithField("group_id", groupID).Error("Failed to invalidate group members cache")
+	}
+}
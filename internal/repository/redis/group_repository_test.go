@@ -0,0 +1,135 @@
+package redis
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/chat-backend/internal/models"
+	"github.com/chat-backend/internal/repository"
+)
+
+// memGroups is the database behind the cache. onRead, when set, runs after
+// GetMembers has read the members and before it returns them.
+type memGroups struct {
+	repository.GroupRepository
+	mu      sync.Mutex
+	members map[uuid.UUID][]uuid.UUID
+	reads   int
+	onRead  func()
+}
+
+func (r *memGroups) GetMembers(ctx context.Context, groupID uuid.UUID) ([]models.GroupMember, error) {
+	r.mu.Lock()
+	r.reads++
+	members := make([]models.GroupMember, len(r.members[groupID]))
+	for i, userID := range r.members[groupID] {
+		members[i] = models.GroupMember{GroupID: groupID, UserID: userID}
+	}
+	onRead := r.onRead
+	r.onRead = nil
+	r.mu.Unlock()
+
+	if onRead != nil {
+		onRead()
+	}
+	return members, nil
+}
+
+func (r *memGroups) RemoveMember(ctx context.Context, groupID, userID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kept := r.members[groupID][:0]
+	for _, id := range r.members[groupID] {
+		if id != userID {
+			kept = append(kept, id)
+		}
+	}
+	r.members[groupID] = kept
+	return nil
+}
+
+func memberIDs(t *testing.T, repo repository.GroupRepository, groupID uuid.UUID) []uuid.UUID {
+	t.Helper()
+	members, err := repo.GetMembers(context.Background(), groupID)
+	if err != nil {
+		t.Fatalf("GetMembers: %v", err)
+	}
+	ids := make([]uuid.UUID, len(members))
+	for i, member := range members {
+		ids[i] = member.UserID
+	}
+	return ids
+}
+
+func TestGroupMembersCacheInvalidation(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	groupID, alice, bob := uuid.New(), uuid.New(), uuid.New()
+	db := &memGroups{members: map[uuid.UUID][]uuid.UUID{groupID: {alice, bob}}}
+	repo := NewCachedGroupRepository(db, client)
+	ctx := context.Background()
+
+	memberIDs(t, repo, groupID)
+	memberIDs(t, repo, groupID)
+	if db.reads != 1 {
+		t.Fatalf("database read %d times, want once", db.reads)
+	}
+
+	if err := repo.RemoveMember(ctx, groupID, bob); err != nil {
+		t.Fatalf("RemoveMember: %v", err)
+	}
+	if ids := memberIDs(t, repo, groupID); len(ids) != 1 || ids[0] != alice {
+		t.Fatalf("members after removal: got %v, want [%s]", ids, alice)
+	}
+}
+
+func TestGroupMembersCacheIgnoresStaleFill(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	groupID, alice, bob := uuid.New(), uuid.New(), uuid.New()
+	db := &memGroups{members: map[uuid.UUID][]uuid.UUID{groupID: {alice, bob}}}
+	repo := NewCachedGroupRepository(db, client)
+	ctx := context.Background()
+
+	// Bob is removed after a read loaded him but before it filled the cache
+	db.onRead = func() {
+		if err := repo.RemoveMember(ctx, groupID, bob); err != nil {
+			t.Errorf("RemoveMember: %v", err)
+		}
+	}
+	if ids := memberIDs(t, repo, groupID); len(ids) != 2 {
+		t.Fatalf("racing read: got %v, want both members", ids)
+	}
+
+	if ids := memberIDs(t, repo, groupID); len(ids) != 1 || ids[0] != alice {
+		t.Fatalf("members after removal: got %v, want [%s]", ids, alice)
+	}
+}
+
+func TestGroupMembershipChangeSucceedsWithoutCache(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	groupID, alice := uuid.New(), uuid.New()
+	db := &memGroups{members: map[uuid.UUID][]uuid.UUID{groupID: {alice}}}
+	repo := NewCachedGroupRepository(db, client)
+
+	// The change is committed even though the cache cannot be invalidated
+	server.Close()
+	if err := repo.RemoveMember(context.Background(), groupID, alice); err != nil {
+		t.Fatalf("RemoveMember: got %v, want nil", err)
+	}
+	if ids := memberIDs(t, repo, groupID); len(ids) != 0 {
+		t.Fatalf("members: got %v, want none", ids)
+	}
+}
@@ -13,15 +13,22 @@ import (
 
 const (
 	userStatusKeyPrefix = "user:status:"
-	statusTTL           = 24 * time.Hour
+	defaultStatusTTL    = 90 * time.Second
 )
 
+// statusRepository stores presence with a short TTL. Live connections keep
+// refreshing it, so a user whose connections all die silently drops back to
+// offline once the TTL runs out.
 type statusRepository struct {
 	client *redis.Client
+	ttl    time.Duration
 }
 
-func NewStatusRepository(client *redis.Client) *statusRepository {
-	return &statusRepository{client: client}
+func NewStatusRepository(client *redis.Client, ttl time.Duration) *statusRepository {
+	if ttl <= 0 {
+		ttl = defaultStatusTTL
+	}
+	return &statusRepository{client: client, ttl: ttl}
 }
 
 func (r *statusRepository) UpdateStatus(ctx context.Context, userID uuid.UUID, status string) error {
@@ -31,7 +38,7 @@ func (r *statusRepository) UpdateStatus(ctx context.Context, userID uuid.UUID, s
 	timeoutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	err := r.client.Set(timeoutCtx, key, status, statusTTL).Err()
+	err := r.client.Set(timeoutCtx, key, status, r.ttl).Err()
 	if err != nil {
 		return fmt.Errorf("failed to update status: %w", err)
 	}
@@ -0,0 +1,76 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const unreadKeyPrefix = "user:unread:"
+
+// unreadRepository keeps one hash per user mapping conversation IDs to the
+// number of unread messages. Conversations with nothing unread have no field.
+type unreadRepository struct {
+	client *redis.Client
+}
+
+func NewUnreadRepository(client *redis.Client) *unreadRepository {
+	return &unreadRepository{client: client}
+}
+
+func unreadKey(userID uuid.UUID) string {
+	return fmt.Sprintf("%s%s", unreadKeyPrefix, userID.String())
+}
+
+func (r *unreadRepository) Increment(ctx context.Context, conversationID string, userIDs []uuid.UUID) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	pipe := r.client.Pipeline()
+	for _, userID := range userIDs {
+		pipe.HIncrBy(ctx, unreadKey(userID), conversationID, 1)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to increment unread counts: %w", err)
+	}
+	return nil
+}
+
+func (r *unreadRepository) Set(ctx context.Context, userID uuid.UUID, conversationID string, count int) error {
+	var err error
+	if count > 0 {
+		err = r.client.HSet(ctx, unreadKey(userID), conversationID, count).Err()
+	} else {
+		err = r.client.HDel(ctx, unreadKey(userID), conversationID).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set unread count: %w", err)
+	}
+	return nil
+}
+
+func (r *unreadRepository) Get(ctx context.Context, userID uuid.UUID, conversationIDs []string) (map[string]int, error) {
+	counts := make(map[string]int)
+	if len(conversationIDs) == 0 {
+		return counts, nil
+	}
+
+	values, err := r.client.HMGet(ctx, unreadKey(userID), conversationIDs...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unread counts: %w", err)
+	}
+	for i, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if count, err := strconv.Atoi(raw); err == nil && count > 0 {
+			counts[conversationIDs[i]] = count
+		}
+	}
+	return counts, nil
+}
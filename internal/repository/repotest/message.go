@@ -0,0 +1,499 @@
+// Package repotest implements a conformance suite shared by the message
+// store backends.
+//
+// Each backend's message_repository_test.go connects to a live instance,
+// named by an environment variable, and calls TestMessageRepository:
+//
+//	if err := repotest.TestMessageRepository(ctx, repo, fixtures); err != nil {
+//		t.Fatal(err)
+//	}
+package repotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/chat-backend/internal/models"
+	"github.com/chat-backend/internal/repository"
+)
+
+// Fixtures names existing rows the suite writes messages against. The users
+// and group must be fresh so that no other messages reference them, and the
+// group should have no members other than the users listed here.
+type Fixtures struct {
+	UserA   uuid.UUID
+	UserB   uuid.UUID
+	UserC   uuid.UUID
+	GroupID uuid.UUID
+}
+
+// TestMessageRepository checks that repo behaves like every other message
+// store. It returns the first mismatch found, and deletes the messages it
+// created before returning.
+func TestMessageRepository(ctx context.Context, repo repository.MessageRepository, fixtures Fixtures) error {
+	s := &suite{repo: repo, f: fixtures}
+	defer s.cleanup(ctx)
+	return s.run(ctx)
+}
+
+type suite struct {
+	repo    repository.MessageRepository
+	f       Fixtures
+	base    time.Time
+	created []*models.Message
+}
+
+func (s *suite) run(ctx context.Context) error {
+	// Millisecond precision is the lowest common denominator of the backends
+	s.base = time.Now().Add(-time.Hour).UTC().Truncate(time.Millisecond)
+
+	a, b, c, g := s.f.UserA, s.f.UserB, s.f.UserC, s.f.GroupID
+	steps := []struct {
+		sender    uuid.UUID
+		recipient *uuid.UUID
+		group     *uuid.UUID
+	}{
+		{a, &b, nil}, // 0
+		{a, &b, nil}, // 1
+		{a, &b, nil}, // 2
+		{b, &a, nil}, // 3
+		{a, &c, nil}, // 4
+		{b, nil, &g}, // 5
+	}
+	for i, step := range steps {
+		if err := s.create(ctx, i, step.sender, step.recipient, step.group, nil, nil); err != nil {
+			return err
+		}
+	}
+	// 6: a reply with attachments
+	if err := s.create(ctx, 6, a, &b, nil, &s.created[0].ID, []string{"attachment-1", "attachment-2"}); err != nil {
+		return err
+	}
+
+	checks := []func(context.Context) error{
+		s.checkGetByID,
+		s.checkMessagesBetween,
+		s.checkUserMessages,
+		s.checkGroupMessages,
+		s.checkUserMessagesSince,
+		s.checkReplies,
+		s.checkReceipts,
+		s.checkUpdate,
+		s.checkTombstone,
+		s.checkHide,
+		s.checkDelete,
+	}
+	for _, check := range checks {
+		if err := check(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *suite) at(i int) time.Time {
+	return s.base.Add(time.Duration(i) * time.Second)
+}
+
+func (s *suite) create(ctx context.Context, i int, sender uuid.UUID, recipient, group, replyTo *uuid.UUID, attachments []string) error {
+	message := models.NewMessage()
+	message.SenderID = sender
+	message.RecipientID = recipient
+	message.GroupID = group
+	message.Content = fmt.Sprintf("message %d", i)
+	message.ContentType = models.ContentTypeText
+	message.Timestamp = s.at(i)
+	message.ReplyToID = replyTo
+	message.Attachments = attachments
+
+	if err := s.repo.Create(ctx, message); err != nil {
+		return fmt.Errorf("Create(message %d): %w", i, err)
+	}
+	s.created = append(s.created, message)
+	return nil
+}
+
+func (s *suite) cleanup(ctx context.Context) {
+	for _, message := range s.created {
+		s.repo.Delete(ctx, message.ID)
+	}
+}
+
+func (s *suite) checkGetByID(ctx context.Context) error {
+	want := s.created[6]
+	got, err := s.repo.GetByID(ctx, want.ID)
+	if err != nil {
+		return fmt.Errorf("GetByID: %w", err)
+	}
+	if err := sameMessage(got, want); err != nil {
+		return fmt.Errorf("GetByID: %w", err)
+	}
+
+	if _, err := s.repo.GetByID(ctx, uuid.New()); !errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("GetByID(unknown): got error %v, want ErrNotFound", err)
+	}
+	return nil
+}
+
+func (s *suite) checkMessagesBetween(ctx context.Context) error {
+	a, b := s.f.UserA, s.f.UserB
+
+	for _, pair := range [][2]uuid.UUID{{a, b}, {b, a}} {
+		got, err := s.repo.GetMessagesBetween(ctx, pair[0], pair[1], models.Page{Limit: 10})
+		if err != nil {
+			return fmt.Errorf("GetMessagesBetween: %w", err)
+		}
+		if err := sameOrder(got, s.pick(6, 3, 2, 1, 0)); err != nil {
+			return fmt.Errorf("GetMessagesBetween(%s, %s): %w", pair[0], pair[1], err)
+		}
+	}
+
+	cursor := models.MessageCursor(s.created[2])
+
+	got, err := s.repo.GetMessagesBetween(ctx, a, b, models.Page{Before: &cursor, Limit: 10})
+	if err != nil {
+		return fmt.Errorf("GetMessagesBetween(before): %w", err)
+	}
+	if err := sameOrder(got, s.pick(1, 0)); err != nil {
+		return fmt.Errorf("GetMessagesBetween(before): %w", err)
+	}
+
+	got, err = s.repo.GetMessagesBetween(ctx, a, b, models.Page{After: &cursor, Limit: 10})
+	if err != nil {
+		return fmt.Errorf("GetMessagesBetween(after): %w", err)
+	}
+	if err := sameOrder(got, s.pick(6, 3)); err != nil {
+		return fmt.Errorf("GetMessagesBetween(after): %w", err)
+	}
+
+	// A forward page holds the messages right after the cursor
+	got, err = s.repo.GetMessagesBetween(ctx, a, b, models.Page{After: &cursor, Limit: 1})
+	if err != nil {
+		return fmt.Errorf("GetMessagesBetween(after, limit): %w", err)
+	}
+	if err := sameOrder(got, s.pick(3)); err != nil {
+		return fmt.Errorf("GetMessagesBetween(after, limit): %w", err)
+	}
+
+	got, err = s.repo.GetMessagesBetween(ctx, a, b, models.Page{Limit: 2})
+	if err != nil {
+		return fmt.Errorf("GetMessagesBetween(limit): %w", err)
+	}
+	if err := sameOrder(got, s.pick(6, 3)); err != nil {
+		return fmt.Errorf("GetMessagesBetween(limit): %w", err)
+	}
+	return nil
+}
+
+func (s *suite) checkUserMessages(ctx context.Context) error {
+	got, err := s.repo.GetUserMessages(ctx, s.f.UserC, models.Page{Limit: 10})
+	if err != nil {
+		return fmt.Errorf("GetUserMessages: %w", err)
+	}
+	if err := sameOrder(got, s.pick(4)); err != nil {
+		return fmt.Errorf("GetUserMessages: %w", err)
+	}
+	return nil
+}
+
+func (s *suite) checkGroupMessages(ctx context.Context) error {
+	got, err := s.repo.GetGroupMessages(ctx, s.f.GroupID, models.Page{Limit: 10})
+	if err != nil {
+		return fmt.Errorf("GetGroupMessages: %w", err)
+	}
+	if err := sameOrder(got, s.pick(5)); err != nil {
+		return fmt.Errorf("GetGroupMessages: %w", err)
+	}
+	return nil
+}
+
+func (s *suite) checkUserMessagesSince(ctx context.Context) error {
+	since := models.Cursor{Timestamp: s.at(0).Add(500 * time.Millisecond), ID: uuid.Nil.String()}
+
+	got, err := s.repo.GetUserMessagesSince(ctx, s.f.UserB, []uuid.UUID{s.f.GroupID}, since, 100)
+	if err != nil {
+		return fmt.Errorf("GetUserMessagesSince: %w", err)
+	}
+	if err := sameOrder(got, s.pick(1, 2, 3, 5, 6)); err != nil {
+		return fmt.Errorf("GetUserMessagesSince: %w", err)
+	}
+
+	got, err = s.repo.GetUserMessagesSince(ctx, s.f.UserB, nil, since, 2)
+	if err != nil {
+		return fmt.Errorf("GetUserMessagesSince(no groups): %w", err)
+	}
+	if err := sameOrder(got, s.pick(1, 2)); err != nil {
+		return fmt.Errorf("GetUserMessagesSince(no groups): %w", err)
+	}
+
+	// The cursor's ID breaks ties: a message sharing the cursor's timestamp
+	// is only left out when it sorts at or before the cursor's ID
+	got, err = s.repo.GetUserMessagesSince(ctx, s.f.UserB, nil, models.Cursor{Timestamp: s.at(1), ID: uuid.Nil.String()}, 2)
+	if err != nil {
+		return fmt.Errorf("GetUserMessagesSince(same timestamp): %w", err)
+	}
+	if err := sameOrder(got, s.pick(1, 2)); err != nil {
+		return fmt.Errorf("GetUserMessagesSince(same timestamp): %w", err)
+	}
+
+	got, err = s.repo.GetUserMessagesSince(ctx, s.f.UserB, nil, s.cursor(1), 2)
+	if err != nil {
+		return fmt.Errorf("GetUserMessagesSince(at message): %w", err)
+	}
+	if err := sameOrder(got, s.pick(2, 3)); err != nil {
+		return fmt.Errorf("GetUserMessagesSince(at message): %w", err)
+	}
+	return nil
+}
+
+// cursor returns the position of the created message with the given index
+func (s *suite) cursor(i int) models.Cursor {
+	return models.Cursor{Timestamp: s.created[i].Timestamp, ID: s.created[i].ID.String()}
+}
+
+func (s *suite) checkReplies(ctx context.Context) error {
+	got, err := s.repo.GetReplies(ctx, s.created[0].ID, models.Page{Limit: 10})
+	if err != nil {
+		return fmt.Errorf("GetReplies: %w", err)
+	}
+	if err := sameOrder(got, s.pick(6)); err != nil {
+		return fmt.Errorf("GetReplies: %w", err)
+	}
+
+	got, err = s.repo.GetReplies(ctx, s.created[1].ID, models.Page{Limit: 10})
+	if err != nil {
+		return fmt.Errorf("GetReplies(no replies): %w", err)
+	}
+	if err := sameOrder(got, nil); err != nil {
+		return fmt.Errorf("GetReplies(no replies): %w", err)
+	}
+	return nil
+}
+
+func (s *suite) checkReceipts(ctx context.Context) error {
+	id, userID := s.created[0].ID, s.f.UserB
+
+	if err := s.repo.MarkAsDelivered(ctx, id, userID); err != nil {
+		return fmt.Errorf("MarkAsDelivered: %w", err)
+	}
+	if err := s.repo.MarkAsRead(ctx, []uuid.UUID{id}, userID); err != nil {
+		return fmt.Errorf("MarkAsRead: %w", err)
+	}
+
+	receipts, err := s.repo.GetReceipts(ctx, id)
+	if err != nil {
+		return fmt.Errorf("GetReceipts: %w", err)
+	}
+	if len(receipts) != 1 || receipts[0].UserID != userID || receipts[0].DeliveredAt == nil || receipts[0].ReadAt == nil {
+		return fmt.Errorf("GetReceipts: got %+v, want one delivered and read receipt for %s", receipts, userID)
+	}
+	readAt := *receipts[0].ReadAt
+
+	// Reading again keeps the first read time and adds no receipt
+	if err := s.repo.MarkAsRead(ctx, []uuid.UUID{id}, userID); err != nil {
+		return fmt.Errorf("MarkAsRead(again): %w", err)
+	}
+	receipts, err = s.repo.GetReceipts(ctx, id)
+	if err != nil {
+		return fmt.Errorf("GetReceipts(again): %w", err)
+	}
+	if len(receipts) != 1 || receipts[0].ReadAt == nil || !receipts[0].ReadAt.Equal(readAt) {
+		return fmt.Errorf("MarkAsRead(again): got %+v, want read_at kept at %s", receipts, readAt)
+	}
+
+	got, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("GetByID after receipts: %w", err)
+	}
+	if got.DeliveredCount != 1 || got.ReadCount != 1 {
+		return fmt.Errorf("GetByID: delivered_count = %d, read_count = %d, want 1 and 1", got.DeliveredCount, got.ReadCount)
+	}
+
+	history, err := s.repo.GetMessagesBetween(ctx, s.f.UserA, userID, models.Page{Limit: 10})
+	if err != nil {
+		return fmt.Errorf("GetMessagesBetween after receipts: %w", err)
+	}
+	for _, message := range history {
+		if message.ID == id && (message.DeliveredCount != 1 || message.ReadCount != 1) {
+			return fmt.Errorf("GetMessagesBetween: delivered_count = %d, read_count = %d, want 1 and 1",
+				message.DeliveredCount, message.ReadCount)
+		}
+	}
+	return nil
+}
+
+func (s *suite) checkUpdate(ctx context.Context) error {
+	message := *s.created[1]
+	message.Content = "edited"
+
+	if err := s.repo.Update(ctx, &message); err != nil {
+		return fmt.Errorf("Update: %w", err)
+	}
+
+	got, err := s.repo.GetByID(ctx, message.ID)
+	if err != nil {
+		return fmt.Errorf("GetByID after Update: %w", err)
+	}
+	if got.Content != "edited" {
+		return fmt.Errorf("Update: content = %q, want %q", got.Content, "edited")
+	}
+	return nil
+}
+
+func (s *suite) checkTombstone(ctx context.Context) error {
+	message := *s.created[6]
+	message.Tombstone(s.at(7))
+
+	if err := s.repo.Update(ctx, &message); err != nil {
+		return fmt.Errorf("Update(tombstone): %w", err)
+	}
+
+	got, err := s.repo.GetByID(ctx, message.ID)
+	if err != nil {
+		return fmt.Errorf("GetByID after tombstone: %w", err)
+	}
+	if !got.IsDeleted() || !got.DeletedAt.Equal(s.at(7)) || got.Content != "" || len(got.Attachments) != 0 {
+		return fmt.Errorf("Update(tombstone): deleted_at = %v, content = %q, attachments = %v, want a tombstone",
+			got.DeletedAt, got.Content, got.Attachments)
+	}
+
+	// The tombstone replaces the reply in its thread too
+	replies, err := s.repo.GetReplies(ctx, s.created[0].ID, models.Page{Limit: 10})
+	if err != nil {
+		return fmt.Errorf("GetReplies after tombstone: %w", err)
+	}
+	if len(replies) != 1 || !replies[0].IsDeleted() {
+		return fmt.Errorf("GetReplies after tombstone: got %d replies, want the tombstone", len(replies))
+	}
+	return nil
+}
+
+func (s *suite) checkHide(ctx context.Context) error {
+	a, b := s.f.UserA, s.f.UserB
+	if err := s.repo.HideMessage(ctx, s.created[3].ID, a); err != nil {
+		return fmt.Errorf("HideMessage: %w", err)
+	}
+
+	got, err := s.repo.GetMessagesBetween(ctx, a, b, models.Page{Limit: 10, Viewer: a})
+	if err != nil {
+		return fmt.Errorf("GetMessagesBetween(hidden): %w", err)
+	}
+	if err := sameOrder(got, s.pick(6, 2, 1, 0)); err != nil {
+		return fmt.Errorf("GetMessagesBetween(hidden): %w", err)
+	}
+
+	hidden, err := s.repo.IsHidden(ctx, s.created[3].ID, a)
+	if err != nil {
+		return fmt.Errorf("IsHidden: %w", err)
+	}
+	if !hidden {
+		return fmt.Errorf("IsHidden: got false for the user who hid the message")
+	}
+
+	// Hiding is per user
+	hidden, err = s.repo.IsHidden(ctx, s.created[3].ID, b)
+	if err != nil {
+		return fmt.Errorf("IsHidden(other user): %w", err)
+	}
+	if hidden {
+		return fmt.Errorf("IsHidden(other user): got true, want false")
+	}
+
+	got, err = s.repo.GetMessagesBetween(ctx, a, b, models.Page{Limit: 10, Viewer: b})
+	if err != nil {
+		return fmt.Errorf("GetMessagesBetween(other viewer): %w", err)
+	}
+	if err := sameOrder(got, s.pick(6, 3, 2, 1, 0)); err != nil {
+		return fmt.Errorf("GetMessagesBetween(other viewer): %w", err)
+	}
+
+	got, err = s.repo.GetUserMessagesSince(ctx, a, nil, s.cursor(2), 100)
+	if err != nil {
+		return fmt.Errorf("GetUserMessagesSince(hidden): %w", err)
+	}
+	if err := sameOrder(got, s.pick(4, 6)); err != nil {
+		return fmt.Errorf("GetUserMessagesSince(hidden): %w", err)
+	}
+	return nil
+}
+
+func (s *suite) checkDelete(ctx context.Context) error {
+	id := s.created[2].ID
+	if err := s.repo.Delete(ctx, id); err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+	if _, err := s.repo.GetByID(ctx, id); !errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("GetByID after Delete: got error %v, want ErrNotFound", err)
+	}
+	return nil
+}
+
+// pick returns the created messages with the given indexes, in order
+func (s *suite) pick(indexes ...int) []models.Message {
+	messages := make([]models.Message, len(indexes))
+	for i, index := range indexes {
+		messages[i] = *s.created[index]
+	}
+	return messages
+}
+
+func sameOrder(got, want []models.Message) error {
+	gotContent := make([]string, len(got))
+	for i, message := range got {
+		gotContent[i] = message.Content
+	}
+	wantContent := make([]string, len(want))
+	for i, message := range want {
+		wantContent[i] = message.Content
+	}
+
+	if len(got) != len(want) {
+		return fmt.Errorf("got %v, want %v", gotContent, wantContent)
+	}
+	for i := range got {
+		if got[i].ID != want[i].ID {
+			return fmt.Errorf("got %v, want %v", gotContent, wantContent)
+		}
+	}
+	return nil
+}
+
+func sameMessage(got, want *models.Message) error {
+	switch {
+	case got.ID != want.ID:
+		return fmt.Errorf("id = %s, want %s", got.ID, want.ID)
+	case got.SenderID != want.SenderID:
+		return fmt.Errorf("sender_id = %s, want %s", got.SenderID, want.SenderID)
+	case !sameID(got.RecipientID, want.RecipientID):
+		return fmt.Errorf("recipient_id = %v, want %v", got.RecipientID, want.RecipientID)
+	case !sameID(got.GroupID, want.GroupID):
+		return fmt.Errorf("group_id = %v, want %v", got.GroupID, want.GroupID)
+	case !sameID(got.ReplyToID, want.ReplyToID):
+		return fmt.Errorf("reply_to_id = %v, want %v", got.ReplyToID, want.ReplyToID)
+	case got.Content != want.Content:
+		return fmt.Errorf("content = %q, want %q", got.Content, want.Content)
+	case got.ContentType != want.ContentType:
+		return fmt.Errorf("content_type = %q, want %q", got.ContentType, want.ContentType)
+	case !got.Timestamp.Equal(want.Timestamp):
+		return fmt.Errorf("timestamp = %s, want %s", got.Timestamp, want.Timestamp)
+	case len(got.Attachments) != len(want.Attachments):
+		return fmt.Errorf("attachments = %v, want %v", got.Attachments, want.Attachments)
+	}
+	for i := range want.Attachments {
+		if got.Attachments[i] != want.Attachments[i] {
+			return fmt.Errorf("attachments = %v, want %v", got.Attachments, want.Attachments)
+		}
+	}
+	return nil
+}
+
+func sameID(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
@@ -0,0 +1,63 @@
+package s3
+
+import (
+	"context"
+	"io"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/pkg/errors"
+
+	"github.com/chat-backend/internal/repository"
+)
+
+// BlobStore keeps blobs as objects in a bucket of any S3-compatible store,
+// such as AWS S3 or MinIO
+type BlobStore struct {
+	client *minio.Client
+	bucket string
+}
+
+func NewBlobStore(client *minio.Client, bucket string) *BlobStore {
+	return &BlobStore{client: client, bucket: bucket}
+}
+
+// EnsureBucket creates the bucket if it does not exist yet
+func (s *BlobStore) EnsureBucket(ctx context.Context, region string) error {
+	exists, err := s.client.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return errors.Wrap(err, "failed to check bucket")
+	}
+	if exists {
+		return nil
+	}
+	if err := s.client.MakeBucket(ctx, s.bucket, minio.MakeBucketOptions{Region: region}); err != nil {
+		return errors.Wrapf(err, "failed to create bucket %s", s.bucket)
+	}
+	return nil
+}
+
+func (s *BlobStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+// Get checks that the object exists before returning it, since objects are
+// otherwise only fetched on the first read
+func (s *BlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, repository.ErrNotFound
+		}
+		return nil, err
+	}
+	return object, nil
+}
+
+func (s *BlobStore) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
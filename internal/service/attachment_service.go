@@ -0,0 +1,253 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
+	"github.com/chat-backend/internal/models"
+	"github.com/chat-backend/internal/repository"
+)
+
+// defaultMaxAttachmentSize applies when AttachmentConfig.MaxSize is unset
+const defaultMaxAttachmentSize = 25 << 20
+
+// maxFilenameLength caps the length in bytes of a stored filename
+const maxFilenameLength = 255
+
+// sniffLength is how much of an upload is read to detect its MIME type
+const sniffLength = 512
+
+var (
+	// ErrAttachmentTooLarge is returned when an upload exceeds the size limit
+	ErrAttachmentTooLarge = errors.New("attachment is too large")
+	// ErrLengthRequired is returned when an upload does not state its size
+	ErrLengthRequired = errors.New("upload size is required")
+	// ErrQuotaExceeded is returned when an upload would take its owner over
+	// their storage quota
+	ErrQuotaExceeded = errors.New("attachment storage quota exceeded")
+)
+
+// AttachmentConfig holds the upload rules read from configuration
+type AttachmentConfig struct {
+	MaxSize   int64 // Largest accepted single-request upload, in bytes
+	UserQuota int64 // Most bytes one user may store, 0 for no limit
+}
+
+type AttachmentService struct {
+	attachmentRepo repository.AttachmentRepository
+	uploadRepo     repository.UploadRepository
+	blobStore      repository.BlobStore
+	messageRepo    repository.MessageRepository
+	groupRepo      repository.GroupRepository
+	config         AttachmentConfig
+}
+
+func NewAttachmentService(
+	attachmentRepo repository.AttachmentRepository,
+	uploadRepo repository.UploadRepository,
+	blobStore repository.BlobStore,
+	messageRepo repository.MessageRepository,
+	groupRepo repository.GroupRepository,
+	config AttachmentConfig,
+) *AttachmentService {
+	if config.MaxSize <= 0 {
+		config.MaxSize = defaultMaxAttachmentSize
+	}
+	return &AttachmentService{
+		attachmentRepo: attachmentRepo,
+		uploadRepo:     uploadRepo,
+		blobStore:      blobStore,
+		messageRepo:    messageRepo,
+		groupRepo:      groupRepo,
+		config:         config,
+	}
+}
+
+// MaxSize returns the largest accepted upload, in bytes
+func (s *AttachmentService) MaxSize() int64 {
+	return s.config.MaxSize
+}
+
+// UploadInput is a file being uploaded. ContentType is the type the client
+// declared; it is only used when the content itself does not reveal one.
+type UploadInput struct {
+	OwnerID     uuid.UUID
+	Filename    string
+	ContentType string
+	Size        int64
+	Body        io.Reader
+}
+
+// Upload streams a file into the blob store and records it. The MIME type is
+// detected from the content and a SHA-256 checksum is computed on the way.
+func (s *AttachmentService) Upload(ctx context.Context, input UploadInput) (*models.Attachment, error) {
+	if input.Size <= 0 {
+		return nil, ErrLengthRequired
+	}
+	if input.Size > s.config.MaxSize {
+		return nil, ErrAttachmentTooLarge
+	}
+	if err := s.checkQuota(ctx, input.OwnerID, input.Size); err != nil {
+		return nil, err
+	}
+
+	id := uuid.New()
+	return s.store(ctx, id, "attachments/"+input.OwnerID.String()+"/"+id.String(), input)
+}
+
+// checkQuota returns ErrQuotaExceeded when storing size more bytes would take
+// the owner over their quota. Unfinished resumable uploads count at their full
+// size until they expire. Concurrent uploads may overshoot the quota by the size of one another.
+func (s *AttachmentService) checkQuota(ctx context.Context, ownerID uuid.UUID, size int64) error {
+	if s.config.UserQuota <= 0 {
+		return nil
+	}
+	stored, err := s.attachmentRepo.TotalSize(ctx, ownerID)
+	if err != nil {
+		return err
+	}
+	pending, err := s.uploadRepo.PendingSize(ctx, ownerID)
+	if err != nil {
+		return err
+	}
+	if stored+pending+size > s.config.UserQuota {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// store streams a file into the blob store under key and records it as the
+// attachment with the given ID
+func (s *AttachmentService) store(ctx context.Context, id uuid.UUID, key string, input UploadInput) (*models.Attachment, error) {
+	head := make([]byte, min(sniffLength, input.Size))
+	n, err := io.ReadFull(input.Body, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	head = head[:n]
+
+	attachment := &models.Attachment{
+		ID:          id,
+		OwnerID:     input.OwnerID,
+		Filename:    cleanFilename(input.Filename),
+		ContentType: detectContentType(head, input.ContentType),
+		Size:        input.Size,
+		StorageKey:  key,
+		CreatedAt:   time.Now(),
+	}
+
+	checksum := sha256.New()
+	body := io.TeeReader(io.MultiReader(bytes.NewReader(head), io.LimitReader(input.Body, input.Size-int64(n))), checksum)
+	if err := s.blobStore.Put(ctx, attachment.StorageKey, body, input.Size, attachment.ContentType); err != nil {
+		return nil, err
+	}
+	attachment.Checksum = hex.EncodeToString(checksum.Sum(nil))
+
+	if err := s.attachmentRepo.Create(ctx, attachment); err != nil {
+		if err := s.blobStore.Delete(ctx, attachment.StorageKey); err != nil {
+			logrus.WithError(err).WithField("key", attachment.StorageKey).Warn("Failed to delete orphaned blob")
+		}
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// GetAttachment returns an attachment's record to a user allowed to see it
+func (s *AttachmentService) GetAttachment(ctx context.Context, userID uuid.UUID, id string) (*models.Attachment, error) {
+	attachmentID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, errors.New("invalid attachment ID")
+	}
+
+	attachment, err := s.attachmentRepo.GetByID(ctx, attachmentID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, userID, attachment); err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// OpenAttachment returns an attachment's record and content to a user allowed
+// to see it. The caller closes the content.
+func (s *AttachmentService) OpenAttachment(ctx context.Context, userID uuid.UUID, id string) (*models.Attachment, io.ReadCloser, error) {
+	attachment, err := s.GetAttachment(ctx, userID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := s.blobStore.Get(ctx, attachment.StorageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return attachment, content, nil
+}
+
+// authorize lets the owner see their attachments, and participants see the
+// attachments of a message in their conversation. Unsent attachments and
+// those of deleted messages are reported as not found to everyone else.
+func (s *AttachmentService) authorize(ctx context.Context, userID uuid.UUID, attachment *models.Attachment) error {
+	if attachment.OwnerID == userID {
+		return nil
+	}
+	if attachment.MessageID == nil {
+		return repository.ErrNotFound
+	}
+
+	message, err := s.messageRepo.GetByID(ctx, *attachment.MessageID)
+	if err != nil {
+		return err
+	}
+	if message.IsDeleted() {
+		return repository.ErrNotFound
+	}
+	userIDs, err := conversationParticipants(ctx, s.groupRepo, message)
+	if err != nil {
+		return err
+	}
+	if !containsUser(userIDs, userID) {
+		return ErrNotParticipant
+	}
+	return nil
+}
+
+// cleanFilename keeps the last path element of a client-supplied filename,
+// cut to maxFilenameLength bytes
+func cleanFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == "" {
+		return "attachment"
+	}
+	for len(name) > maxFilenameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+// detectContentType sniffs the MIME type of content from its first bytes,
+// falling back to the declared type when sniffing finds nothing specific
+func detectContentType(head []byte, declared string) string {
+	sniffed := http.DetectContentType(head)
+	if sniffed != "application/octet-stream" || declared == "" {
+		return sniffed
+	}
+	mediaType, params, err := mime.ParseMediaType(declared)
+	if err != nil {
+		return sniffed
+	}
+	return mime.FormatMediaType(mediaType, params)
+}
@@ -0,0 +1,524 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"github.com/chat-backend/internal/models"
+	"github.com/chat-backend/internal/repository"
+	redisrepo "github.com/chat-backend/internal/repository/redis"
+	"github.com/chat-backend/internal/websocket"
+)
+
+// In-memory repositories for service tests. Each embeds its interface so
+// that methods a test does not need panic instead of being stubbed out.
+
+type memMessages struct {
+	repository.MessageRepository
+	mu       sync.Mutex
+	messages map[uuid.UUID]models.Message
+	receipts map[uuid.UUID]map[uuid.UUID]models.Receipt
+	hidden   map[[2]uuid.UUID]bool
+}
+
+func newMemMessages() *memMessages {
+	return &memMessages{
+		messages: make(map[uuid.UUID]models.Message),
+		receipts: make(map[uuid.UUID]map[uuid.UUID]models.Receipt),
+		hidden:   make(map[[2]uuid.UUID]bool),
+	}
+}
+
+func (r *memMessages) Create(ctx context.Context, message *models.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages[message.ID] = *message
+	return nil
+}
+
+func (r *memMessages) Update(ctx context.Context, message *models.Message) error {
+	return r.Create(ctx, message)
+}
+
+func (r *memMessages) GetByID(ctx context.Context, id uuid.UUID) (*models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	message, ok := r.messages[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	message.CountReceipts(r.receiptList(id))
+	return &message, nil
+}
+
+func (r *memMessages) GetGroupMessages(ctx context.Context, groupID uuid.UUID, page models.Page) ([]models.Message, error) {
+	return r.page(page, func(m *models.Message) bool {
+		return m.GroupID != nil && *m.GroupID == groupID
+	}), nil
+}
+
+func (r *memMessages) GetMessagesBetween(ctx context.Context, userID1, userID2 uuid.UUID, page models.Page) ([]models.Message, error) {
+	conversationID := models.DirectConversationID(userID1, userID2)
+	return r.page(page, func(m *models.Message) bool {
+		return m.GroupID == nil && m.ConversationID() == conversationID
+	}), nil
+}
+
+// page applies a history page the way the database backends do
+func (r *memMessages) page(page models.Page, match func(*models.Message) bool) []models.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var rows []models.Message
+	for _, message := range r.messages {
+		if !match(&message) || r.hidden[[2]uuid.UUID{message.ID, page.Viewer}] {
+			continue
+		}
+		if page.After != nil && !cursorBefore(*page.After, message) {
+			continue
+		}
+		if page.Before != nil && !cursorBefore(models.MessageCursor(&message), messageAt(*page.Before)) {
+			continue
+		}
+		message.CountReceipts(r.receiptList(message.ID))
+		rows = append(rows, message)
+	}
+
+	// Oldest first, then keep the rows next to the cursor
+	sort.Slice(rows, func(i, j int) bool {
+		return cursorBefore(models.MessageCursor(&rows[i]), rows[j])
+	})
+	if len(rows) > page.Limit {
+		if page.Forward() {
+			rows = rows[:page.Limit]
+		} else {
+			rows = rows[len(rows)-page.Limit:]
+		}
+	}
+	models.ReverseMessages(rows)
+	return rows
+}
+
+// cursorBefore reports whether the cursor sorts before the message
+func cursorBefore(cursor models.Cursor, message models.Message) bool {
+	if !cursor.Timestamp.Equal(message.Timestamp) {
+		return cursor.Timestamp.Before(message.Timestamp)
+	}
+	return cursor.ID < message.ID.String()
+}
+
+func messageAt(cursor models.Cursor) models.Message {
+	return models.Message{Timestamp: cursor.Timestamp, ID: uuid.MustParse(cursor.ID)}
+}
+
+func (r *memMessages) MarkAsRead(ctx context.Context, messageIDs []uuid.UUID, userID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, messageID := range messageIDs {
+		receipt := r.receipt(messageID, userID)
+		if receipt.DeliveredAt == nil {
+			receipt.DeliveredAt = &now
+		}
+		if receipt.ReadAt == nil {
+			receipt.ReadAt = &now
+		}
+		r.receipts[messageID][userID] = receipt
+	}
+	return nil
+}
+
+func (r *memMessages) MarkAsDelivered(ctx context.Context, messageID uuid.UUID, userID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	receipt := r.receipt(messageID, userID)
+	if receipt.DeliveredAt == nil {
+		now := time.Now()
+		receipt.DeliveredAt = &now
+	}
+	r.receipts[messageID][userID] = receipt
+	return nil
+}
+
+// receipt returns the user's receipt for a message. Callers must hold r.mu.
+func (r *memMessages) receipt(messageID, userID uuid.UUID) models.Receipt {
+	if r.receipts[messageID] == nil {
+		r.receipts[messageID] = make(map[uuid.UUID]models.Receipt)
+	}
+	receipt, ok := r.receipts[messageID][userID]
+	if !ok {
+		receipt = models.Receipt{MessageID: messageID, UserID: userID}
+	}
+	return receipt
+}
+
+// receiptList returns a message's receipts. Callers must hold r.mu.
+func (r *memMessages) receiptList(messageID uuid.UUID) []models.Receipt {
+	var receipts []models.Receipt
+	for _, receipt := range r.receipts[messageID] {
+		receipts = append(receipts, receipt)
+	}
+	return receipts
+}
+
+func (r *memMessages) GetReceipts(ctx context.Context, messageID uuid.UUID) ([]models.Receipt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.receiptList(messageID), nil
+}
+
+func (r *memMessages) HideMessage(ctx context.Context, messageID uuid.UUID, userID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hidden[[2]uuid.UUID{messageID, userID}] = true
+	return nil
+}
+
+func (r *memMessages) IsHidden(ctx context.Context, messageID uuid.UUID, userID uuid.UUID) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hidden[[2]uuid.UUID{messageID, userID}], nil
+}
+
+type memGroups struct {
+	repository.GroupRepository
+	mu      sync.Mutex
+	members map[uuid.UUID][]models.GroupMember
+}
+
+func newMemGroups() *memGroups {
+	return &memGroups{members: make(map[uuid.UUID][]models.GroupMember)}
+}
+
+func (r *memGroups) AddMember(ctx context.Context, groupID, userID uuid.UUID, role string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.members[groupID] = append(r.members[groupID], models.GroupMember{GroupID: groupID, UserID: userID, Role: role})
+	return nil
+}
+
+func (r *memGroups) GetMembers(ctx context.Context, groupID uuid.UUID) ([]models.GroupMember, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]models.GroupMember(nil), r.members[groupID]...), nil
+}
+
+func (r *memGroups) GetUserGroups(ctx context.Context, userID uuid.UUID) ([]models.Group, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var groups []models.Group
+	for groupID, members := range r.members {
+		for _, member := range members {
+			if member.UserID == userID {
+				groups = append(groups, models.Group{ID: groupID})
+			}
+		}
+	}
+	return groups, nil
+}
+
+type memConversations struct {
+	repository.ConversationRepository
+	mu      sync.Mutex
+	entries map[uuid.UUID]map[string]models.Conversation
+}
+
+func newMemConversations() *memConversations {
+	return &memConversations{entries: make(map[uuid.UUID]map[string]models.Conversation)}
+}
+
+func (r *memConversations) RecordMessage(ctx context.Context, entries []models.Conversation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, entry := range entries {
+		entry.UnreadCount = 0
+		if r.entries[entry.UserID] == nil {
+			r.entries[entry.UserID] = make(map[string]models.Conversation)
+		}
+		stored, ok := r.entries[entry.UserID][entry.ID]
+		if ok {
+			// Keep the latest message and the furthest read pointer
+			if stored.LastActivityAt.After(entry.LastActivityAt) {
+				entry.LastMessageID, entry.LastSenderID = stored.LastMessageID, stored.LastSenderID
+				entry.LastMessage, entry.LastContentType = stored.LastMessage, stored.LastContentType
+				entry.LastActivityAt = stored.LastActivityAt
+			}
+			if entry.LastReadAt == nil || (stored.LastReadAt != nil && stored.LastReadAt.After(*entry.LastReadAt)) {
+				entry.LastReadMessageID, entry.LastReadAt = stored.LastReadMessageID, stored.LastReadAt
+			}
+		}
+		r.entries[entry.UserID][entry.ID] = entry
+	}
+	return nil
+}
+
+func (r *memConversations) GetConversation(ctx context.Context, userID uuid.UUID, conversationID string) (*models.Conversation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.entries[userID][conversationID]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &entry, nil
+}
+
+func (r *memConversations) MarkRead(ctx context.Context, userID uuid.UUID, conversationID string, messageID uuid.UUID, timestamp time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.entries[userID][conversationID]
+	if !ok {
+		return false, nil
+	}
+	if entry.LastReadAt != nil && !cursorBefore(models.Cursor{Timestamp: *entry.LastReadAt, ID: entry.LastReadMessageID.String()},
+		models.Message{ID: messageID, Timestamp: timestamp}) {
+		return false, nil
+	}
+	entry.LastReadMessageID, entry.LastReadAt = &messageID, &timestamp
+	r.entries[userID][conversationID] = entry
+	return true, nil
+}
+
+func (r *memConversations) GetUserConversations(ctx context.Context, userID uuid.UUID, after *models.Cursor, limit int) ([]models.Conversation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var conversations []models.Conversation
+	for _, entry := range r.entries[userID] {
+		if after != nil && !conversationBefore(entry, *after) {
+			continue
+		}
+		conversations = append(conversations, entry)
+	}
+	sort.Slice(conversations, func(i, j int) bool {
+		return conversationBefore(conversations[j], models.Cursor{Timestamp: conversations[i].LastActivityAt, ID: conversations[i].ID})
+	})
+	if len(conversations) > limit {
+		conversations = conversations[:limit]
+	}
+	return conversations, nil
+}
+
+// conversationBefore reports whether the entry sorts before the cursor in
+// an inbox, which lists the most recently active conversations first
+func conversationBefore(entry models.Conversation, cursor models.Cursor) bool {
+	if !entry.LastActivityAt.Equal(cursor.Timestamp) {
+		return entry.LastActivityAt.Before(cursor.Timestamp)
+	}
+	return entry.ID < cursor.ID
+}
+
+// testRedis returns a client for a fresh in-memory Redis server
+func testRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// messageFixture wires a message service to in-memory repositories, Redis
+// backed unread counts and a websocket manager without connections
+type messageFixture struct {
+	service       *MessageService
+	messages      *memMessages
+	groups        *memGroups
+	conversations *memConversations
+	unread        repository.UnreadRepository
+}
+
+func newMessageFixture(t *testing.T) *messageFixture {
+	t.Helper()
+	client := testRedis(t)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	f := &messageFixture{
+		messages:      newMemMessages(),
+		groups:        newMemGroups(),
+		conversations: newMemConversations(),
+		unread:        redisrepo.NewUnreadRepository(client),
+	}
+	manager := websocket.NewManager(logger, client, f.groups, websocket.Config{})
+	f.service = NewMessageService(f.messages, nil, f.groups, f.conversations, f.unread,
+		nil, nil, nil, nil, nil, manager, MessageConfig{})
+	return f
+}
+
+// send stores a direct or group message as SendMessage does, at the given time
+func (f *messageFixture) send(t *testing.T, sender uuid.UUID, recipient, group *uuid.UUID, at time.Time) *models.Message {
+	t.Helper()
+	message := &models.Message{
+		ID:          uuid.New(),
+		SenderID:    sender,
+		RecipientID: recipient,
+		GroupID:     group,
+		Content:     "hello",
+		ContentType: models.ContentTypeText,
+		Timestamp:   at,
+	}
+	if err := f.messages.Create(context.Background(), message); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := f.service.recordConversation(context.Background(), message); err != nil {
+		t.Fatalf("recordConversation: %v", err)
+	}
+	return message
+}
+
+type memUploads struct {
+	repository.UploadRepository
+	mu                 sync.Mutex
+	uploads            map[uuid.UUID]models.Upload
+	chunks             map[uuid.UUID][]models.UploadChunk
+	deleteExpiredCalls int
+}
+
+func newMemUploads() *memUploads {
+	return &memUploads{
+		uploads: make(map[uuid.UUID]models.Upload),
+		chunks:  make(map[uuid.UUID][]models.UploadChunk),
+	}
+}
+
+func (r *memUploads) Create(ctx context.Context, upload *models.Upload) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.uploads[upload.ID] = *upload
+	return nil
+}
+
+func (r *memUploads) GetByID(ctx context.Context, id uuid.UUID) (*models.Upload, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	upload, ok := r.uploads[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &upload, nil
+}
+
+func (r *memUploads) AppendChunk(ctx context.Context, chunk *models.UploadChunk, expiresAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	upload, ok := r.uploads[chunk.UploadID]
+	if !ok || !upload.ExpiresAt.After(time.Now()) || chunk.Offset != upload.Offset || chunk.Offset+chunk.Size > upload.Size {
+		return false, nil
+	}
+	upload.Offset += chunk.Size
+	upload.ExpiresAt = expiresAt
+	r.uploads[upload.ID] = upload
+	r.chunks[upload.ID] = append(r.chunks[upload.ID], *chunk)
+	return true, nil
+}
+
+func (r *memUploads) GetChunks(ctx context.Context, uploadID uuid.UUID) ([]models.UploadChunk, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]models.UploadChunk(nil), r.chunks[uploadID]...), nil
+}
+
+func (r *memUploads) Delete(ctx context.Context, id uuid.UUID) ([]models.UploadChunk, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.uploads[id]; !ok {
+		return nil, repository.ErrNotFound
+	}
+	return r.delete(id), nil
+}
+
+// delete removes an upload and returns its chunks. Callers must hold r.mu.
+func (r *memUploads) delete(id uuid.UUID) []models.UploadChunk {
+	chunks := r.chunks[id]
+	delete(r.uploads, id)
+	delete(r.chunks, id)
+	return chunks
+}
+
+func (r *memUploads) DeleteExpired(ctx context.Context, before time.Time, limit int) ([]models.UploadChunk, int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deleteExpiredCalls++
+
+	var chunks []models.UploadChunk
+	deleted := 0
+	for id, upload := range r.uploads {
+		if deleted == limit {
+			break
+		}
+		if upload.ExpiresAt.Before(before) {
+			chunks = append(chunks, r.delete(id)...)
+			deleted++
+		}
+	}
+	return chunks, deleted, nil
+}
+
+func (r *memUploads) PendingSize(ctx context.Context, ownerID uuid.UUID) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var size int64
+	for _, upload := range r.uploads {
+		if upload.OwnerID == ownerID && upload.ExpiresAt.After(time.Now()) {
+			size += upload.Size
+		}
+	}
+	return size, nil
+}
+
+// memAttachments fails the next failCreates calls to Create
+type memAttachments struct {
+	repository.AttachmentRepository
+	mu          sync.Mutex
+	attachments map[uuid.UUID]models.Attachment
+	failCreates int
+}
+
+func newMemAttachments() *memAttachments {
+	return &memAttachments{attachments: make(map[uuid.UUID]models.Attachment)}
+}
+
+func (r *memAttachments) Create(ctx context.Context, attachment *models.Attachment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failCreates > 0 {
+		r.failCreates--
+		return errors.New("create failed")
+	}
+	if _, ok := r.attachments[attachment.ID]; ok {
+		return errors.New("duplicate attachment")
+	}
+	r.attachments[attachment.ID] = *attachment
+	return nil
+}
+
+func (r *memAttachments) GetByID(ctx context.Context, id uuid.UUID) (*models.Attachment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	attachment, ok := r.attachments[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &attachment, nil
+}
+
+func (r *memAttachments) TotalSize(ctx context.Context, ownerID uuid.UUID) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var size int64
+	for _, attachment := range r.attachments {
+		if attachment.OwnerID == ownerID {
+			size += attachment.Size
+		}
+	}
+	return size, nil
+}
@@ -4,39 +4,159 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"sort"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
-	"github.com/sourcegraph/conc"
+	"github.com/sirupsen/logrus"
 
 	"github.com/chat-backend/internal/models"
 	"github.com/chat-backend/internal/repository"
 	"github.com/chat-backend/internal/websocket"
 )
 
+// maxReplayMessages caps how many missed messages are streamed to a reconnecting client
+const maxReplayMessages = 500
+
+// Inbox page sizes
+const (
+	defaultConversationLimit = 20
+	maxConversationLimit     = 100
+)
+
+// maxUnreadCount caps how many messages are counted when a read pointer
+// stops short of the newest message
+const maxUnreadCount = 1000
+
+// maxReadReceipts caps how many messages get a read receipt when a read
+// pointer jumps forward
+const maxReadReceipts = 200
+
+var (
+	// ErrNotParticipant is returned when a user acts on a conversation they are not part of
+	ErrNotParticipant = errors.New("user is not a participant in this conversation")
+	// ErrMessageNotInConversation is returned when a read pointer names a message from another conversation
+	ErrMessageNotInConversation = errors.New("message does not belong to this conversation")
+	// ErrNotSender is returned when a user changes a message someone else sent
+	ErrNotSender = errors.New("only the sender can change this message")
+	// ErrEditWindowExpired is returned when a message is edited after the edit window
+	ErrEditWindowExpired = errors.New("message can no longer be edited")
+	// ErrDeleteWindowExpired is returned when a message is deleted for everyone after the delete window
+	ErrDeleteWindowExpired = errors.New("message can no longer be deleted for everyone")
+	// ErrMessageDeleted is returned when a message deleted for everyone is changed
+	ErrMessageDeleted = errors.New("message has been deleted")
+	// ErrInvalidAttachment is returned when a message names an attachment the sender cannot send
+	ErrInvalidAttachment = errors.New("attachments must be unsent uploads of the sender")
+	// ErrInvalidEmoji is returned when a reaction is not a short printable string
+	ErrInvalidEmoji = errors.New("invalid reaction emoji")
+)
+
+// Defaults for unset MessageConfig fields
+const (
+	defaultEditWindow   = 15 * time.Minute
+	defaultDeleteWindow = time.Hour
+)
+
+// Reaction event states
+const (
+	ReactionAdded   = "added"
+	ReactionRemoved = "removed"
+)
+
+// maxMessageAttachments caps how many attachments a message may carry
+const maxMessageAttachments = 10
+
+// maxEmojiLength caps the size in bytes of a reaction emoji, enough for
+// multi-codepoint sequences such as flags and families
+const maxEmojiLength = 64
+
+// Delete scopes
+const (
+	DeleteForMe       = "me"
+	DeleteForEveryone = "everyone"
+)
+
+// MessageConfig holds the message rules read from configuration
+type MessageConfig struct {
+	EditWindow   time.Duration // How long after sending a message its sender may edit it
+	DeleteWindow time.Duration // How long after sending a message its sender may delete it for everyone
+}
+
+// History page sizes
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+)
+
 type MessageService struct {
-	messageRepo repository.MessageRepository
-	userRepo    repository.UserRepository
-	groupRepo   repository.GroupRepository
-	wsManager   *websocket.Manager
+	messageRepo      repository.MessageRepository
+	userRepo         repository.UserRepository
+	groupRepo        repository.GroupRepository
+	conversationRepo repository.ConversationRepository
+	unreadRepo       repository.UnreadRepository
+	revisionRepo     repository.RevisionRepository
+	reactionRepo     repository.ReactionRepository
+	attachmentRepo   repository.AttachmentRepository
+	threadRepo       repository.ThreadRepository
+	searchIndex      repository.SearchIndex
+	wsManager        *websocket.Manager
+	config           MessageConfig
 }
 
 func NewMessageService(
 	messageRepo repository.MessageRepository,
 	userRepo repository.UserRepository,
 	groupRepo repository.GroupRepository,
+	conversationRepo repository.ConversationRepository,
+	unreadRepo repository.UnreadRepository,
+	revisionRepo repository.RevisionRepository,
+	reactionRepo repository.ReactionRepository,
+	attachmentRepo repository.AttachmentRepository,
+	threadRepo repository.ThreadRepository,
+	searchIndex repository.SearchIndex,
 	wsManager *websocket.Manager,
+	config MessageConfig,
 ) *MessageService {
+	if config.EditWindow <= 0 {
+		config.EditWindow = defaultEditWindow
+	}
+	if config.DeleteWindow <= 0 {
+		config.DeleteWindow = defaultDeleteWindow
+	}
 	return &MessageService{
-		messageRepo: messageRepo,
-		userRepo:    userRepo,
-		groupRepo:   groupRepo,
-		wsManager:   wsManager,
+		messageRepo:      messageRepo,
+		userRepo:         userRepo,
+		groupRepo:        groupRepo,
+		conversationRepo: conversationRepo,
+		unreadRepo:       unreadRepo,
+		revisionRepo:     revisionRepo,
+		reactionRepo:     reactionRepo,
+		attachmentRepo:   attachmentRepo,
+		threadRepo:       threadRepo,
+		searchIndex:      searchIndex,
+		wsManager:        wsManager,
+		config:           config,
 	}
 }
 
+// ConversationPage is one page of a user's inbox
+type ConversationPage struct {
+	Conversations []models.Conversation `json:"conversations"`
+	NextCursor    string                `json:"next_cursor,omitempty"`
+}
+
+// MessagePage is one page of message history, newest first. NextCursor
+// continues towards older messages and PrevCursor towards newer ones.
+type MessagePage struct {
+	Messages   []models.Message `json:"messages"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+	PrevCursor string           `json:"prev_cursor,omitempty"`
+}
+
 type SendMessageInput struct {
-	SenderID    string   `json:"sender_id"`
+	SenderID    string   `json:"-"` // Set from the authenticated user, never from the request body
 	RecipientID *string  `json:"recipient_id,omitempty"`
 	GroupID     *string  `json:"group_id,omitempty"`
 	Content     string   `json:"content"`
@@ -87,6 +207,29 @@ func (s *MessageService) SendMessage(ctx context.Context, input SendMessageInput
 		replyToUUID = &parsed
 	}
 
+	if input.Content == "" && len(input.Attachments) == 0 {
+		return nil, errors.New("message content cannot be empty")
+	}
+	attachmentIDs, err := parseAttachmentIDs(input.Attachments)
+	if err != nil {
+		return nil, err
+	}
+	if input.ContentType == "" {
+		input.ContentType = models.ContentTypeText
+	}
+
+	// Make sure the sender is allowed to write to the conversation
+	if recipientUUID != nil {
+		if _, err := s.userRepo.GetByID(ctx, *recipientUUID); err != nil {
+			return nil, errors.New("recipient not found")
+		}
+	}
+	if groupUUID != nil {
+		if err := s.checkGroupMember(ctx, *groupUUID, senderUUID); err != nil {
+			return nil, err
+		}
+	}
+
 	// Create message
 	message := &models.Message{
 		ID:          uuid.New(),
@@ -96,154 +239,1344 @@ func (s *MessageService) SendMessage(ctx context.Context, input SendMessageInput
 		Content:     input.Content,
 		ContentType: input.ContentType,
 		Timestamp:   time.Now(),
-		ReadBy:      []string{input.SenderID},
-		DeliveredTo: []string{input.SenderID},
 		ReplyToID:   replyToUUID,
-		Attachments: input.Attachments,
+	}
+	for _, id := range attachmentIDs {
+		message.Attachments = append(message.Attachments, id.String())
 	}
 
-	// Use conc.WaitGroup for concurrent operations
-	var wg conc.WaitGroup
-	var saveErr error
-	var deliveryErr error
+	var parent *models.Message
+	if message.ReplyToID != nil {
+		if parent, err = s.threadParent(ctx, message); err != nil {
+			return nil, err
+		}
+	}
 
-	// Save message
-	wg.Go(func() {
-		if err := s.messageRepo.Create(ctx, message); err != nil {
-			saveErr = err
+	// Claim the attachments first so two messages cannot both carry one
+	if len(attachmentIDs) > 0 {
+		claimed, err := s.attachmentRepo.Claim(ctx, attachmentIDs, senderUUID, message.ID)
+		if err != nil {
+			return nil, err
 		}
-	})
+		if !claimed {
+			return nil, ErrInvalidAttachment
+		}
+	}
 
-	// Handle delivery
-	wg.Go(func() {
-		if input.RecipientID != nil {
-			// Direct message
-			if err := s.deliverDirectMessage(ctx, message); err != nil {
-				deliveryErr = err
-			}
-		} else {
-			// Group message
-			if err := s.deliverGroupMessage(ctx, message); err != nil {
-				deliveryErr = err
+	// Save message before delivering it so recipients never see a message
+	// that is missing from history
+	if err := s.messageRepo.Create(ctx, message); err != nil {
+		if len(attachmentIDs) > 0 {
+			if err := s.attachmentRepo.Release(ctx, message.ID); err != nil {
+				logrus.WithError(err).WithField("message_id", message.ID).Error("Failed to release attachments")
 			}
 		}
-	})
-
-	wg.Wait()
+		return nil, err
+	}
 
-	if saveErr != nil {
-		return nil, saveErr
+	if err := s.recordConversation(ctx, message); err != nil {
+		logrus.WithError(err).WithField("message_id", message.ID).Error("Failed to update conversations")
 	}
-	if deliveryErr != nil {
-		return nil, deliveryErr
+	if err := s.searchIndex.Index(ctx, message); err != nil {
+		logrus.WithError(err).WithField("message_id", message.ID).Error("Failed to index message")
+	}
+	if parent != nil {
+		if err := s.recordReply(ctx, message, parent); err != nil {
+			logrus.WithError(err).WithField("message_id", message.ID).Error("Failed to update thread")
+		}
+	}
+
+	if input.RecipientID != nil {
+		// Direct message
+		if err := s.deliverDirectMessage(ctx, message); err != nil {
+			logrus.WithError(err).WithField("message_id", message.ID).Error("Failed to deliver direct message")
+		}
+	} else {
+		// Group message
+		if err := s.deliverGroupMessage(ctx, message); err != nil {
+			logrus.WithError(err).WithField("message_id", message.ID).Error("Failed to deliver group message")
+		}
 	}
 
 	return message, nil
 }
 
-func (s *MessageService) deliverDirectMessage(ctx context.Context, message *models.Message) error {
-	messageJSON, err := json.Marshal(message)
+// parseAttachmentIDs parses the attachment IDs of a new message, dropping
+// repeats
+func parseAttachmentIDs(values []string) ([]uuid.UUID, error) {
+	if len(values) > maxMessageAttachments {
+		return nil, errors.New("too many attachments")
+	}
+	ids := make([]uuid.UUID, 0, len(values))
+	seen := make(map[uuid.UUID]bool, len(values))
+	for _, value := range values {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return nil, ErrInvalidAttachment
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// threadParent checks that a reply's parent is in the reply's conversation
+// and returns the message whose thread the reply joins. A reply to a reply
+// is moved to the thread of the message that one replied to.
+func (s *MessageService) threadParent(ctx context.Context, reply *models.Message) (*models.Message, error) {
+	parent, err := s.messageRepo.GetByID(ctx, *reply.ReplyToID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, errors.New("reply-to message not found")
+	}
 	if err != nil {
+		return nil, err
+	}
+	if parent.ConversationID() != reply.ConversationID() {
+		return nil, ErrMessageNotInConversation
+	}
+
+	if parent.ReplyToID != nil {
+		if parent, err = s.messageRepo.GetByID(ctx, *parent.ReplyToID); err != nil {
+			return nil, err
+		}
+		reply.ReplyToID = &parent.ID
+	}
+	return parent, nil
+}
+
+// recordReply counts a reply in its thread and bumps the unread count of
+// the thread's followers. The parent's sender follows the thread unless they
+// unfollowed it; the replier follows it again even if they had.
+func (s *MessageService) recordReply(ctx context.Context, reply *models.Message, parent *models.Message) error {
+	if err := s.threadRepo.RecordReply(ctx, reply); err != nil {
+		return err
+	}
+	if err := s.threadRepo.FollowByDefault(ctx, parent.ID, []uuid.UUID{parent.SenderID}); err != nil {
+		return err
+	}
+	if err := s.threadRepo.Follow(ctx, parent.ID, reply.SenderID, true); err != nil {
 		return err
 	}
 
-	// Send to recipient via WebSocket if online
-	if err := s.wsManager.SendToUser(message.RecipientID.String(), messageJSON); err != nil {
-		// TODO: Queue for push notification if delivery fails
+	followers, err := s.threadRepo.GetFollowers(ctx, parent.ID)
+	if err != nil {
+		return err
+	}
+	var unread []uuid.UUID
+	for _, userID := range followers {
+		if userID != reply.SenderID {
+			unread = append(unread, userID)
+		}
+	}
+
+	key := models.ThreadUnreadKey(parent.ID)
+	if err := s.unreadRepo.Set(ctx, reply.SenderID, key, 0); err != nil {
 		return err
 	}
+	return s.unreadRepo.Increment(ctx, key, unread)
+}
 
-	return nil
+// HandleChatMessage stores a chat frame received over a WebSocket connection
+func (s *MessageService) HandleChatMessage(ctx context.Context, msg websocket.WebSocketMessage) (string, time.Time, error) {
+	message, err := s.SendMessage(ctx, SendMessageInput{
+		SenderID:    msg.SenderID,
+		RecipientID: msg.RecipientID,
+		GroupID:     msg.GroupID,
+		Content:     msg.Content,
+		ContentType: msg.ContentType,
+		ReplyToID:   msg.ReplyToID,
+		Attachments: msg.Attachments,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return message.ID.String(), message.Timestamp, nil
 }
 
-func (s *MessageService) deliverGroupMessage(ctx context.Context, message *models.Message) error {
-	messageJSON, err := json.Marshal(message)
+// ReplayMessages returns the messages addressed to a user after the given
+// message, oldest first, as delivery frames for a reconnecting client
+func (s *MessageService) ReplayMessages(ctx context.Context, userID string, afterMessageID string) ([]websocket.WebSocketMessage, error) {
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+	afterUUID, err := uuid.Parse(afterMessageID)
+	if err != nil {
+		return nil, errors.New("invalid since cursor")
+	}
+
+	after, err := s.messageRepo.GetByID(ctx, afterUUID)
+	if err != nil {
+		return nil, errors.New("since message not found")
+	}
+
+	groups, err := s.groupRepo.GetUserGroups(ctx, userUUID)
+	if err != nil {
+		return nil, err
+	}
+	groupIDs := make([]uuid.UUID, len(groups))
+	for i, group := range groups {
+		groupIDs[i] = group.ID
+	}
+
+	// The ID breaks ties, so messages sharing the last seen one's timestamp
+	// are not lost
+	since := models.Cursor{Timestamp: after.Timestamp, ID: after.ID.String()}
+	messages, err := s.messageRepo.GetUserMessagesSince(ctx, userUUID, groupIDs, since, maxReplayMessages)
+	if err != nil {
+		return nil, err
+	}
+
+	frames := make([]websocket.WebSocketMessage, 0, len(messages))
+	for _, message := range messages {
+		payload, err := json.Marshal(message)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, websocket.WebSocketMessage{
+			Type:      websocket.MessageTypeMessage,
+			MessageID: message.ID.String(),
+			Payload:   payload,
+			Timestamp: message.Timestamp,
+		})
+	}
+	return frames, nil
+}
+
+func (s *MessageService) checkGroupMember(ctx context.Context, groupID, userID uuid.UUID) error {
+	members, err := s.groupRepo.GetMembers(ctx, groupID)
 	if err != nil {
 		return err
 	}
+	for _, member := range members {
+		if member.UserID == userID {
+			return nil
+		}
+	}
+	return errors.New("sender is not a member of this group")
+}
 
-	// Send to all group members except sender
-	s.wsManager.SendToGroup(message.GroupID.String(), messageJSON, message.SenderID.String())
-	return nil
+// participants returns everyone in the message's conversation
+func (s *MessageService) participants(ctx context.Context, message *models.Message) ([]uuid.UUID, error) {
+	return conversationParticipants(ctx, s.groupRepo, message)
 }
 
-func (s *MessageService) GetMessage(ctx context.Context, id string) (*models.Message, error) {
-	messageID, err := uuid.Parse(id)
+func conversationParticipants(ctx context.Context, groupRepo repository.GroupRepository, message *models.Message) ([]uuid.UUID, error) {
+	if message.GroupID != nil {
+		members, err := groupRepo.GetMembers(ctx, *message.GroupID)
+		if err != nil {
+			return nil, err
+		}
+		userIDs := make([]uuid.UUID, len(members))
+		for i, member := range members {
+			userIDs[i] = member.UserID
+		}
+		return userIDs, nil
+	}
+
+	userIDs := []uuid.UUID{message.SenderID}
+	if *message.RecipientID != message.SenderID {
+		userIDs = append(userIDs, *message.RecipientID)
+	}
+	return userIDs, nil
+}
+
+// recordConversation updates the inbox and unread counts of everyone in the
+// message's conversation
+func (s *MessageService) recordConversation(ctx context.Context, message *models.Message) error {
+	userIDs, err := s.participants(ctx, message)
 	if err != nil {
-		return nil, errors.New("invalid message ID")
+		return err
+	}
+
+	entries := make([]models.Conversation, len(userIDs))
+	var unread []uuid.UUID
+	for i, userID := range userIDs {
+		entries[i] = models.NewConversationEntry(userID, message)
+		if entries[i].UnreadCount > 0 {
+			unread = append(unread, userID)
+		}
+	}
+	if err := s.conversationRepo.RecordMessage(ctx, entries); err != nil {
+		return err
 	}
-	return s.messageRepo.GetByID(ctx, messageID)
+
+	// Replying means the sender has caught up with the conversation
+	conversationID := message.ConversationID()
+	if err := s.unreadRepo.Set(ctx, message.SenderID, conversationID, 0); err != nil {
+		return err
+	}
+	return s.unreadRepo.Increment(ctx, conversationID, unread)
 }
 
-func (s *MessageService) GetUserMessages(ctx context.Context, userID string, limit, offset int) ([]models.Message, error) {
-	userUUID, err := uuid.Parse(userID)
+// GetConversations returns a page of the user's inbox, most recently active
+// first. cursor is the next_cursor of the previous page, if any.
+func (s *MessageService) GetConversations(ctx context.Context, userID uuid.UUID, cursor string, limit int) (*ConversationPage, error) {
+	after, err := models.DecodeCursor(cursor)
 	if err != nil {
-		return nil, errors.New("invalid user ID")
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultConversationLimit
+	}
+	if limit > maxConversationLimit {
+		limit = maxConversationLimit
 	}
-	return s.messageRepo.GetUserMessages(ctx, userUUID, limit, offset)
+
+	// Fetch one extra row to know whether there is another page
+	conversations, err := s.conversationRepo.GetUserConversations(ctx, userID, after, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ConversationPage{Conversations: conversations}
+	if len(conversations) > limit {
+		page.Conversations = conversations[:limit]
+		last := page.Conversations[limit-1]
+		page.NextCursor = models.Cursor{Timestamp: last.LastActivityAt, ID: last.ID}.Encode()
+	}
+	if page.Conversations == nil {
+		page.Conversations = []models.Conversation{}
+	}
+
+	conversationIDs := make([]string, len(page.Conversations))
+	for i, conversation := range page.Conversations {
+		conversationIDs[i] = conversation.ID
+	}
+	counts, err := s.unreadRepo.Get(ctx, userID, conversationIDs)
+	if err != nil {
+		// The inbox is still useful without badges
+		logrus.WithError(err).WithField("user_id", userID).Warn("Failed to load unread counts")
+	}
+	for i := range page.Conversations {
+		page.Conversations[i].UnreadCount = counts[page.Conversations[i].ID]
+	}
+	return page, nil
 }
 
-func (s *MessageService) GetGroupMessages(ctx context.Context, groupID string, limit, offset int) ([]models.Message, error) {
-	groupUUID, err := uuid.Parse(groupID)
+// ReadState is a user's position in a conversation after marking it read
+type ReadState struct {
+	ConversationID    string    `json:"conversation_id"`
+	LastReadMessageID uuid.UUID `json:"last_read_message_id"`
+	UnreadCount       int       `json:"unread_count"`
+}
+
+// MarkConversationRead moves the user's read pointer in a conversation up to
+// messageID, recounts their unread messages and tells the other participants
+// with a single read event. Marking an older message than the current
+// pointer leaves it in place.
+func (s *MessageService) MarkConversationRead(ctx context.Context, userID uuid.UUID, conversationID string, messageID uuid.UUID) (*ReadState, error) {
+	message, err := s.messageRepo.GetByID(ctx, messageID)
 	if err != nil {
-		return nil, errors.New("invalid group ID")
+		return nil, err
+	}
+	if message.ConversationID() != conversationID {
+		return nil, ErrMessageNotInConversation
 	}
-	return s.messageRepo.GetGroupMessages(ctx, groupUUID, limit, offset)
+	return s.markRead(ctx, userID, message)
 }
 
-func (s *MessageService) GetConversation(ctx context.Context, user1ID, user2ID string, limit, offset int) ([]models.Message, error) {
-	user1UUID, err := uuid.Parse(user1ID)
+func (s *MessageService) markRead(ctx context.Context, userID uuid.UUID, message *models.Message) (*ReadState, error) {
+	userIDs, err := s.participants(ctx, message)
 	if err != nil {
-		return nil, errors.New("invalid user1 ID")
+		return nil, err
 	}
-	user2UUID, err := uuid.Parse(user2ID)
+	if !containsUser(userIDs, userID) {
+		return nil, ErrNotParticipant
+	}
+
+	conversationID := message.ConversationID()
+	state := &ReadState{ConversationID: conversationID, LastReadMessageID: message.ID}
+
+	entry, err := s.conversationRepo.GetConversation(ctx, userID, conversationID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+
+	moved, err := s.conversationRepo.MarkRead(ctx, userID, conversationID, message.ID, message.Timestamp)
 	if err != nil {
-		return nil, errors.New("invalid user2 ID")
+		return nil, err
+	}
+	if !moved {
+		// Already read this far; report the current count unchanged
+		counts, err := s.unreadRepo.Get(ctx, userID, []string{conversationID})
+		if err != nil {
+			return nil, err
+		}
+		state.UnreadCount = counts[conversationID]
+		return state, nil
 	}
 
-	messages, err := s.messageRepo.GetMessagesBetween(ctx, user1UUID, user2UUID, int64(limit), time.Now())
+	state.UnreadCount, err = s.countUnread(ctx, userID, message)
 	if err != nil {
 		return nil, err
 	}
-	result := make([]models.Message, len(messages))
-	for i, msg := range messages {
-		result[i] = *msg
+	if err := s.unreadRepo.Set(ctx, userID, conversationID, state.UnreadCount); err != nil {
+		return nil, err
 	}
-	return result, nil
+
+	if err := s.recordReadReceipts(ctx, userID, message, entry); err != nil {
+		logrus.WithError(err).WithField("conversation_id", conversationID).Warn("Failed to record read receipts")
+	}
+
+	s.announceRead(conversationID, userID, message)
+	return state, nil
 }
 
-func (s *MessageService) MarkAsRead(ctx context.Context, messageID string, userID string) error {
-	msgUUID, err := uuid.Parse(messageID)
+// conversationPage reads a page of the conversation message belongs to
+func (s *MessageService) conversationPage(ctx context.Context, message *models.Message, page models.Page) ([]models.Message, error) {
+	if message.GroupID != nil {
+		return s.messageRepo.GetGroupMessages(ctx, *message.GroupID, page)
+	}
+	return s.messageRepo.GetMessagesBetween(ctx, message.SenderID, *message.RecipientID, page)
+}
+
+// countUnread counts the messages from others after message, up to maxUnreadCount
+func (s *MessageService) countUnread(ctx context.Context, userID uuid.UUID, message *models.Message) (int, error) {
+	cursor := models.MessageCursor(message)
+	newer, err := s.conversationPage(ctx, message, models.Page{After: &cursor, Limit: maxUnreadCount, Viewer: userID})
 	if err != nil {
-		return errors.New("invalid message ID")
+		return 0, err
 	}
-	userUUID, err := uuid.Parse(userID)
+
+	count := 0
+	for _, m := range newer {
+		if m.SenderID != userID && !m.IsDeleted() {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// recordReadReceipts stores read receipts for the messages from others that
+// a read pointer moved past: those after the entry's previous pointer, up to
+// and including message
+func (s *MessageService) recordReadReceipts(ctx context.Context, userID uuid.UUID, message *models.Message, entry *models.Conversation) error {
+	cursor := models.MessageCursor(message)
+	earlier, err := s.conversationPage(ctx, message, models.Page{Before: &cursor, Limit: maxReadReceipts, Viewer: userID})
 	if err != nil {
-		return errors.New("invalid user ID")
+		return err
+	}
+
+	var ids []uuid.UUID
+	if message.SenderID != userID {
+		ids = append(ids, message.ID)
+	}
+	for i := range earlier {
+		if entry != nil && !readPast(entry, &earlier[i]) {
+			break
+		}
+		if earlier[i].SenderID != userID {
+			ids = append(ids, earlier[i].ID)
+		}
 	}
-	return s.messageRepo.MarkAsRead(ctx, msgUUID, userUUID)
+	return s.messageRepo.MarkAsRead(ctx, ids, userID)
 }
 
-func (s *MessageService) MarkAsDelivered(ctx context.Context, messageID string, userID string) error {
-	msgUUID, err := uuid.Parse(messageID)
+// readPast reports whether message lies beyond the entry's read pointer
+func readPast(entry *models.Conversation, message *models.Message) bool {
+	if entry.LastReadAt == nil || entry.LastReadMessageID == nil {
+		return true
+	}
+	if !message.Timestamp.Equal(*entry.LastReadAt) {
+		return message.Timestamp.After(*entry.LastReadAt)
+	}
+	return message.ID.String() > entry.LastReadMessageID.String()
+}
+
+// announceRead sends one read event to every participant, including the
+// reader's other devices
+func (s *MessageService) announceRead(conversationID string, userID uuid.UUID, message *models.Message) {
+	s.notifyConversation(message, websocket.WebSocketMessage{
+		Type:           websocket.MessageTypeRead,
+		SenderID:       userID.String(),
+		UserID:         userID.String(),
+		ConversationID: conversationID,
+		MessageID:      message.ID.String(),
+		Timestamp:      time.Now(),
+	})
+}
+
+// notifyConversation sends an event frame to every connection of every
+// participant in the message's conversation. Events are not queued for
+// offline users; clients catch up from history.
+func (s *MessageService) notifyConversation(message *models.Message, frame websocket.WebSocketMessage) {
+	event, err := json.Marshal(frame)
 	if err != nil {
-		return errors.New("invalid message ID")
+		logrus.WithError(err).WithField("type", frame.Type).Error("Failed to marshal event")
+		return
 	}
-	userUUID, err := uuid.Parse(userID)
+
+	if message.GroupID != nil {
+		if err := s.wsManager.SendToGroup(message.GroupID.String(), event, ""); err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"type":     frame.Type,
+				"group_id": message.GroupID,
+			}).Error("Failed to send event")
+		}
+		return
+	}
+
+	userIDs := []uuid.UUID{message.SenderID}
+	if *message.RecipientID != message.SenderID {
+		userIDs = append(userIDs, *message.RecipientID)
+	}
+	for _, userID := range userIDs {
+		if err := s.wsManager.SendToUser(userID.String(), event); err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"type":    frame.Type,
+				"user_id": userID,
+			}).Debug("Failed to send event")
+		}
+	}
+}
+
+func containsUser(userIDs []uuid.UUID, userID uuid.UUID) bool {
+	for _, id := range userIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MessageService) deliverDirectMessage(ctx context.Context, message *models.Message) error {
+	messageJSON, err := json.Marshal(message)
 	if err != nil {
-		return errors.New("invalid user ID")
+		return err
 	}
-	return s.messageRepo.MarkAsDelivered(ctx, msgUUID, userUUID)
+
+	// Send to recipient via WebSocket, kept pending until the client acks it
+	if err := s.wsManager.DeliverMessage(message.RecipientID.String(), message.ID.String(), messageJSON); err != nil {
+		// TODO: Queue for push notification if delivery fails
+		return err
+	}
+
+	return nil
 }
 
-func (s *MessageService) UpdateMessage(ctx context.Context, message *models.Message) error {
-	return s.messageRepo.Update(ctx, message)
+func (s *MessageService) deliverGroupMessage(ctx context.Context, message *models.Message) error {
+	messageJSON, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	// Send to all group members except sender
+	return s.wsManager.DeliverToGroup(message.GroupID.String(), message.ID.String(), messageJSON, message.SenderID.String())
 }
 
-func (s *MessageService) DeleteMessage(ctx context.Context, id string) error {
+// GetMessage returns a message unless viewer deleted it for themselves
+func (s *MessageService) GetMessage(ctx context.Context, viewer uuid.UUID, id string) (*models.Message, error) {
 	messageID, err := uuid.Parse(id)
 	if err != nil {
-		return errors.New("invalid message ID")
+		return nil, errors.New("invalid message ID")
+	}
+	return s.visibleMessage(ctx, viewer, messageID)
+}
+
+// visibleMessage loads a message, reporting one the viewer deleted for
+// themselves as not found, as history pages leave it out
+func (s *MessageService) visibleMessage(ctx context.Context, viewer, messageID uuid.UUID) (*models.Message, error) {
+	message, err := s.messageRepo.GetByID(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	hidden, err := s.messageRepo.IsHidden(ctx, messageID, viewer)
+	if err != nil {
+		return nil, err
 	}
-	return s.messageRepo.Delete(ctx, messageID)
+	if hidden {
+		return nil, repository.ErrNotFound
+	}
+	return message, nil
+}
+
+// GetUserMessages returns a page of the messages a user sent or received.
+// before and after are cursors from a previous page; at most one may be set.
+// Messages viewer deleted for themselves are left out of every history page.
+func (s *MessageService) GetUserMessages(ctx context.Context, viewer uuid.UUID, userID string, before, after string, limit int) (*MessagePage, error) {
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+	return s.historyPage(ctx, viewer, before, after, limit, func(page models.Page) ([]models.Message, error) {
+		return s.messageRepo.GetUserMessages(ctx, userUUID, page)
+	})
+}
+
+// GetGroupMessages returns a page of a group's messages
+func (s *MessageService) GetGroupMessages(ctx context.Context, viewer uuid.UUID, groupID string, before, after string, limit int) (*MessagePage, error) {
+	groupUUID, err := uuid.Parse(groupID)
+	if err != nil {
+		return nil, errors.New("invalid group ID")
+	}
+	return s.historyPage(ctx, viewer, before, after, limit, func(page models.Page) ([]models.Message, error) {
+		return s.messageRepo.GetGroupMessages(ctx, groupUUID, page)
+	})
+}
+
+// GetConversation returns a page of the direct messages between two users
+func (s *MessageService) GetConversation(ctx context.Context, viewer uuid.UUID, user1ID, user2ID string, before, after string, limit int) (*MessagePage, error) {
+	user1UUID, err := uuid.Parse(user1ID)
+	if err != nil {
+		return nil, errors.New("invalid user1 ID")
+	}
+	user2UUID, err := uuid.Parse(user2ID)
+	if err != nil {
+		return nil, errors.New("invalid user2 ID")
+	}
+	return s.historyPage(ctx, viewer, before, after, limit, func(page models.Page) ([]models.Message, error) {
+		return s.messageRepo.GetMessagesBetween(ctx, user1UUID, user2UUID, page)
+	})
+}
+
+// historyPage decodes the page cursors, runs fetch with one extra row to
+// detect further pages, builds the cursors for the neighbouring pages and
+// attaches the reactions as viewer sees them
+func (s *MessageService) historyPage(ctx context.Context, viewer uuid.UUID, before, after string, limit int, fetch func(models.Page) ([]models.Message, error)) (*MessagePage, error) {
+	if before != "" && after != "" {
+		return nil, models.ErrInvalidCursor
+	}
+	beforeCursor, err := decodeMessageCursor(before)
+	if err != nil {
+		return nil, err
+	}
+	afterCursor, err := decodeMessageCursor(after)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	page := models.Page{Before: beforeCursor, After: afterCursor, Limit: limit + 1, Viewer: viewer}
+	messages, err := fetch(page)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MessagePage{Messages: messages}
+	more := len(messages) > limit
+	if page.Forward() {
+		// The extra row is the newest one; older pages always exist behind
+		// the cursor
+		if more {
+			result.Messages = messages[1:]
+		}
+		if len(result.Messages) > 0 {
+			result.NextCursor = models.MessageCursor(&result.Messages[len(result.Messages)-1]).Encode()
+		}
+	} else if more {
+		result.Messages = messages[:limit]
+		result.NextCursor = models.MessageCursor(&result.Messages[limit-1]).Encode()
+	}
+
+	if len(result.Messages) > 0 {
+		result.PrevCursor = models.MessageCursor(&result.Messages[0]).Encode()
+	} else if page.Forward() {
+		// Nothing newer yet; poll again from the same position
+		result.PrevCursor = after
+	}
+	if result.Messages == nil {
+		result.Messages = []models.Message{}
+	}
+	s.decorate(ctx, viewer, result.Messages)
+	return result, nil
+}
+
+// decorate attaches the reactions and threads of messages as viewer sees
+// them. History is still useful without them, so failures are only logged.
+func (s *MessageService) decorate(ctx context.Context, viewer uuid.UUID, messages []models.Message) {
+	if err := s.attachReactions(ctx, viewer, messages); err != nil {
+		logrus.WithError(err).Warn("Failed to load reactions")
+	}
+	if err := s.attachThreads(ctx, viewer, messages); err != nil {
+		logrus.WithError(err).Warn("Failed to load threads")
+	}
+}
+
+// attachThreads fills in the reply counts of messages, and viewer's state
+// for the threads they follow
+func (s *MessageService) attachThreads(ctx context.Context, viewer uuid.UUID, messages []models.Message) error {
+	ids := make([]uuid.UUID, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].ID
+	}
+	threads, err := s.threadRepo.GetThreads(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		if thread, ok := threads[messages[i].ID]; ok {
+			lastReplyAt := thread.LastReplyAt
+			messages[i].ReplyCount = thread.ReplyCount
+			messages[i].LastReplyAt = &lastReplyAt
+		}
+	}
+
+	if viewer == uuid.Nil {
+		return nil
+	}
+	followed, err := s.threadRepo.GetFollowed(ctx, viewer, ids)
+	if err != nil || len(followed) == 0 {
+		return err
+	}
+	keys := make([]string, 0, len(followed))
+	for id := range followed {
+		keys = append(keys, models.ThreadUnreadKey(id))
+	}
+	counts, err := s.unreadRepo.Get(ctx, viewer, keys)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		if followed[messages[i].ID] {
+			messages[i].ThreadState = &models.ThreadState{
+				Following:   true,
+				UnreadCount: counts[models.ThreadUnreadKey(messages[i].ID)],
+			}
+		}
+	}
+	return nil
+}
+
+// attachReactions fills in the reaction summaries of messages as viewer
+// sees them
+func (s *MessageService) attachReactions(ctx context.Context, viewer uuid.UUID, messages []models.Message) error {
+	ids := make([]uuid.UUID, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].ID
+	}
+	summaries, err := s.reactionRepo.Summarize(ctx, ids, viewer)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Reactions = summaries[messages[i].ID]
+	}
+	return nil
+}
+
+// decodeMessageCursor decodes a history cursor, whose ID must be a message ID
+func decodeMessageCursor(value string) (*models.Cursor, error) {
+	cursor, err := models.DecodeCursor(value)
+	if err != nil || cursor == nil {
+		return cursor, err
+	}
+	if _, err := uuid.Parse(cursor.ID); err != nil {
+		return nil, models.ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// ThreadPage is a thread's parent message with one page of its replies,
+// newest first
+type ThreadPage struct {
+	Parent *models.Message `json:"parent"`
+	MessagePage
+}
+
+// GetThread returns a page of the replies to a message, to a participant of
+// its conversation. Asking for the thread of a reply returns the thread it
+// belongs to.
+func (s *MessageService) GetThread(ctx context.Context, viewer uuid.UUID, messageID string, before, after string, limit int) (*ThreadPage, error) {
+	parent, err := s.threadRoot(ctx, viewer, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := s.historyPage(ctx, viewer, before, after, limit, func(page models.Page) ([]models.Message, error) {
+		return s.messageRepo.GetReplies(ctx, parent.ID, page)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	parents := []models.Message{*parent}
+	s.decorate(ctx, viewer, parents)
+	return &ThreadPage{Parent: &parents[0], MessagePage: *page}, nil
+}
+
+// MarkThreadRead clears the user's unread count for a thread
+func (s *MessageService) MarkThreadRead(ctx context.Context, userID uuid.UUID, messageID string) (*models.ThreadState, error) {
+	parent, err := s.threadRoot(ctx, userID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.unreadRepo.Set(ctx, userID, models.ThreadUnreadKey(parent.ID), 0); err != nil {
+		return nil, err
+	}
+	followed, err := s.threadRepo.GetFollowed(ctx, userID, []uuid.UUID{parent.ID})
+	if err != nil {
+		return nil, err
+	}
+	return &models.ThreadState{Following: followed[parent.ID]}, nil
+}
+
+// FollowThread sets whether the user follows a thread. Followers have
+// unread counts kept for the thread; unfollowing clears it.
+func (s *MessageService) FollowThread(ctx context.Context, userID uuid.UUID, messageID string, following bool) (*models.ThreadState, error) {
+	parent, err := s.threadRoot(ctx, userID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.threadRepo.Follow(ctx, parent.ID, userID, following); err != nil {
+		return nil, err
+	}
+	state := &models.ThreadState{Following: following}
+	key := models.ThreadUnreadKey(parent.ID)
+	if !following {
+		if err := s.unreadRepo.Set(ctx, userID, key, 0); err != nil {
+			return nil, err
+		}
+		return state, nil
+	}
+	counts, err := s.unreadRepo.Get(ctx, userID, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	state.UnreadCount = counts[key]
+	return state, nil
+}
+
+// threadRoot loads the message whose thread messageID belongs to and checks
+// that userID takes part in its conversation
+func (s *MessageService) threadRoot(ctx context.Context, userID uuid.UUID, messageID string) (*models.Message, error) {
+	msgUUID, err := uuid.Parse(messageID)
+	if err != nil {
+		return nil, errors.New("invalid message ID")
+	}
+
+	message, err := s.messageRepo.GetByID(ctx, msgUUID)
+	if err != nil {
+		return nil, err
+	}
+	if message.ReplyToID != nil {
+		if message, err = s.messageRepo.GetByID(ctx, *message.ReplyToID); err != nil {
+			return nil, err
+		}
+	}
+
+	userIDs, err := s.participants(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+	if !containsUser(userIDs, userID) {
+		return nil, ErrNotParticipant
+	}
+	return message, nil
+}
+
+type EditMessageInput struct {
+	Content string `json:"content" binding:"required"`
+}
+
+// EditMessage replaces the content of a message. Only the sender may edit,
+// and only within the edit window. The previous content is kept as a
+// revision and the conversation's participants receive an edit event.
+func (s *MessageService) EditMessage(ctx context.Context, messageID string, userID uuid.UUID, input EditMessageInput) (*models.Message, error) {
+	msgUUID, err := uuid.Parse(messageID)
+	if err != nil {
+		return nil, errors.New("invalid message ID")
+	}
+	if input.Content == "" {
+		return nil, errors.New("message content cannot be empty")
+	}
+
+	message, err := s.messageRepo.GetByID(ctx, msgUUID)
+	if err != nil {
+		return nil, err
+	}
+	if message.SenderID != userID {
+		return nil, ErrNotSender
+	}
+	if message.IsDeleted() {
+		return nil, ErrMessageDeleted
+	}
+	if time.Since(message.Timestamp) > s.config.EditWindow {
+		return nil, ErrEditWindowExpired
+	}
+	if input.Content == message.Content {
+		return message, nil
+	}
+
+	now := time.Now()
+	if err := s.revisionRepo.Create(ctx, models.NewMessageRevision(message, now)); err != nil {
+		return nil, err
+	}
+
+	message.Content = input.Content
+	message.IsEdited = true
+	message.EditTimestamp = &now
+	if err := s.messageRepo.Update(ctx, message); err != nil {
+		return nil, err
+	}
+	if err := s.conversationRepo.UpdatePreview(ctx, message); err != nil {
+		logrus.WithError(err).WithField("message_id", message.ID).Error("Failed to update conversation preview")
+	}
+	if err := s.searchIndex.Index(ctx, message); err != nil {
+		logrus.WithError(err).WithField("message_id", message.ID).Error("Failed to index message")
+	}
+
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	s.notifyConversation(message, websocket.WebSocketMessage{
+		Type:           websocket.MessageTypeEdit,
+		SenderID:       userID.String(),
+		ConversationID: message.ConversationID(),
+		MessageID:      message.ID.String(),
+		Payload:        payload,
+		Timestamp:      now,
+	})
+	return message, nil
+}
+
+// MessageHistory is a message with its earlier versions, oldest first
+type MessageHistory struct {
+	Message   *models.Message          `json:"message"`
+	Revisions []models.MessageRevision `json:"revisions"`
+}
+
+// GetMessageHistory returns the revisions of a message to a participant of
+// its conversation. Messages deleted for everyone have no history left.
+func (s *MessageService) GetMessageHistory(ctx context.Context, messageID string, userID uuid.UUID) (*MessageHistory, error) {
+	msgUUID, err := uuid.Parse(messageID)
+	if err != nil {
+		return nil, errors.New("invalid message ID")
+	}
+
+	message, err := s.visibleMessage(ctx, userID, msgUUID)
+	if err != nil {
+		return nil, err
+	}
+	userIDs, err := s.participants(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+	if !containsUser(userIDs, userID) {
+		return nil, ErrNotParticipant
+	}
+	if message.IsDeleted() {
+		return &MessageHistory{Message: message, Revisions: []models.MessageRevision{}}, nil
+	}
+
+	revisions, err := s.revisionRepo.GetByMessageID(ctx, msgUUID)
+	if err != nil {
+		return nil, err
+	}
+	if revisions == nil {
+		revisions = []models.MessageRevision{}
+	}
+	return &MessageHistory{Message: message, Revisions: revisions}, nil
+}
+
+// ReactionInput names the emoji to react with
+type ReactionInput struct {
+	Emoji string `json:"emoji" binding:"required"`
+}
+
+// MessageReactions is a message's reactions as one user sees them
+type MessageReactions struct {
+	MessageID uuid.UUID                `json:"message_id"`
+	Reactions []models.ReactionSummary `json:"reactions"`
+}
+
+// AddReaction reacts to a message on behalf of a participant of its
+// conversation. Reacting twice with the same emoji changes nothing.
+func (s *MessageService) AddReaction(ctx context.Context, messageID string, userID uuid.UUID, emoji string) (*MessageReactions, error) {
+	message, err := s.reactionTarget(ctx, messageID, userID, emoji)
+	if err != nil {
+		return nil, err
+	}
+	if message.IsDeleted() {
+		return nil, ErrMessageDeleted
+	}
+
+	added, err := s.reactionRepo.Add(ctx, &models.Reaction{
+		MessageID: message.ID,
+		UserID:    userID,
+		Emoji:     emoji,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.reactionChanged(ctx, message, userID, emoji, added, ReactionAdded)
+}
+
+// RemoveReaction takes back the user's reaction to a message. Removing a
+// reaction that does not exist changes nothing.
+func (s *MessageService) RemoveReaction(ctx context.Context, messageID string, userID uuid.UUID, emoji string) (*MessageReactions, error) {
+	message, err := s.reactionTarget(ctx, messageID, userID, emoji)
+	if err != nil {
+		return nil, err
+	}
+
+	removed, err := s.reactionRepo.Remove(ctx, message.ID, userID, emoji)
+	if err != nil {
+		return nil, err
+	}
+	return s.reactionChanged(ctx, message, userID, emoji, removed, ReactionRemoved)
+}
+
+// reactionTarget validates a reaction request and loads the message
+func (s *MessageService) reactionTarget(ctx context.Context, messageID string, userID uuid.UUID, emoji string) (*models.Message, error) {
+	msgUUID, err := uuid.Parse(messageID)
+	if err != nil {
+		return nil, errors.New("invalid message ID")
+	}
+	if !validEmoji(emoji) {
+		return nil, ErrInvalidEmoji
+	}
+
+	message, err := s.messageRepo.GetByID(ctx, msgUUID)
+	if err != nil {
+		return nil, err
+	}
+	userIDs, err := s.participants(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+	if !containsUser(userIDs, userID) {
+		return nil, ErrNotParticipant
+	}
+	return message, nil
+}
+
+// reactionChanged returns the message's reactions as userID now sees them
+// and, when the reaction really changed, sends a reaction event carrying the
+// emoji's new count to the conversation's participants
+func (s *MessageService) reactionChanged(ctx context.Context, message *models.Message, userID uuid.UUID, emoji string, changed bool, state string) (*MessageReactions, error) {
+	summaries, err := s.reactionRepo.Summarize(ctx, []uuid.UUID{message.ID}, userID)
+	if err != nil {
+		return nil, err
+	}
+	result := &MessageReactions{MessageID: message.ID, Reactions: summaries[message.ID]}
+	if result.Reactions == nil {
+		result.Reactions = []models.ReactionSummary{}
+	}
+	if !changed {
+		return result, nil
+	}
+
+	count := reactionCount{Emoji: emoji}
+	for _, summary := range result.Reactions {
+		if summary.Emoji == emoji {
+			count.Count = summary.Count
+		}
+	}
+	payload, err := json.Marshal(count)
+	if err != nil {
+		return nil, err
+	}
+	s.notifyConversation(message, websocket.WebSocketMessage{
+		Type:           websocket.MessageTypeReaction,
+		SenderID:       userID.String(),
+		UserID:         userID.String(),
+		ConversationID: message.ConversationID(),
+		MessageID:      message.ID.String(),
+		Emoji:          emoji,
+		State:          state,
+		Payload:        payload,
+		Timestamp:      time.Now(),
+	})
+	return result, nil
+}
+
+// reactionCount is the payload of a reaction event. It has no reacted-by-me
+// flag because the same frame goes to every participant.
+type reactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+// validEmoji accepts short printable strings without spaces. Emoji are not
+// checked against a list so new emoji and skin tone sequences work.
+func validEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > maxEmojiLength || !utf8.ValidString(emoji) {
+		return false
+	}
+	for _, r := range emoji {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *MessageService) MarkAsRead(ctx context.Context, messageID string, userID uuid.UUID) error {
+	msgUUID, err := uuid.Parse(messageID)
+	if err != nil {
+		return errors.New("invalid message ID")
+	}
+
+	message, err := s.messageRepo.GetByID(ctx, msgUUID)
+	if err != nil {
+		return err
+	}
+	userIDs, err := s.participants(ctx, message)
+	if err != nil {
+		return err
+	}
+	if !containsUser(userIDs, userID) {
+		return ErrNotParticipant
+	}
+
+	if err := s.messageRepo.MarkAsRead(ctx, []uuid.UUID{msgUUID}, userID); err != nil {
+		return err
+	}
+	// Reading a message also reads everything before it
+	_, err = s.markRead(ctx, userID, message)
+	return err
+}
+
+// ReceiptList lists who a message reached and who read it
+type ReceiptList struct {
+	MessageID      uuid.UUID        `json:"message_id"`
+	DeliveredCount int              `json:"delivered_count"`
+	ReadCount      int              `json:"read_count"`
+	Receipts       []models.Receipt `json:"receipts"`
+}
+
+// GetReceipts returns a message's receipts, readers first in the order they
+// read it, then users it was only delivered to. Only participants of the
+// message's conversation may see them.
+func (s *MessageService) GetReceipts(ctx context.Context, messageID string, userID uuid.UUID) (*ReceiptList, error) {
+	msgUUID, err := uuid.Parse(messageID)
+	if err != nil {
+		return nil, errors.New("invalid message ID")
+	}
+
+	message, err := s.messageRepo.GetByID(ctx, msgUUID)
+	if err != nil {
+		return nil, err
+	}
+	userIDs, err := s.participants(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+	if !containsUser(userIDs, userID) {
+		return nil, ErrNotParticipant
+	}
+
+	receipts, err := s.messageRepo.GetReceipts(ctx, msgUUID)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(receipts, func(i, j int) bool {
+		return receiptBefore(receipts[i], receipts[j])
+	})
+	if receipts == nil {
+		receipts = []models.Receipt{}
+	}
+
+	list := &ReceiptList{MessageID: msgUUID, Receipts: receipts}
+	message.CountReceipts(receipts)
+	list.DeliveredCount, list.ReadCount = message.DeliveredCount, message.ReadCount
+	return list, nil
+}
+
+func receiptBefore(a, b models.Receipt) bool {
+	if (a.ReadAt == nil) != (b.ReadAt == nil) {
+		return a.ReadAt != nil
+	}
+	if a.ReadAt != nil && !a.ReadAt.Equal(*b.ReadAt) {
+		return a.ReadAt.Before(*b.ReadAt)
+	}
+	if (a.DeliveredAt == nil) != (b.DeliveredAt == nil) {
+		return a.DeliveredAt != nil
+	}
+	if a.DeliveredAt != nil && !a.DeliveredAt.Equal(*b.DeliveredAt) {
+		return a.DeliveredAt.Before(*b.DeliveredAt)
+	}
+	return a.UserID.String() < b.UserID.String()
+}
+
+// HandleReadMessage moves the sender's read pointer for a read frame
+// received over a WebSocket connection
+func (s *MessageService) HandleReadMessage(ctx context.Context, msg websocket.WebSocketMessage) error {
+	userID, err := uuid.Parse(msg.SenderID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+	messageID, err := uuid.Parse(msg.MessageID)
+	if err != nil {
+		return errors.New("invalid message ID")
+	}
+
+	message, err := s.messageRepo.GetByID(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	if msg.ConversationID != "" && msg.ConversationID != message.ConversationID() {
+		return ErrMessageNotInConversation
+	}
+	_, err = s.markRead(ctx, userID, message)
+	return err
+}
+
+func (s *MessageService) MarkAsDelivered(ctx context.Context, messageID string, userID string) error {
+	msgUUID, err := uuid.Parse(messageID)
+	if err != nil {
+		return errors.New("invalid message ID")
+	}
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+	return s.messageRepo.MarkAsDelivered(ctx, msgUUID, userUUID)
+}
+
+// Contacts returns the users among userIDs who share a group with the user
+// or have a direct conversation in their inbox, in the order given
+func (s *MessageService) Contacts(ctx context.Context, userID string, userIDs []string) ([]string, error) {
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	groups, err := s.groupRepo.GetUserGroups(ctx, userUUID)
+	if err != nil {
+		return nil, err
+	}
+	shared := map[uuid.UUID]bool{userUUID: true}
+	for _, group := range groups {
+		members, err := s.groupRepo.GetMembers(ctx, group.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			shared[member.UserID] = true
+		}
+	}
+
+	contacts := make([]string, 0, len(userIDs))
+	for _, id := range userIDs {
+		otherUUID, err := uuid.Parse(id)
+		if err != nil {
+			continue
+		}
+		if !shared[otherUUID] {
+			_, err := s.conversationRepo.GetConversation(ctx, userUUID, models.DirectConversationID(userUUID, otherUUID))
+			if errors.Is(err, repository.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		contacts = append(contacts, id)
+	}
+	return contacts, nil
+}
+
+func (s *MessageService) UpdateMessage(ctx context.Context, message *models.Message) error {
+	return s.messageRepo.Update(ctx, message)
+}
+
+// DeleteMessage deletes a message for the user only (DeleteForMe) or, for
+// its sender within the delete window, for everyone (DeleteForEveryone).
+// Deleting for everyone leaves a tombstone so replies keep their parent.
+func (s *MessageService) DeleteMessage(ctx context.Context, id string, userID uuid.UUID, scope string) error {
+	messageID, err := uuid.Parse(id)
+	if err != nil {
+		return errors.New("invalid message ID")
+	}
+
+	message, err := s.messageRepo.GetByID(ctx, messageID)
+	if err != nil {
+		return err
+	}
+
+	switch scope {
+	case DeleteForMe:
+		return s.deleteForMe(ctx, message, userID)
+	case DeleteForEveryone:
+		return s.deleteForEveryone(ctx, message, userID)
+	default:
+		return errors.New("scope must be me or everyone")
+	}
+}
+
+func (s *MessageService) deleteForMe(ctx context.Context, message *models.Message, userID uuid.UUID) error {
+	userIDs, err := s.participants(ctx, message)
+	if err != nil {
+		return err
+	}
+	if !containsUser(userIDs, userID) {
+		return ErrNotParticipant
+	}
+
+	if err := s.messageRepo.HideMessage(ctx, message.ID, userID); err != nil {
+		return err
+	}
+	if err := s.searchIndex.Hide(ctx, message.ID, userID); err != nil {
+		logrus.WithError(err).WithField("message_id", message.ID).Error("Failed to hide message from search")
+	}
+
+	// Only the user's own devices need to drop the message
+	event, err := json.Marshal(websocket.WebSocketMessage{
+		Type:           websocket.MessageTypeDelete,
+		SenderID:       userID.String(),
+		ConversationID: message.ConversationID(),
+		MessageID:      message.ID.String(),
+		Scope:          DeleteForMe,
+		Timestamp:      time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.wsManager.SendToUser(userID.String(), event); err != nil {
+		logrus.WithError(err).WithField("user_id", userID).Debug("Failed to send delete event")
+	}
+	return nil
+}
+
+func (s *MessageService) deleteForEveryone(ctx context.Context, message *models.Message, userID uuid.UUID) error {
+	if message.SenderID != userID {
+		return ErrNotSender
+	}
+	if message.IsDeleted() {
+		return nil
+	}
+	if time.Since(message.Timestamp) > s.config.DeleteWindow {
+		return ErrDeleteWindowExpired
+	}
+
+	// Earlier versions go first: once the tombstone is stored, deleting again
+	// is a no-op and would not retry
+	if err := s.revisionRepo.DeleteByMessageID(ctx, message.ID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	message.Tombstone(now)
+	if err := s.messageRepo.Update(ctx, message); err != nil {
+		return err
+	}
+	if err := s.conversationRepo.UpdatePreview(ctx, message); err != nil {
+		logrus.WithError(err).WithField("message_id", message.ID).Error("Failed to update conversation preview")
+	}
+	if err := s.searchIndex.Remove(ctx, message.ID); err != nil {
+		logrus.WithError(err).WithField("message_id", message.ID).Error("Failed to remove message from search")
+	}
+
+	s.notifyConversation(message, websocket.WebSocketMessage{
+		Type:           websocket.MessageTypeDelete,
+		SenderID:       userID.String(),
+		ConversationID: message.ConversationID(),
+		MessageID:      message.ID.String(),
+		Scope:          DeleteForEveryone,
+		Timestamp:      now,
+	})
+	return nil
 }
@@ -0,0 +1,294 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMarkAsReadRejectsNonParticipant(t *testing.T) {
+	f := newMessageFixture(t)
+	ctx := context.Background()
+	alice, bob, mallory := uuid.New(), uuid.New(), uuid.New()
+
+	message := f.send(t, alice, &bob, nil, time.Now())
+
+	err := f.service.MarkAsRead(ctx, message.ID.String(), mallory)
+	if !errors.Is(err, ErrNotParticipant) {
+		t.Fatalf("MarkAsRead: got %v, want ErrNotParticipant", err)
+	}
+
+	receipts, err := f.messages.GetReceipts(ctx, message.ID)
+	if err != nil {
+		t.Fatalf("GetReceipts: %v", err)
+	}
+	if len(receipts) != 0 {
+		t.Fatalf("got %d receipts, want none", len(receipts))
+	}
+	stored, err := f.messages.GetByID(ctx, message.ID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if stored.ReadCount != 0 {
+		t.Fatalf("read count: got %d, want 0", stored.ReadCount)
+	}
+
+	// The recipient can still read it
+	if err := f.service.MarkAsRead(ctx, message.ID.String(), bob); err != nil {
+		t.Fatalf("MarkAsRead: %v", err)
+	}
+	if stored, _ = f.messages.GetByID(ctx, message.ID); stored.ReadCount != 1 {
+		t.Fatalf("read count: got %d, want 1", stored.ReadCount)
+	}
+}
+
+func TestMarkConversationReadRecordsReceipts(t *testing.T) {
+	f := newMessageFixture(t)
+	ctx := context.Background()
+	alice, bob := uuid.New(), uuid.New()
+
+	start := time.Now().Add(-time.Hour)
+	m1 := f.send(t, alice, &bob, nil, start)
+	m2 := f.send(t, alice, &bob, nil, start.Add(time.Minute))
+	own := f.send(t, bob, &alice, nil, start.Add(2*time.Minute))
+	m3 := f.send(t, alice, &bob, nil, start.Add(3*time.Minute))
+
+	readBy := func(message uuid.UUID) int {
+		t.Helper()
+		stored, err := f.messages.GetByID(ctx, message)
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		return stored.ReadCount
+	}
+
+	// Sending moved bob's pointer to his own message, so only m3 is new
+	if _, err := f.service.MarkConversationRead(ctx, bob, m3.ConversationID(), m3.ID); err != nil {
+		t.Fatalf("MarkConversationRead: %v", err)
+	}
+	if readBy(m3.ID) != 1 {
+		t.Fatal("m3 was not marked read")
+	}
+	if readBy(m1.ID) != 0 || readBy(m2.ID) != 0 {
+		t.Fatal("messages before the previous read pointer were marked read again")
+	}
+	if readBy(own.ID) != 0 {
+		t.Fatal("the reader's own message got a receipt")
+	}
+
+	// Bob answers; alice reading it leaves her own messages without receipts
+	reply := f.send(t, bob, &alice, nil, start.Add(4*time.Minute))
+	if _, err := f.service.MarkConversationRead(ctx, alice, reply.ConversationID(), reply.ID); err != nil {
+		t.Fatalf("MarkConversationRead: %v", err)
+	}
+	if readBy(reply.ID) != 1 || readBy(m3.ID) != 1 || readBy(m1.ID) != 0 {
+		t.Fatal("alice's receipts went to the wrong messages")
+	}
+}
+
+func TestMarkConversationReadWithoutEntryReadsEarlierMessages(t *testing.T) {
+	f := newMessageFixture(t)
+	ctx := context.Background()
+	alice, bob := uuid.New(), uuid.New()
+
+	start := time.Now().Add(-time.Hour)
+	m1 := f.send(t, alice, &bob, nil, start)
+	m2 := f.send(t, alice, &bob, nil, start.Add(time.Minute))
+	m3 := f.send(t, alice, &bob, nil, start.Add(2*time.Minute))
+
+	if _, err := f.service.MarkConversationRead(ctx, bob, m2.ConversationID(), m2.ID); err != nil {
+		t.Fatalf("MarkConversationRead: %v", err)
+	}
+	for _, tt := range []struct {
+		id   uuid.UUID
+		read int
+	}{{m1.ID, 1}, {m2.ID, 1}, {m3.ID, 0}} {
+		stored, _ := f.messages.GetByID(ctx, tt.id)
+		if stored.ReadCount != tt.read {
+			t.Errorf("message %s: read count %d, want %d", tt.id, stored.ReadCount, tt.read)
+		}
+	}
+}
+
+func TestGetReceiptsListsReadersFirst(t *testing.T) {
+	f := newMessageFixture(t)
+	ctx := context.Background()
+	alice, bob, carol, dave, outsider := uuid.New(), uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	groupID := uuid.New()
+	for _, userID := range []uuid.UUID{alice, bob, carol, dave} {
+		f.groups.AddMember(ctx, groupID, userID, "member")
+	}
+
+	message := f.send(t, alice, nil, &groupID, time.Now())
+	if err := f.messages.MarkAsDelivered(ctx, message.ID, carol); err != nil {
+		t.Fatalf("MarkAsDelivered: %v", err)
+	}
+	if err := f.service.MarkAsRead(ctx, message.ID.String(), dave); err != nil {
+		t.Fatalf("MarkAsRead: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	if err := f.service.MarkAsRead(ctx, message.ID.String(), bob); err != nil {
+		t.Fatalf("MarkAsRead: %v", err)
+	}
+
+	list, err := f.service.GetReceipts(ctx, message.ID.String(), alice)
+	if err != nil {
+		t.Fatalf("GetReceipts: %v", err)
+	}
+	if list.DeliveredCount != 3 || list.ReadCount != 2 {
+		t.Fatalf("counts: got %d delivered, %d read, want 3 and 2", list.DeliveredCount, list.ReadCount)
+	}
+	want := []uuid.UUID{dave, bob, carol}
+	if len(list.Receipts) != len(want) {
+		t.Fatalf("got %d receipts, want %d", len(list.Receipts), len(want))
+	}
+	for i, userID := range want {
+		if list.Receipts[i].UserID != userID {
+			t.Fatalf("receipt %d: got user %s, want %s", i, list.Receipts[i].UserID, userID)
+		}
+	}
+
+	if _, err := f.service.GetReceipts(ctx, message.ID.String(), outsider); !errors.Is(err, ErrNotParticipant) {
+		t.Fatalf("outsider: got %v, want ErrNotParticipant", err)
+	}
+}
+
+func TestRecordConversationCountsUnread(t *testing.T) {
+	f := newMessageFixture(t)
+	ctx := context.Background()
+	alice, bob, carol := uuid.New(), uuid.New(), uuid.New()
+	groupID := uuid.New()
+	for _, userID := range []uuid.UUID{alice, bob, carol} {
+		f.groups.AddMember(ctx, groupID, userID, "member")
+	}
+
+	unread := func(userID uuid.UUID, conversationID string) int {
+		t.Helper()
+		counts, err := f.unread.Get(ctx, userID, []string{conversationID})
+		if err != nil {
+			t.Fatalf("unread Get: %v", err)
+		}
+		return counts[conversationID]
+	}
+
+	start := time.Now().Add(-time.Hour)
+	direct := f.send(t, alice, &bob, nil, start)
+	f.send(t, alice, &bob, nil, start.Add(time.Minute))
+	if got := unread(bob, direct.ConversationID()); got != 2 {
+		t.Fatalf("bob unread: got %d, want 2", got)
+	}
+	if got := unread(alice, direct.ConversationID()); got != 0 {
+		t.Fatalf("alice unread: got %d, want 0", got)
+	}
+
+	// Replying means bob has caught up
+	f.send(t, bob, &alice, nil, start.Add(2*time.Minute))
+	if got := unread(bob, direct.ConversationID()); got != 0 {
+		t.Fatalf("bob unread after replying: got %d, want 0", got)
+	}
+	if got := unread(alice, direct.ConversationID()); got != 1 {
+		t.Fatalf("alice unread: got %d, want 1", got)
+	}
+
+	group := f.send(t, carol, nil, &groupID, start.Add(3*time.Minute))
+	for userID, want := range map[uuid.UUID]int{alice: 1, bob: 1, carol: 0} {
+		if got := unread(userID, group.ConversationID()); got != want {
+			t.Errorf("group unread of %s: got %d, want %d", userID, got, want)
+		}
+	}
+
+	// Reading recounts from the read pointer
+	state, err := f.service.MarkConversationRead(ctx, alice, group.ConversationID(), group.ID)
+	if err != nil {
+		t.Fatalf("MarkConversationRead: %v", err)
+	}
+	if state.UnreadCount != 0 || unread(alice, group.ConversationID()) != 0 {
+		t.Fatalf("alice group unread after reading: got %d, want 0", state.UnreadCount)
+	}
+}
+
+func TestGetConversationsOrdersInbox(t *testing.T) {
+	f := newMessageFixture(t)
+	ctx := context.Background()
+	alice, bob, carol := uuid.New(), uuid.New(), uuid.New()
+	groupID := uuid.New()
+	for _, userID := range []uuid.UUID{alice, bob, carol} {
+		f.groups.AddMember(ctx, groupID, userID, "member")
+	}
+
+	start := time.Now().Add(-time.Hour)
+	withAlice := f.send(t, alice, &bob, nil, start)
+	inGroup := f.send(t, carol, nil, &groupID, start.Add(time.Minute))
+	withCarol := f.send(t, carol, &bob, nil, start.Add(2*time.Minute))
+	f.send(t, carol, &bob, nil, start.Add(3*time.Minute))
+	// An older message arriving late does not move the conversation up
+	f.send(t, alice, &bob, nil, start.Add(-time.Minute))
+
+	first, err := f.service.GetConversations(ctx, bob, "", 2)
+	if err != nil {
+		t.Fatalf("GetConversations: %v", err)
+	}
+	if first.NextCursor == "" {
+		t.Fatal("first page has no next cursor")
+	}
+	second, err := f.service.GetConversations(ctx, bob, first.NextCursor, 2)
+	if err != nil {
+		t.Fatalf("GetConversations: %v", err)
+	}
+	if second.NextCursor != "" {
+		t.Fatal("last page has a next cursor")
+	}
+
+	got := append(first.Conversations, second.Conversations...)
+	want := []struct {
+		id     string
+		unread int
+	}{
+		{withCarol.ConversationID(), 2},
+		{inGroup.ConversationID(), 1},
+		{withAlice.ConversationID(), 2},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d conversations, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].ID != want[i].id || got[i].UnreadCount != want[i].unread {
+			t.Errorf("conversation %d: got %s with %d unread, want %s with %d", i, got[i].ID, got[i].UnreadCount, want[i].id, want[i].unread)
+		}
+	}
+	if got[2].LastMessageID == nil || *got[2].LastMessageID != withAlice.ID {
+		t.Error("a late older message replaced the conversation's last message")
+	}
+
+	if _, err := f.service.GetConversations(ctx, bob, "not a cursor", 2); err == nil {
+		t.Fatal("invalid cursor: got nil error")
+	}
+}
+
+func TestContactsSharingGroupOrConversation(t *testing.T) {
+	f := newMessageFixture(t)
+	ctx := context.Background()
+	alice, bob, carol, dave, stranger := uuid.New(), uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	groupID := uuid.New()
+	for _, userID := range []uuid.UUID{alice, carol} {
+		f.groups.AddMember(ctx, groupID, userID, "member")
+	}
+
+	// Bob wrote to alice; dave is in no group or conversation with her
+	f.send(t, bob, &alice, nil, time.Now())
+	f.send(t, dave, &stranger, nil, time.Now())
+
+	requested := []string{stranger.String(), carol.String(), dave.String(), bob.String(), alice.String()}
+	contacts, err := f.service.Contacts(ctx, alice.String(), requested)
+	if err != nil {
+		t.Fatalf("Contacts: %v", err)
+	}
+	want := []string{carol.String(), bob.String(), alice.String()}
+	if strings.Join(contacts, ",") != strings.Join(want, ",") {
+		t.Fatalf("got %v, want %v", contacts, want)
+	}
+}
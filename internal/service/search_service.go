@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/chat-backend/internal/models"
+	"github.com/chat-backend/internal/repository"
+)
+
+// Search page sizes
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+)
+
+// maxSearchLength caps the length in bytes of a search query
+const maxSearchLength = 256
+
+// ErrInvalidSearch is returned when a search query or one of its filters is malformed
+var ErrInvalidSearch = errors.New("invalid search")
+
+type SearchService struct {
+	searchIndex repository.SearchIndex
+	groupRepo   repository.GroupRepository
+}
+
+func NewSearchService(searchIndex repository.SearchIndex, groupRepo repository.GroupRepository) *SearchService {
+	return &SearchService{
+		searchIndex: searchIndex,
+		groupRepo:   groupRepo,
+	}
+}
+
+// SearchInput is a message search as given in the query string. From and
+// To take RFC 3339 times or dates; a To date includes the whole day.
+type SearchInput struct {
+	Query       string `form:"q"`
+	SenderID    string `form:"sender_id"`
+	GroupID     string `form:"group_id"`
+	ContentType string `form:"content_type"`
+	From        string `form:"from"`
+	To          string `form:"to"`
+	Cursor      string `form:"cursor"`
+	Limit       int    `form:"limit"`
+}
+
+// SearchPage is one page of search results, newest first. Snippets are
+// HTML-escaped with the matched terms wrapped in <mark> tags.
+type SearchPage struct {
+	Results    []models.SearchHit `json:"results"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+}
+
+// SearchMessages searches the messages of the conversations the user takes
+// part in: their direct messages and the groups they belong to, or only the
+// group given as a filter
+func (s *SearchService) SearchMessages(ctx context.Context, userID uuid.UUID, input SearchInput) (*SearchPage, error) {
+	query, err := s.buildQuery(ctx, userID, input)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := query.Limit
+	query.Limit = limit + 1
+	hits, err := s.searchIndex.Search(ctx, *query)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &SearchPage{Results: hits}
+	if len(hits) > limit {
+		page.Results = hits[:limit]
+		last := page.Results[limit-1]
+		page.NextCursor = models.Cursor{Timestamp: last.Timestamp, ID: last.MessageID.String()}.Encode()
+	}
+	if page.Results == nil {
+		page.Results = []models.SearchHit{}
+	}
+	for i := range page.Results {
+		page.Results[i].Snippet = highlightHTML(page.Results[i].Snippet)
+	}
+	return page, nil
+}
+
+func (s *SearchService) buildQuery(ctx context.Context, userID uuid.UUID, input SearchInput) (*models.SearchQuery, error) {
+	text := strings.TrimSpace(input.Query)
+	if text == "" {
+		return nil, fmt.Errorf("%w: q is required", ErrInvalidSearch)
+	}
+	if len(text) > maxSearchLength {
+		return nil, fmt.Errorf("%w: q is longer than %d bytes", ErrInvalidSearch, maxSearchLength)
+	}
+
+	query := &models.SearchQuery{
+		Text:        text,
+		UserID:      userID,
+		ContentType: input.ContentType,
+		Limit:       input.Limit,
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultSearchLimit
+	}
+	if query.Limit > maxSearchLimit {
+		query.Limit = maxSearchLimit
+	}
+
+	if input.SenderID != "" {
+		senderID, err := uuid.Parse(input.SenderID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid sender_id", ErrInvalidSearch)
+		}
+		query.SenderID = &senderID
+	}
+
+	var err error
+	if query.From, err = parseSearchTime(input.From, false); err != nil {
+		return nil, fmt.Errorf("%w: invalid from", ErrInvalidSearch)
+	}
+	if query.To, err = parseSearchTime(input.To, true); err != nil {
+		return nil, fmt.Errorf("%w: invalid to", ErrInvalidSearch)
+	}
+	if query.Before, err = decodeMessageCursor(input.Cursor); err != nil {
+		return nil, err
+	}
+
+	groups, err := s.groupRepo.GetUserGroups(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if input.GroupID == "" {
+		query.IncludeDirect = true
+		for _, group := range groups {
+			query.GroupIDs = append(query.GroupIDs, group.ID)
+		}
+		return query, nil
+	}
+
+	groupID, err := uuid.Parse(input.GroupID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid group_id", ErrInvalidSearch)
+	}
+	for _, group := range groups {
+		if group.ID == groupID {
+			query.GroupIDs = []uuid.UUID{groupID}
+			return query, nil
+		}
+	}
+	return nil, ErrNotParticipant
+}
+
+// parseSearchTime parses an RFC 3339 time or a date. A date that ends a
+// range is moved to the end of that day.
+func parseSearchTime(value string, end bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// highlightHTML escapes a snippet for HTML and turns its highlight markers
+// into <mark> tags
+func highlightHTML(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, models.HighlightStart, "<mark>")
+	return strings.ReplaceAll(escaped, models.HighlightEnd, "</mark>")
+}
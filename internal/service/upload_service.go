@@ -0,0 +1,444 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
+	"github.com/chat-backend/internal/models"
+	"github.com/chat-backend/internal/repository"
+)
+
+const (
+	// defaultMaxUploadSize applies when UploadConfig.MaxSize is unset
+	defaultMaxUploadSize = 1 << 30
+	// defaultUploadExpiry applies when UploadConfig.Expiry is unset
+	defaultUploadExpiry = 24 * time.Hour
+	// defaultJanitorInterval applies when UploadConfig.JanitorInterval is unset
+	defaultJanitorInterval = 10 * time.Minute
+	// janitorBatchSize is how many expired uploads are deleted at a time
+	janitorBatchSize = 100
+)
+
+// ChecksumAlgorithms lists the algorithms accepted for chunk checksums
+var ChecksumAlgorithms = []string{"md5", "sha1", "sha256"}
+
+var (
+	// ErrOffsetMismatch is returned when a chunk does not start where the
+	// upload's received content ends
+	ErrOffsetMismatch = errors.New("chunk offset does not match the upload offset")
+	// ErrChunkTooLarge is returned when a chunk runs past the end of the upload
+	ErrChunkTooLarge = errors.New("chunk runs past the end of the upload")
+	// ErrInvalidChecksum is returned when a chunk checksum cannot be parsed or
+	// uses an unsupported algorithm
+	ErrInvalidChecksum = errors.New("invalid chunk checksum")
+	// ErrChecksumMismatch is returned when a chunk does not match its checksum
+	ErrChecksumMismatch = errors.New("chunk checksum does not match")
+)
+
+// UploadConfig holds the resumable upload rules read from configuration
+type UploadConfig struct {
+	MaxSize         int64         // Largest accepted resumable upload, in bytes
+	Expiry          time.Duration // How long an upload may go without a chunk
+	JanitorInterval time.Duration // How often expired uploads are deleted
+}
+
+// UploadService runs resumable uploads. Each chunk is stored as its own blob;
+// once the last one arrives they are joined into an attachment with the
+// upload's ID, stored and checked like any other.
+type UploadService struct {
+	uploadRepo        repository.UploadRepository
+	attachmentRepo    repository.AttachmentRepository
+	blobStore         repository.BlobStore
+	attachmentService *AttachmentService
+	config            UploadConfig
+}
+
+func NewUploadService(
+	uploadRepo repository.UploadRepository,
+	attachmentRepo repository.AttachmentRepository,
+	blobStore repository.BlobStore,
+	attachmentService *AttachmentService,
+	config UploadConfig,
+) *UploadService {
+	if config.MaxSize <= 0 {
+		config.MaxSize = defaultMaxUploadSize
+	}
+	if config.Expiry <= 0 {
+		config.Expiry = defaultUploadExpiry
+	}
+	if config.JanitorInterval <= 0 {
+		config.JanitorInterval = defaultJanitorInterval
+	}
+	return &UploadService{
+		uploadRepo:        uploadRepo,
+		attachmentRepo:    attachmentRepo,
+		blobStore:         blobStore,
+		attachmentService: attachmentService,
+		config:            config,
+	}
+}
+
+// MaxSize returns the largest accepted resumable upload, in bytes
+func (s *UploadService) MaxSize() int64 {
+	return s.config.MaxSize
+}
+
+// CreateUploadInput describes a file about to be uploaded in chunks
+type CreateUploadInput struct {
+	OwnerID     uuid.UUID
+	Filename    string
+	ContentType string
+	Size        int64
+}
+
+// CreateUpload starts a resumable upload, reserving its full size against the
+// owner's quota
+func (s *UploadService) CreateUpload(ctx context.Context, input CreateUploadInput) (*models.Upload, error) {
+	if input.Size <= 0 {
+		return nil, ErrLengthRequired
+	}
+	if input.Size > s.config.MaxSize {
+		return nil, ErrAttachmentTooLarge
+	}
+	if err := s.attachmentService.checkQuota(ctx, input.OwnerID, input.Size); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	upload := &models.Upload{
+		ID:          uuid.New(),
+		OwnerID:     input.OwnerID,
+		Filename:    cleanFilename(input.Filename),
+		ContentType: input.ContentType,
+		Size:        input.Size,
+		ExpiresAt:   now.Add(s.config.Expiry),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.uploadRepo.Create(ctx, upload); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// GetUpload returns the state of one of the user's uploads. An upload that
+// has become an attachment is reported complete, so that clients resuming it
+// learn it is done.
+func (s *UploadService) GetUpload(ctx context.Context, userID uuid.UUID, id string) (*models.Upload, error) {
+	uploadID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, errors.New("invalid upload ID")
+	}
+
+	upload, err := s.uploadRepo.GetByID(ctx, uploadID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return s.finishedUpload(ctx, userID, uploadID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Other users' and expired uploads do not exist as far as callers know
+	if upload.OwnerID != userID || !upload.ExpiresAt.After(time.Now()) {
+		return nil, repository.ErrNotFound
+	}
+
+	// The last chunk arrived but joining the chunks failed; try again
+	if upload.Complete() {
+		if _, err := s.finish(ctx, upload); err != nil {
+			return nil, err
+		}
+	}
+	return upload, nil
+}
+
+// finishedUpload describes the user's attachment created from an upload
+func (s *UploadService) finishedUpload(ctx context.Context, userID, uploadID uuid.UUID) (*models.Upload, error) {
+	attachment, err := s.attachmentRepo.GetByID(ctx, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if attachment.OwnerID != userID {
+		return nil, repository.ErrNotFound
+	}
+	return &models.Upload{
+		ID:          attachment.ID,
+		OwnerID:     attachment.OwnerID,
+		Filename:    attachment.Filename,
+		ContentType: attachment.ContentType,
+		Size:        attachment.Size,
+		Offset:      attachment.Size,
+		CreatedAt:   attachment.CreatedAt,
+		UpdatedAt:   attachment.CreatedAt,
+	}, nil
+}
+
+// ChunkInput is a piece of an upload. Checksum, when set, has the form
+// "<algorithm> <base64 digest>" and is verified before the chunk is accepted.
+type ChunkInput struct {
+	Offset   int64
+	Size     int64
+	Checksum string
+	Body     io.Reader
+}
+
+// WriteChunk appends a chunk to one of the user's uploads and returns the
+// upload's new state. The chunk must start at the upload's offset. When it
+// completes the upload, the upload becomes an attachment.
+func (s *UploadService) WriteChunk(ctx context.Context, userID uuid.UUID, id string, input ChunkInput) (*models.Upload, error) {
+	newChecksum, digest, err := parseChecksum(input.Checksum)
+	if err != nil {
+		return nil, err
+	}
+	if input.Size < 0 {
+		return nil, ErrLengthRequired
+	}
+
+	upload, err := s.GetUpload(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if input.Offset != upload.Offset {
+		return nil, ErrOffsetMismatch
+	}
+	if input.Offset+input.Size > upload.Size {
+		return nil, ErrChunkTooLarge
+	}
+	if input.Size == 0 {
+		return upload, nil
+	}
+
+	chunk := &models.UploadChunk{
+		UploadID:   upload.ID,
+		Offset:     input.Offset,
+		Size:       input.Size,
+		StorageKey: "uploads/" + upload.OwnerID.String() + "/" + upload.ID.String() + "/" + uuid.New().String(),
+		CreatedAt:  time.Now(),
+	}
+	body := io.LimitReader(input.Body, input.Size)
+	var checksum hash.Hash
+	if newChecksum != nil {
+		checksum = newChecksum()
+		body = io.TeeReader(body, checksum)
+	}
+	if err := s.blobStore.Put(ctx, chunk.StorageKey, body, chunk.Size, "application/octet-stream"); err != nil {
+		return nil, err
+	}
+	if checksum != nil && !bytes.Equal(checksum.Sum(nil), digest) {
+		s.deleteChunks(ctx, []models.UploadChunk{*chunk})
+		return nil, ErrChecksumMismatch
+	}
+
+	expiresAt := time.Now().Add(s.config.Expiry)
+	appended, err := s.uploadRepo.AppendChunk(ctx, chunk, expiresAt)
+	if err != nil || !appended {
+		s.deleteChunks(ctx, []models.UploadChunk{*chunk})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !appended {
+		// Another chunk got there first, or the upload expired meanwhile
+		if _, err := s.uploadRepo.GetByID(ctx, upload.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrOffsetMismatch
+	}
+
+	upload.Offset += chunk.Size
+	upload.ExpiresAt = expiresAt
+	upload.UpdatedAt = chunk.CreatedAt
+	if upload.Complete() {
+		if _, err := s.finish(ctx, upload); err != nil {
+			return nil, err
+		}
+	}
+	return upload, nil
+}
+
+// finish joins a complete upload's chunks into an attachment and deletes the
+// upload. It may run more than once for the same upload; the attachment is
+// only created once.
+func (s *UploadService) finish(ctx context.Context, upload *models.Upload) (*models.Attachment, error) {
+	chunks, err := s.uploadRepo.GetChunks(ctx, upload.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	content := &chunkReader{ctx: ctx, blobStore: s.blobStore, chunks: chunks}
+	defer content.Close()
+	// Each attempt writes its own blob, so a losing attempt only deletes its own
+	attachment, err := s.attachmentService.store(ctx, upload.ID, "attachments/"+upload.OwnerID.String()+"/"+uuid.New().String(), UploadInput{
+		OwnerID:     upload.OwnerID,
+		Filename:    upload.Filename,
+		ContentType: upload.ContentType,
+		Size:        upload.Size,
+		Body:        content,
+	})
+	if err != nil {
+		existing, getErr := s.attachmentRepo.GetByID(ctx, upload.ID)
+		if getErr != nil {
+			return nil, err
+		}
+		attachment = existing
+	}
+
+	chunks, err = s.uploadRepo.Delete(ctx, upload.ID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+	s.deleteChunks(ctx, chunks)
+	return attachment, nil
+}
+
+// DeleteUpload abandons one of the user's uploads and deletes its chunks
+func (s *UploadService) DeleteUpload(ctx context.Context, userID uuid.UUID, id string) error {
+	uploadID, err := uuid.Parse(id)
+	if err != nil {
+		return errors.New("invalid upload ID")
+	}
+
+	upload, err := s.uploadRepo.GetByID(ctx, uploadID)
+	if err != nil {
+		return err
+	}
+	if upload.OwnerID != userID {
+		return repository.ErrNotFound
+	}
+
+	chunks, err := s.uploadRepo.Delete(ctx, uploadID)
+	if err != nil {
+		return err
+	}
+	s.deleteChunks(ctx, chunks)
+	return nil
+}
+
+// RunJanitor deletes expired uploads every JanitorInterval until ctx is done
+func (s *UploadService) RunJanitor(ctx context.Context) {
+	ticker := time.NewTicker(s.config.JanitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.ExpireUploads(ctx); err != nil {
+				logrus.WithError(err).Error("Failed to expire uploads")
+			}
+		}
+	}
+}
+
+// ExpireUploads deletes every upload that has gone longer than the expiry
+// without receiving a chunk, along with its chunks
+func (s *UploadService) ExpireUploads(ctx context.Context) error {
+	for {
+		chunks, deleted, err := s.uploadRepo.DeleteExpired(ctx, time.Now(), janitorBatchSize)
+		if err != nil {
+			return err
+		}
+		s.deleteChunks(ctx, chunks)
+		if deleted > 0 {
+			logrus.WithField("uploads", deleted).Info("Expired abandoned uploads")
+		}
+		if deleted < janitorBatchSize {
+			return nil
+		}
+	}
+}
+
+// deleteChunks deletes the blobs of chunks that are no longer recorded.
+// Failures leave orphaned blobs behind and are only logged.
+func (s *UploadService) deleteChunks(ctx context.Context, chunks []models.UploadChunk) {
+	for _, chunk := range chunks {
+		if err := s.blobStore.Delete(ctx, chunk.StorageKey); err != nil {
+			logrus.WithError(err).WithField("key", chunk.StorageKey).Warn("Failed to delete upload chunk")
+		}
+	}
+}
+
+// parseChecksum parses a chunk checksum of the form "<algorithm> <base64
+// digest>". It returns a nil hash constructor when there is no checksum.
+func parseChecksum(value string) (func() hash.Hash, []byte, error) {
+	if value == "" {
+		return nil, nil, nil
+	}
+	algorithm, encoded, ok := strings.Cut(value, " ")
+	if !ok {
+		return nil, nil, ErrInvalidChecksum
+	}
+
+	var newChecksum func() hash.Hash
+	switch strings.ToLower(algorithm) {
+	case "md5":
+		newChecksum = md5.New
+	case "sha1":
+		newChecksum = sha1.New
+	case "sha256":
+		newChecksum = sha256.New
+	default:
+		return nil, nil, ErrInvalidChecksum
+	}
+
+	digest, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(digest) != newChecksum().Size() {
+		return nil, nil, ErrInvalidChecksum
+	}
+	return newChecksum, digest, nil
+}
+
+// chunkReader reads an upload's chunks one after another, opening each blob
+// only once the one before it is used up
+type chunkReader struct {
+	ctx       context.Context
+	blobStore repository.BlobStore
+	chunks    []models.UploadChunk
+	current   io.ReadCloser
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.EOF
+			}
+			content, err := r.blobStore.Get(r.ctx, r.chunks[0].StorageKey)
+			if err != nil {
+				return 0, err
+			}
+			r.current = content
+			r.chunks = r.chunks[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if errors.Is(err, io.EOF) {
+			r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	return r.current.Close()
+}
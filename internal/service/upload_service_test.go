@@ -0,0 +1,330 @@
+package service
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/chat-backend/internal/models"
+	"github.com/chat-backend/internal/repository"
+	"github.com/chat-backend/internal/repository/filesystem"
+)
+
+func TestParseChecksum(t *testing.T) {
+	sum := func(h interface{ Sum([]byte) []byte }) string {
+		return base64.StdEncoding.EncodeToString(h.Sum(nil))
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+		size    int // Digest size of the parsed algorithm, 0 for no checksum
+	}{
+		{name: "empty", value: ""},
+		{name: "md5", value: "md5 " + sum(md5.New()), size: md5.Size},
+		{name: "sha1", value: "sha1 " + sum(sha1.New()), size: sha1.Size},
+		{name: "sha256", value: "sha256 " + sum(sha256.New()), size: sha256.Size},
+		{name: "algorithm case", value: "SHA256 " + sum(sha256.New()), size: sha256.Size},
+		{name: "no digest", value: "sha256", wantErr: true},
+		{name: "unsupported algorithm", value: "crc32 AAAAAA==", wantErr: true},
+		{name: "not base64", value: "sha256 not-base64!", wantErr: true},
+		{name: "digest of another algorithm", value: "sha256 " + sum(md5.New()), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newChecksum, digest, err := parseChecksum(tt.value)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidChecksum) {
+					t.Fatalf("got %v, want ErrInvalidChecksum", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseChecksum: %v", err)
+			}
+			if tt.size == 0 {
+				if newChecksum != nil || digest != nil {
+					t.Fatal("got a checksum for an empty value")
+				}
+				return
+			}
+			if newChecksum().Size() != tt.size || len(digest) != tt.size {
+				t.Fatalf("got digest of %d bytes, want %d", len(digest), tt.size)
+			}
+		})
+	}
+}
+
+// uploadFixture wires an upload service to in-memory repositories and a
+// filesystem blob store in a temporary directory
+type uploadFixture struct {
+	service     *UploadService
+	uploads     *memUploads
+	attachments *memAttachments
+	blobs       *filesystem.BlobStore
+	dir         string
+	owner       uuid.UUID
+}
+
+func newUploadFixture(t *testing.T, quota int64) *uploadFixture {
+	t.Helper()
+	f := &uploadFixture{
+		uploads:     newMemUploads(),
+		attachments: newMemAttachments(),
+		dir:         t.TempDir(),
+		owner:       uuid.New(),
+	}
+	f.blobs = filesystem.NewBlobStore(f.dir)
+	attachmentService := NewAttachmentService(f.attachments, f.uploads, f.blobs, nil, nil, AttachmentConfig{UserQuota: quota})
+	f.service = NewUploadService(f.uploads, f.attachments, f.blobs, attachmentService, UploadConfig{})
+	return f
+}
+
+func (f *uploadFixture) create(t *testing.T, size int64) *models.Upload {
+	t.Helper()
+	upload, err := f.service.CreateUpload(context.Background(), CreateUploadInput{
+		OwnerID:     f.owner,
+		Filename:    "notes.txt",
+		ContentType: "text/plain",
+		Size:        size,
+	})
+	if err != nil {
+		t.Fatalf("CreateUpload: %v", err)
+	}
+	return upload
+}
+
+func (f *uploadFixture) write(upload *models.Upload, offset int64, content, checksum string) (*models.Upload, error) {
+	return f.service.WriteChunk(context.Background(), f.owner, upload.ID.String(), ChunkInput{
+		Offset:   offset,
+		Size:     int64(len(content)),
+		Checksum: checksum,
+		Body:     strings.NewReader(content),
+	})
+}
+
+// blobCount counts the blobs stored below a key prefix
+func (f *uploadFixture) blobCount(t *testing.T, prefix string) int {
+	t.Helper()
+	count := 0
+	err := filepath.WalkDir(filepath.Join(f.dir, filepath.FromSlash(prefix)), func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err == nil && !d.IsDir() {
+			count++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("walk blobs: %v", err)
+	}
+	return count
+}
+
+func (f *uploadFixture) attachmentContent(t *testing.T, id uuid.UUID) string {
+	t.Helper()
+	attachment, err := f.attachments.GetByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("attachment: %v", err)
+	}
+	content, err := f.blobs.Get(context.Background(), attachment.StorageKey)
+	if err != nil {
+		t.Fatalf("Get blob: %v", err)
+	}
+	defer content.Close()
+	data, err := io.ReadAll(content)
+	if err != nil {
+		t.Fatalf("read blob: %v", err)
+	}
+	return string(data)
+}
+
+func TestWriteChunkRejectsBadChunks(t *testing.T) {
+	f := newUploadFixture(t, 0)
+	upload := f.create(t, 10)
+
+	if _, err := f.write(upload, 0, "hello", ""); err != nil {
+		t.Fatalf("first chunk: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		offset  int64
+		content string
+		want    error
+	}{
+		{name: "offset behind", offset: 0, content: "hello", want: ErrOffsetMismatch},
+		{name: "offset ahead", offset: 7, content: "abc", want: ErrOffsetMismatch},
+		{name: "past the end", offset: 5, content: "world!", want: ErrChunkTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := f.write(upload, tt.offset, tt.content, ""); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// Nothing was recorded for the rejected chunks
+	state, err := f.service.GetUpload(context.Background(), f.owner, upload.ID.String())
+	if err != nil {
+		t.Fatalf("GetUpload: %v", err)
+	}
+	if state.Offset != 5 {
+		t.Fatalf("offset: got %d, want 5", state.Offset)
+	}
+	if n := f.blobCount(t, "uploads"); n != 1 {
+		t.Fatalf("got %d chunk blobs, want 1", n)
+	}
+}
+
+func TestWriteChunkVerifiesChecksum(t *testing.T) {
+	f := newUploadFixture(t, 0)
+	upload := f.create(t, 10)
+
+	sum := sha256.Sum256([]byte("hello"))
+	valid := "sha256 " + base64.StdEncoding.EncodeToString(sum[:])
+
+	if _, err := f.write(upload, 0, "jello", valid); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("mismatch: got %v, want ErrChecksumMismatch", err)
+	}
+	// The rejected chunk's blob is not left behind
+	if n := f.blobCount(t, "uploads"); n != 0 {
+		t.Fatalf("got %d chunk blobs after a mismatch, want none", n)
+	}
+	if _, err := f.write(upload, 0, "hello", "sha256 !!"); !errors.Is(err, ErrInvalidChecksum) {
+		t.Fatalf("invalid: got %v, want ErrInvalidChecksum", err)
+	}
+
+	state, err := f.write(upload, 0, "hello", valid)
+	if err != nil {
+		t.Fatalf("valid checksum: %v", err)
+	}
+	if state.Offset != 5 {
+		t.Fatalf("offset: got %d, want 5", state.Offset)
+	}
+}
+
+func TestLastChunkCreatesAttachment(t *testing.T) {
+	f := newUploadFixture(t, 0)
+	upload := f.create(t, 10)
+
+	if _, err := f.write(upload, 0, "hello", ""); err != nil {
+		t.Fatalf("first chunk: %v", err)
+	}
+	state, err := f.write(upload, 5, "world", "")
+	if err != nil {
+		t.Fatalf("last chunk: %v", err)
+	}
+	if !state.Complete() {
+		t.Fatalf("upload not complete: offset %d of %d", state.Offset, state.Size)
+	}
+
+	if got := f.attachmentContent(t, upload.ID); got != "helloworld" {
+		t.Fatalf("attachment content: got %q, want %q", got, "helloworld")
+	}
+	if n := f.blobCount(t, "uploads"); n != 0 {
+		t.Fatalf("got %d chunk blobs after completion, want none", n)
+	}
+}
+
+func TestGetUploadFinishesAfterFailedJoin(t *testing.T) {
+	f := newUploadFixture(t, 0)
+	upload := f.create(t, 5)
+
+	// The chunk is recorded but storing the attachment fails
+	f.attachments.failCreates = 1
+	if _, err := f.write(upload, 0, "hello", ""); err == nil {
+		t.Fatal("last chunk: got nil, want the join error")
+	}
+	if _, err := f.attachments.GetByID(context.Background(), upload.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("attachment after failed join: got %v, want ErrNotFound", err)
+	}
+	if n := f.blobCount(t, "attachments"); n != 0 {
+		t.Fatalf("got %d attachment blobs after a failed join, want none", n)
+	}
+
+	// Resuming the upload joins the chunks again
+	state, err := f.service.GetUpload(context.Background(), f.owner, upload.ID.String())
+	if err != nil {
+		t.Fatalf("GetUpload: %v", err)
+	}
+	if !state.Complete() {
+		t.Fatalf("upload not complete: offset %d of %d", state.Offset, state.Size)
+	}
+	if got := f.attachmentContent(t, upload.ID); got != "hello" {
+		t.Fatalf("attachment content: got %q, want %q", got, "hello")
+	}
+
+	// Once finished, the upload is described by its attachment
+	state, err = f.service.GetUpload(context.Background(), f.owner, upload.ID.String())
+	if err != nil {
+		t.Fatalf("GetUpload after finish: %v", err)
+	}
+	if state.ID != upload.ID || !state.Complete() {
+		t.Fatalf("got %+v, want the complete upload", state)
+	}
+}
+
+func TestExpireUploadsInBatches(t *testing.T) {
+	f := newUploadFixture(t, 0)
+	ctx := context.Background()
+
+	const expired = 2*janitorBatchSize + 1
+	for i := 0; i < expired; i++ {
+		upload := f.create(t, 10)
+		if _, err := f.write(upload, 0, "hello", ""); err != nil {
+			t.Fatalf("chunk: %v", err)
+		}
+		stored := f.uploads.uploads[upload.ID]
+		stored.ExpiresAt = time.Now().Add(-time.Minute)
+		f.uploads.uploads[upload.ID] = stored
+	}
+	live := f.create(t, 10)
+
+	if err := f.service.ExpireUploads(ctx); err != nil {
+		t.Fatalf("ExpireUploads: %v", err)
+	}
+
+	if f.uploads.deleteExpiredCalls != 3 {
+		t.Fatalf("DeleteExpired called %d times, want 3", f.uploads.deleteExpiredCalls)
+	}
+	if len(f.uploads.uploads) != 1 {
+		t.Fatalf("%d uploads left, want 1", len(f.uploads.uploads))
+	}
+	if _, err := f.uploads.GetByID(ctx, live.ID); err != nil {
+		t.Fatalf("live upload: %v", err)
+	}
+	if n := f.blobCount(t, "uploads"); n != 0 {
+		t.Fatalf("got %d chunk blobs after expiry, want none", n)
+	}
+}
+
+func TestQuotaIgnoresExpiredUploads(t *testing.T) {
+	f := newUploadFixture(t, 15)
+
+	upload := f.create(t, 10)
+	if _, err := f.service.CreateUpload(context.Background(), CreateUploadInput{OwnerID: f.owner, Filename: "b", Size: 10}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("over quota: got %v, want ErrQuotaExceeded", err)
+	}
+
+	// An expired upload no longer holds its reservation
+	stored := f.uploads.uploads[upload.ID]
+	stored.ExpiresAt = time.Now().Add(-time.Minute)
+	f.uploads.uploads[upload.ID] = stored
+	f.create(t, 10)
+}
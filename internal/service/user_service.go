@@ -86,15 +86,6 @@ func (s *UserService) Register(ctx context.Context, input RegisterUserInput) (*A
 		return nil, apperrors.ErrServerError
 	}
 
-	// Update user status
-	var wg conc.WaitGroup
-	wg.Go(func() {
-		bgCtx := context.Background()
-		if err := s.statusRepo.UpdateStatus(bgCtx, user.ID, "online"); err != nil {
-			logrus.Error("Failed to update user status: ", err)
-		}
-	})
-
 	return &AuthResponse{
 		Token: token,
 		User:  *user,
@@ -116,7 +107,7 @@ func (s *UserService) Login(ctx context.Context, input LoginInput) (*AuthRespons
 		return nil, apperrors.ErrServerError
 	}
 
-	// Update last seen and status concurrently
+	// Update last seen. Presence itself follows the user's WebSocket connections.
 	var wg conc.WaitGroup
 	wg.Go(func() {
 		bgCtx := context.Background()
@@ -125,13 +116,6 @@ func (s *UserService) Login(ctx context.Context, input LoginInput) (*AuthRespons
 		}
 	})
 
-	wg.Go(func() {
-		bgCtx := context.Background()
-		if err := s.statusRepo.UpdateStatus(bgCtx, user.ID, "online"); err != nil {
-			logrus.Error("Failed to update user status: ", err)
-		}
-	})
-
 	return &AuthResponse{
 		Token: token,
 		User:  *user,
@@ -224,6 +208,23 @@ func (s *UserService) UpdateStatus(ctx context.Context, userID string, status st
 	return nil
 }
 
+// SetOffline marks the user offline and records when they were last seen.
+// It is called when the user's last connection closes.
+func (s *UserService) SetOffline(ctx context.Context, userID string) error {
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return fmt.Errorf("invalid user ID: %w", err)
+	}
+
+	if err := s.UpdateStatus(ctx, userID, "offline"); err != nil {
+		return err
+	}
+	if err := s.userRepo.UpdateLastSeen(ctx, userUUID); err != nil {
+		return fmt.Errorf("failed to update last seen: %w", err)
+	}
+	return nil
+}
+
 func (s *UserService) GetUserStatus(ctx context.Context, userID string) (string, error) {
 	userUUID, err := uuid.Parse(userID)
 	if err != nil {
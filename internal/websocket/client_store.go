@@ -14,85 +14,142 @@ const (
 	wsClientTTL       = 24 * time.Hour
 )
 
+// ClientStore keeps a hash per user in Redis with one entry per connection,
+// so a user can be connected from several devices and servers at once
 type ClientStore struct {
-	redis *redis.Client
+	redis      *redis.Client
+	staleAfter time.Duration // Entries not refreshed for this long belong to dead connections
 }
 
 type ClientInfo struct {
-	UserID    string    `json:"user_id"`
-	ServerID  string    `json:"server_id"` // For identifying which server instance the client is connected to
-	LastSeen  time.Time `json:"last_seen"`
-	Connected bool      `json:"connected"`
+	UserID     string    `json:"user_id"`
+	ConnID     string    `json:"conn_id"`
+	DeviceID   string    `json:"device_id,omitempty"`
+	ServerID   string    `json:"server_id"` // For identifying which server instance the client is connected to
+	LastSeen   time.Time `json:"last_seen"`
+	LastActive time.Time `json:"last_active"` // Last frame received from the client, used to tell online from away
+	Connected  bool      `json:"connected"`
 }
 
-func NewClientStore(redisClient *redis.Client) *ClientStore {
+func NewClientStore(redisClient *redis.Client, staleAfter time.Duration) *ClientStore {
 	return &ClientStore{
-		redis: redisClient,
+		redis:      redisClient,
+		staleAfter: staleAfter,
 	}
 }
 
-func (s *ClientStore) AddClient(ctx context.Context, userID, serverID string) error {
-	key := fmt.Sprintf("%s%s", wsClientKeyPrefix, userID)
+// AddClient records a connection and returns the user's connection count
+func (s *ClientStore) AddClient(ctx context.Context, userID, connID, deviceID, serverID string) (int64, error) {
+	now := time.Now()
 	info := ClientInfo{
-		UserID:    userID,
-		ServerID:  serverID,
-		LastSeen:  time.Now(),
-		Connected: true,
+		UserID:     userID,
+		ConnID:     connID,
+		DeviceID:   deviceID,
+		ServerID:   serverID,
+		LastSeen:   now,
+		LastActive: now,
+		Connected:  true,
 	}
+	return s.setClient(ctx, info)
+}
+
+func (s *ClientStore) setClient(ctx context.Context, info ClientInfo) (int64, error) {
+	key := fmt.Sprintf("%s%s", wsClientKeyPrefix, info.UserID)
 
 	data, err := json.Marshal(info)
 	if err != nil {
-		return fmt.Errorf("failed to marshal client info: %w", err)
+		return 0, fmt.Errorf("failed to marshal client info: %w", err)
 	}
 
-	return s.redis.Set(ctx, key, data, wsClientTTL).Err()
+	pipe := s.redis.TxPipeline()
+	pipe.HSet(ctx, key, info.ConnID, data)
+	pipe.Expire(ctx, key, wsClientTTL)
+	count := pipe.HLen(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to store client info: %w", err)
+	}
+	return count.Val(), nil
 }
 
-func (s *ClientStore) RemoveClient(ctx context.Context, userID string) error {
+// RemoveClient removes a connection and returns how many the user has left
+func (s *ClientStore) RemoveClient(ctx context.Context, userID, connID string) (int64, error) {
 	key := fmt.Sprintf("%s%s", wsClientKeyPrefix, userID)
-	return s.redis.Del(ctx, key).Err()
+
+	if err := s.redis.HDel(ctx, key, connID).Err(); err != nil {
+		return 0, fmt.Errorf("failed to remove client info: %w", err)
+	}
+
+	clients, err := s.GetClients(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(clients)), nil
 }
 
-func (s *ClientStore) GetClient(ctx context.Context, userID string) (*ClientInfo, error) {
+// GetClients returns every live connection the user has across all servers.
+// Entries left behind by connections that died without unregistering are
+// pruned once they go stale.
+func (s *ClientStore) GetClients(ctx context.Context, userID string) ([]ClientInfo, error) {
 	key := fmt.Sprintf("%s%s", wsClientKeyPrefix, userID)
-	data, err := s.redis.Get(ctx, key).Bytes()
-	if err == redis.Nil {
-		return nil, nil
-	}
+	entries, err := s.redis.HGetAll(ctx, key).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get client info: %w", err)
 	}
 
-	var info ClientInfo
-	if err := json.Unmarshal(data, &info); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal client info: %w", err)
+	clients := make([]ClientInfo, 0, len(entries))
+	var stale []string
+	for connID, data := range entries {
+		var info ClientInfo
+		if err := json.Unmarshal([]byte(data), &info); err != nil {
+			stale = append(stale, connID)
+			continue
+		}
+		if s.staleAfter > 0 && time.Since(info.LastSeen) > s.staleAfter {
+			stale = append(stale, connID)
+			continue
+		}
+		clients = append(clients, info)
 	}
 
-	return &info, nil
+	if len(stale) > 0 {
+		if err := s.redis.HDel(ctx, key, stale...).Err(); err != nil {
+			return nil, fmt.Errorf("failed to prune stale clients: %w", err)
+		}
+	}
+
+	return clients, nil
 }
 
-func (s *ClientStore) UpdateLastSeen(ctx context.Context, userID string) error {
-	info, err := s.GetClient(ctx, userID)
+// UpdateLastSeen refreshes a connection's entry and records when the client
+// was last active
+func (s *ClientStore) UpdateLastSeen(ctx context.Context, userID, connID string, lastActive time.Time) error {
+	key := fmt.Sprintf("%s%s", wsClientKeyPrefix, userID)
+	data, err := s.redis.HGet(ctx, key, connID).Bytes()
+	if err == redis.Nil {
+		return fmt.Errorf("client not found")
+	}
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to get client info: %w", err)
 	}
-	if info == nil {
-		return fmt.Errorf("client not found")
+
+	var info ClientInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return fmt.Errorf("failed to unmarshal client info: %w", err)
 	}
 
 	info.LastSeen = time.Now()
-	return s.AddClient(ctx, userID, info.ServerID)
+	info.LastActive = lastActive
+	_, err = s.setClient(ctx, info)
+	return err
 }
 
 func (s *ClientStore) IsConnected(ctx context.Context, userID string) (bool, error) {
-	info, err := s.GetClient(ctx, userID)
+	key := fmt.Sprintf("%s%s", wsClientKeyPrefix, userID)
+	count, err := s.redis.HLen(ctx, key).Result()
 	if err != nil {
-		return false, err
+		return false, fmt.Errorf("failed to get client info: %w", err)
 	}
-	if info == nil {
-		return false, nil
-	}
-	return info.Connected, nil
+	return count > 0, nil
 }
 
 func (s *ClientStore) GetAllClients(ctx context.Context) ([]ClientInfo, error) {
@@ -104,16 +161,18 @@ func (s *ClientStore) GetAllClients(ctx context.Context) ([]ClientInfo, error) {
 
 	var clients []ClientInfo
 	for _, key := range keys {
-		data, err := s.redis.Get(ctx, key).Bytes()
+		entries, err := s.redis.HGetAll(ctx, key).Result()
 		if err != nil {
 			continue
 		}
 
-		var info ClientInfo
-		if err := json.Unmarshal(data, &info); err != nil {
-			continue
+		for _, data := range entries {
+			var info ClientInfo
+			if err := json.Unmarshal([]byte(data), &info); err != nil {
+				continue
+			}
+			clients = append(clients, info)
 		}
-		clients = append(clients, info)
 	}
 
 	return clients, nil
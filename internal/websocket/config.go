@@ -0,0 +1,58 @@
+package websocket
+
+import "time"
+
+// Config holds connection limits and timings for WebSocket clients
+type Config struct {
+	ReadBufferSize  int
+	WriteBufferSize int
+	PingPeriod      time.Duration // How often pings are sent, must be less than PongWait
+	PongWait        time.Duration // How long to wait for a pong before dropping the connection
+	WriteWait       time.Duration // Deadline for writing a single message
+	MaxMessageSize  int64         // Largest frame accepted from a client, in bytes
+	TypingTimeout   time.Duration // Typing indicators without a refresh are stopped after this long
+	AwayAfter       time.Duration // Users with no activity on any connection for this long are shown as away
+}
+
+// DefaultConfig returns the settings used for any value left unset
+func DefaultConfig() Config {
+	return Config{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		PingPeriod:      30 * time.Second,
+		PongWait:        60 * time.Second,
+		WriteWait:       10 * time.Second,
+		MaxMessageSize:  64 * 1024,
+		TypingTimeout:   6 * time.Second,
+		AwayAfter:       5 * time.Minute,
+	}
+}
+
+func (c Config) withDefaults() Config {
+	defaults := DefaultConfig()
+	if c.ReadBufferSize <= 0 {
+		c.ReadBufferSize = defaults.ReadBufferSize
+	}
+	if c.WriteBufferSize <= 0 {
+		c.WriteBufferSize = defaults.WriteBufferSize
+	}
+	if c.PongWait <= 0 {
+		c.PongWait = defaults.PongWait
+	}
+	if c.PingPeriod <= 0 || c.PingPeriod >= c.PongWait {
+		c.PingPeriod = c.PongWait * 9 / 10
+	}
+	if c.WriteWait <= 0 {
+		c.WriteWait = defaults.WriteWait
+	}
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = defaults.MaxMessageSize
+	}
+	if c.TypingTimeout <= 0 {
+		c.TypingTimeout = defaults.TypingTimeout
+	}
+	if c.AwayAfter <= 0 {
+		c.AwayAfter = defaults.AwayAfter
+	}
+	return c
+}
@@ -0,0 +1,274 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	redeliveryTimeout = 30 * time.Second
+	maxHeldFrames     = 256 // Live frames a connection may hold back during replay
+)
+
+var ErrSendBufferFull = errors.New("client send buffer is full")
+
+// DeliverMessage sends a stored message to a user with at-least-once
+// semantics. The frame carries a per-user sequence number and is kept until
+// the client acknowledges it, so it is resent on reconnect if it is lost.
+func (m *Manager) DeliverMessage(userID, messageID string, message []byte) error {
+	ctx := context.Background()
+
+	seq, err := m.deliveries.NextSeq(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	frame, err := json.Marshal(WebSocketMessage{
+		Type:      MessageTypeMessage,
+		MessageID: messageID,
+		Seq:       seq,
+		Payload:   message,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := m.deliveries.AddPending(ctx, userID, seq, frame); err != nil {
+		return err
+	}
+
+	if err := m.SendToUser(userID, frame); err != nil && !errors.Is(err, ErrSendBufferFull) {
+		// The frame stays pending and is resent when the user reconnects
+		return err
+	}
+	return nil
+}
+
+// DeliverToGroup delivers a stored message to every member of the group
+// except excludeUserID
+func (m *Manager) DeliverToGroup(groupID, messageID string, message []byte, excludeUserID string) error {
+	memberIDs, err := m.groupMemberIDs(groupID, excludeUserID)
+	if err != nil {
+		return err
+	}
+
+	for _, memberID := range memberIDs {
+		if err := m.DeliverMessage(memberID, messageID, message); err != nil {
+			m.logger.WithError(err).WithFields(logrus.Fields{
+				"group_id": groupID,
+				"user_id":  memberID,
+			}).Error("Failed to deliver group message")
+		}
+	}
+
+	return nil
+}
+
+// device identifies the connection's device for delivery tracking. A
+// connection without a device ID cannot resume, so it counts as a device of
+// its own until it disconnects.
+func (c *Client) device() string {
+	if c.DeviceID != "" {
+		return c.DeviceID
+	}
+	return "conn:" + c.ConnID
+}
+
+// addDevice starts keeping unacknowledged frames for the client's device
+func (m *Manager) addDevice(client *Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	if err := m.deliveries.AddDevice(ctx, client.ID, client.device()); err != nil {
+		m.logger.WithError(err).WithField("user_id", client.ID).Error("Failed to add device")
+	}
+}
+
+// redeliverPending resends all unacknowledged frames to a newly connected client
+func (m *Manager) redeliverPending(ctx context.Context, client *Client) {
+	frames, err := m.deliveries.GetPending(ctx, client.ID, client.device())
+	if err != nil {
+		m.logger.WithError(err).WithField("user_id", client.ID).Error("Failed to load pending frames")
+		return
+	}
+
+	for _, frame := range frames {
+		if !client.sendWait(frame, redeliveryTimeout) {
+			// Whatever is left stays pending for the next connection
+			return
+		}
+	}
+
+	if len(frames) > 0 {
+		m.logger.WithFields(logrus.Fields{
+			"user_id": client.ID,
+			"count":   len(frames),
+		}).Info("Redelivered pending frames")
+	}
+}
+
+// ackSeen handles a delivery sequence resume cursor: everything up to it was
+// seen, so only unseen pending frames are resent
+func (m *Manager) ackSeen(client *Client) {
+	seq, err := strconv.ParseInt(client.Since, 10, 64)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redeliveryTimeout)
+	defer cancel()
+
+	frames, err := m.deliveries.AckUpTo(ctx, client.ID, client.device(), seq)
+	if err != nil {
+		m.logger.WithError(err).WithField("user_id", client.ID).Error("Failed to ack seen frames")
+		return
+	}
+	for _, frame := range frames {
+		client.markDelivered(ctx, frame)
+	}
+}
+
+// replaysHistory reports whether the client resumes from a message ID and
+// so is caught up from message history
+func (m *Manager) replaysHistory(client *Client) bool {
+	if client.Since == "" || m.handler == nil {
+		return false
+	}
+	_, err := strconv.ParseInt(client.Since, 10, 64)
+	return err != nil
+}
+
+// replayMissed streams the messages sent after the client's resume cursor
+// and returns the IDs of the messages it sent. The client is registered
+// already, so it must be holding live frames.
+func (m *Manager) replayMissed(client *Client) map[string]struct{} {
+	ctx, cancel := context.WithTimeout(context.Background(), redeliveryTimeout)
+	defer cancel()
+
+	frames, err := m.handler.ReplayMessages(ctx, client.ID, client.Since)
+	if err != nil {
+		m.logger.WithError(err).WithField("user_id", client.ID).Warn("Failed to load missed messages")
+		client.sendError("", err.Error())
+		return nil
+	}
+
+	replayed := make(map[string]struct{}, len(frames))
+	for _, frame := range frames {
+		data, err := json.Marshal(frame)
+		if err != nil {
+			m.logger.WithError(err).Error("Failed to marshal frame")
+			continue
+		}
+		if !client.sendNowWait(data, redeliveryTimeout) {
+			return replayed
+		}
+		replayed[frame.MessageID] = struct{}{}
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"user_id": client.ID,
+		"since":   client.Since,
+		"count":   len(frames),
+	}).Info("Replayed missed messages")
+	return replayed
+}
+
+// holdLive starts holding back live frames, see releaseLive
+func (c *Client) holdLive() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.holding = true
+}
+
+// releaseLive sends the live frames held back during replay, in order, and
+// switches the client to live delivery. Messages that were already replayed
+// are not sent twice.
+func (c *Client) releaseLive(replayed map[string]struct{}) {
+	for {
+		c.mu.Lock()
+		if len(c.held) == 0 || c.isClosed {
+			c.holding = false
+			c.held = nil
+			c.mu.Unlock()
+			return
+		}
+		frame := c.held[0]
+		c.held = c.held[1:]
+		c.mu.Unlock()
+
+		if wasReplayed(frame, replayed) {
+			continue
+		}
+		if !c.sendNowWait(frame, redeliveryTimeout) {
+			// Unacknowledged frames are resent when the client reconnects
+			c.Manager.logger.WithField("user_id", c.ID).Warn("Send buffer full, disconnecting client")
+			c.mu.Lock()
+			c.holding = false
+			c.held = nil
+			c.mu.Unlock()
+			c.Conn.Close()
+			return
+		}
+	}
+}
+
+// wasReplayed reports whether a frame delivers a message that was replayed
+func wasReplayed(frame []byte, replayed map[string]struct{}) bool {
+	if len(replayed) == 0 {
+		return false
+	}
+	var msg WebSocketMessage
+	if err := json.Unmarshal(frame, &msg); err != nil || msg.Type != MessageTypeMessage {
+		return false
+	}
+	_, ok := replayed[msg.MessageID]
+	return ok
+}
+
+// handleAck records that the client received the frame with the given sequence number
+func (c *Client) handleAck(msg WebSocketMessage) {
+	if msg.Seq <= 0 {
+		c.sendError(msg.ClientMessageID, "ack requires a seq")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	frame, err := c.Manager.deliveries.Ack(ctx, c.ID, c.device(), msg.Seq)
+	if err != nil {
+		c.Manager.logger.WithError(err).WithField("user_id", c.ID).Error("Failed to ack frame")
+		return
+	}
+	if frame == nil {
+		// Already acknowledged
+		return
+	}
+
+	c.markDelivered(ctx, frame)
+}
+
+// markDelivered marks the message carried by an acknowledged frame as delivered
+func (c *Client) markDelivered(ctx context.Context, frame []byte) {
+	var delivered WebSocketMessage
+	if err := json.Unmarshal(frame, &delivered); err != nil {
+		c.Manager.logger.WithError(err).Error("Failed to unmarshal pending frame")
+		return
+	}
+
+	if c.Manager.handler == nil || delivered.MessageID == "" {
+		return
+	}
+	if err := c.Manager.handler.MarkAsDelivered(ctx, delivered.MessageID, c.ID); err != nil {
+		c.Manager.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":    c.ID,
+			"message_id": delivered.MessageID,
+		}).Error("Failed to mark message as delivered")
+	}
+}
@@ -0,0 +1,194 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	wsSeqKeyPrefix     = "ws:seq:"
+	wsPendingKeyPrefix = "ws:pending:"
+	wsDevicesKeyPrefix = "ws:devices:"
+	wsPendingTTL       = 7 * 24 * time.Hour
+	wsMaxPending       = 1000 // Older unacked frames are left to history replay
+)
+
+// DeliveryStore tracks per-user delivery sequence numbers and, for each of
+// the user's devices, the frames that device has not acknowledged yet.
+// Frames sent while the user has no known device are kept unclaimed; every
+// device that connects afterwards gets a copy, and the unclaimed frames
+// expire with wsPendingTTL.
+//
+// Steps that read and then change the same keys run as Lua scripts so that
+// concurrent acks, connects and disconnects cannot interleave.
+type DeliveryStore struct {
+	redis *redis.Client
+}
+
+func NewDeliveryStore(redisClient *redis.Client) *DeliveryStore {
+	return &DeliveryStore{
+		redis: redisClient,
+	}
+}
+
+func pendingKey(userID, device string) string {
+	if device == "" {
+		return fmt.Sprintf("%s%s", wsPendingKeyPrefix, userID)
+	}
+	return fmt.Sprintf("%s%s:%s", wsPendingKeyPrefix, userID, device)
+}
+
+func devicesKey(userID string) string {
+	return fmt.Sprintf("%s%s", wsDevicesKeyPrefix, userID)
+}
+
+// addDeviceScript registers a device and, if it is new, copies the unclaimed
+// frames into its pending set.
+// KEYS: devices, device pending, unclaimed. ARGV: device, max pending, TTL.
+var addDeviceScript = redis.NewScript(`
+if redis.call('SADD', KEYS[1], ARGV[1]) == 1 then
+	redis.call('ZUNIONSTORE', KEYS[2], 2, KEYS[2], KEYS[3], 'AGGREGATE', 'MAX')
+	redis.call('ZREMRANGEBYRANK', KEYS[2], 0, -tonumber(ARGV[2]) - 1)
+end
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+redis.call('EXPIRE', KEYS[2], ARGV[3])
+return 0
+`)
+
+// removeDeviceScript forgets a device. The frames of the last device are
+// left unclaimed.
+// KEYS: devices, device pending, unclaimed. ARGV: device, TTL.
+var removeDeviceScript = redis.NewScript(`
+redis.call('SREM', KEYS[1], ARGV[1])
+if redis.call('SCARD', KEYS[1]) == 0 then
+	redis.call('ZUNIONSTORE', KEYS[3], 2, KEYS[3], KEYS[2], 'AGGREGATE', 'MAX')
+	redis.call('EXPIRE', KEYS[3], ARGV[2])
+end
+redis.call('DEL', KEYS[2])
+return 0
+`)
+
+// addPendingScript stores a frame for every device, or unclaimed if there
+// is none.
+// KEYS: devices, unclaimed. ARGV: device key prefix, seq, frame, max pending, TTL.
+var addPendingScript = redis.NewScript(`
+local keys = {}
+for _, device in ipairs(redis.call('SMEMBERS', KEYS[1])) do
+	table.insert(keys, ARGV[1] .. device)
+end
+if #keys == 0 then
+	keys = {KEYS[2]}
+end
+for _, key in ipairs(keys) do
+	redis.call('ZADD', key, ARGV[2], ARGV[3])
+	redis.call('ZREMRANGEBYRANK', key, 0, -tonumber(ARGV[4]) - 1)
+	redis.call('EXPIRE', key, ARGV[5])
+end
+return 0
+`)
+
+// ackScript removes the frames scored from ARGV[1] to ARGV[2] and returns them
+// KEYS: device pending.
+var ackScript = redis.NewScript(`
+local frames = redis.call('ZRANGEBYSCORE', KEYS[1], ARGV[1], ARGV[2])
+if #frames > 0 then
+	redis.call('ZREMRANGEBYSCORE', KEYS[1], ARGV[1], ARGV[2])
+end
+return frames
+`)
+
+// NextSeq returns the next delivery sequence number for a user
+func (s *DeliveryStore) NextSeq(ctx context.Context, userID string) (int64, error) {
+	key := fmt.Sprintf("%s%s", wsSeqKeyPrefix, userID)
+	seq, err := s.redis.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get next sequence: %w", err)
+	}
+	return seq, nil
+}
+
+// AddDevice records a device of the user so that frames are kept for it
+// until it acknowledges them. A device seen for the first time gets a copy
+// of the unclaimed frames.
+func (s *DeliveryStore) AddDevice(ctx context.Context, userID, device string) error {
+	keys := []string{devicesKey(userID), pendingKey(userID, device), pendingKey(userID, "")}
+	err := addDeviceScript.Run(ctx, s.redis, keys, device, wsMaxPending, int(wsPendingTTL.Seconds())).Err()
+	if err != nil {
+		return fmt.Errorf("failed to add device: %w", err)
+	}
+	return nil
+}
+
+// RemoveDevice forgets a device. If it was the user's last device, the frames
+// it had not acknowledged are left unclaimed for the next one.
+func (s *DeliveryStore) RemoveDevice(ctx context.Context, userID, device string) error {
+	keys := []string{devicesKey(userID), pendingKey(userID, device), pendingKey(userID, "")}
+	err := removeDeviceScript.Run(ctx, s.redis, keys, device, int(wsPendingTTL.Seconds())).Err()
+	if err != nil {
+		return fmt.Errorf("failed to remove device: %w", err)
+	}
+	return nil
+}
+
+// AddPending stores a frame for each of the user's devices until that device
+// acknowledges its sequence number
+func (s *DeliveryStore) AddPending(ctx context.Context, userID string, seq int64, frame []byte) error {
+	keys := []string{devicesKey(userID), pendingKey(userID, "")}
+	prefix := pendingKey(userID, "") + ":"
+	err := addPendingScript.Run(ctx, s.redis, keys, prefix, seq, frame, wsMaxPending, int(wsPendingTTL.Seconds())).Err()
+	if err != nil {
+		return fmt.Errorf("failed to store pending frame: %w", err)
+	}
+	return nil
+}
+
+// GetPending returns the frames a device has not acknowledged in sequence order
+func (s *DeliveryStore) GetPending(ctx context.Context, userID, device string) ([][]byte, error) {
+	members, err := s.redis.ZRange(ctx, pendingKey(userID, device), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending frames: %w", err)
+	}
+	return toFrames(members), nil
+}
+
+// Ack removes the frame with the given sequence number from the device's
+// pending frames and returns it, or nil if the device already acknowledged it.
+// Of two concurrent acks of the same frame only one gets it back.
+func (s *DeliveryStore) Ack(ctx context.Context, userID, device string, seq int64) ([]byte, error) {
+	score := strconv.FormatInt(seq, 10)
+	frames, err := s.ack(ctx, pendingKey(userID, device), score, score)
+	if err != nil || len(frames) == 0 {
+		return nil, err
+	}
+	return frames[0], nil
+}
+
+// AckUpTo removes every frame with a sequence number up to and including seq
+// from the device's pending frames and returns them
+func (s *DeliveryStore) AckUpTo(ctx context.Context, userID, device string, seq int64) ([][]byte, error) {
+	frames, err := s.ack(ctx, pendingKey(userID, device), "-inf", strconv.FormatInt(seq, 10))
+	if err != nil || len(frames) == 0 {
+		return nil, err
+	}
+	return frames, nil
+}
+
+func (s *DeliveryStore) ack(ctx context.Context, key, min, max string) ([][]byte, error) {
+	members, err := ackScript.Run(ctx, s.redis, []string{key}, min, max).StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove pending frames: %w", err)
+	}
+	return toFrames(members), nil
+}
+
+func toFrames(members []string) [][]byte {
+	frames := make([][]byte, len(members))
+	for i, member := range members {
+		frames[i] = []byte(member)
+	}
+	return frames
+}
@@ -0,0 +1,228 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// replayHandler replays fixed messages and lets the test deliver live ones
+// while the replay is being loaded
+type replayHandler struct {
+	MessageHandler
+	replay   []string
+	duringFn func()
+}
+
+func (h *replayHandler) ReplayMessages(ctx context.Context, userID string, afterMessageID string) ([]WebSocketMessage, error) {
+	h.duringFn()
+	frames := make([]WebSocketMessage, len(h.replay))
+	for i, id := range h.replay {
+		frames[i] = WebSocketMessage{Type: MessageTypeMessage, MessageID: id, Timestamp: time.Now()}
+	}
+	return frames, nil
+}
+
+func TestLiveMessagesDuringReplayAreHeldAndDeduplicated(t *testing.T) {
+	server := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	m := NewManager(logger, redisClient, nil, Config{})
+
+	userID := uuid.New().String()
+	client := &Client{ID: userID, ConnID: uuid.New().String(), Send: make(chan []byte, 16), Manager: m, Since: uuid.New().String()}
+
+	// The client is registered before the replay starts
+	client.holdLive()
+	m.clients[userID] = map[string]*Client{client.ConnID: client}
+
+	// One message is both replayed and delivered live, the other is only live
+	m.SetMessageHandler(&replayHandler{
+		replay: []string{"replayed-1", "both"},
+		duringFn: func() {
+			for _, id := range []string{"both", "live-1"} {
+				if err := m.DeliverMessage(userID, id, []byte(`{}`)); err != nil {
+					t.Fatalf("DeliverMessage: %v", err)
+				}
+			}
+		},
+	})
+
+	if !m.replaysHistory(client) {
+		t.Fatal("a message ID cursor should replay history")
+	}
+	client.releaseLive(m.replayMissed(client))
+
+	var got []string
+	for len(client.Send) > 0 {
+		var frame WebSocketMessage
+		if err := json.Unmarshal(<-client.Send, &frame); err != nil {
+			t.Fatalf("unmarshal frame: %v", err)
+		}
+		got = append(got, frame.MessageID)
+	}
+
+	want := []string{"replayed-1", "both", "live-1"}
+	if len(got) != len(want) {
+		t.Fatalf("got frames %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got frames %v, want %v", got, want)
+		}
+	}
+
+	// Live delivery resumes once the held frames are released
+	if !client.trySend([]byte(`{}`)) || len(client.Send) != 1 {
+		t.Fatal("frame after release was not sent directly")
+	}
+}
+
+func TestAckByOneDeviceKeepsFrameForOthers(t *testing.T) {
+	store := newTestDeliveryStore(t)
+	ctx := context.Background()
+	userID := uuid.New().String()
+
+	// Sent before any device is known, so every device connecting later gets it
+	if err := store.AddPending(ctx, userID, 1, []byte(`first`)); err != nil {
+		t.Fatalf("AddPending: %v", err)
+	}
+	for _, device := range []string{"phone", "laptop"} {
+		if err := store.AddDevice(ctx, userID, device); err != nil {
+			t.Fatalf("AddDevice: %v", err)
+		}
+	}
+	if err := store.AddPending(ctx, userID, 2, []byte(`second`)); err != nil {
+		t.Fatalf("AddPending: %v", err)
+	}
+
+	if frame, err := store.Ack(ctx, userID, "phone", 2); err != nil || string(frame) != "second" {
+		t.Fatalf("Ack: got %q, %v", frame, err)
+	}
+	expectPending(t, store, userID, "phone", "first")
+	expectPending(t, store, userID, "laptop", "first", "second")
+
+	// A known device reconnecting does not get acknowledged frames back
+	if _, err := store.AckUpTo(ctx, userID, "phone", 1); err != nil {
+		t.Fatalf("AckUpTo: %v", err)
+	}
+	if err := store.AddDevice(ctx, userID, "phone"); err != nil {
+		t.Fatalf("AddDevice: %v", err)
+	}
+	expectPending(t, store, userID, "phone")
+
+	// Frames of the last device to leave are kept for the next one
+	for _, device := range []string{"phone", "laptop"} {
+		if err := store.RemoveDevice(ctx, userID, device); err != nil {
+			t.Fatalf("RemoveDevice: %v", err)
+		}
+	}
+	if err := store.AddDevice(ctx, userID, "tablet"); err != nil {
+		t.Fatalf("AddDevice: %v", err)
+	}
+	expectPending(t, store, userID, "tablet", "first", "second")
+}
+
+func TestConcurrentAcksReturnFrameOnce(t *testing.T) {
+	store := newTestDeliveryStore(t)
+	ctx := context.Background()
+	userID := uuid.New().String()
+
+	if err := store.AddDevice(ctx, userID, "phone"); err != nil {
+		t.Fatalf("AddDevice: %v", err)
+	}
+	if err := store.AddPending(ctx, userID, 1, []byte(`frame`)); err != nil {
+		t.Fatalf("AddPending: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var got atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			frame, err := store.Ack(ctx, userID, "phone", 1)
+			if err != nil {
+				t.Errorf("Ack: %v", err)
+			}
+			if frame != nil {
+				got.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got.Load() != 1 {
+		t.Fatalf("frame returned %d times, want once", got.Load())
+	}
+}
+
+func TestConcurrentDisconnectsLeaveFramesUnclaimed(t *testing.T) {
+	store := newTestDeliveryStore(t)
+	ctx := context.Background()
+	userID := uuid.New().String()
+
+	devices := []string{"phone", "laptop"}
+	for _, device := range devices {
+		if err := store.AddDevice(ctx, userID, device); err != nil {
+			t.Fatalf("AddDevice: %v", err)
+		}
+	}
+	if err := store.AddPending(ctx, userID, 1, []byte(`frame`)); err != nil {
+		t.Fatalf("AddPending: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for _, device := range devices {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := store.RemoveDevice(ctx, userID, device); err != nil {
+				t.Errorf("RemoveDevice: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := store.AddDevice(ctx, userID, "tablet"); err != nil {
+		t.Fatalf("AddDevice: %v", err)
+	}
+	expectPending(t, store, userID, "tablet", "frame")
+}
+
+func newTestDeliveryStore(t *testing.T) *DeliveryStore {
+	t.Helper()
+	server := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+	return NewDeliveryStore(redisClient)
+}
+
+// expectPending checks a device's pending frames, in order
+func expectPending(t *testing.T, store *DeliveryStore, userID, device string, want ...string) {
+	t.Helper()
+	frames, err := store.GetPending(context.Background(), userID, device)
+	if err != nil {
+		t.Fatalf("GetPending: %v", err)
+	}
+	got := make([]string, len(frames))
+	for i, frame := range frames {
+		got[i] = string(frame)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("%s pending: got %v, want %v", device, got, want)
+	}
+}
@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+const handlerTimeout = 10 * time.Second
+
+// MessageHandler persists chat messages received over a socket using the
+// same path as the HTTP API, records client delivery acknowledgements and
+// loads history for reconnecting clients
+type MessageHandler interface {
+	HandleChatMessage(ctx context.Context, msg WebSocketMessage) (messageID string, timestamp time.Time, err error)
+	HandleReadMessage(ctx context.Context, msg WebSocketMessage) error
+	MarkAsDelivered(ctx context.Context, messageID string, userID string) error
+	ReplayMessages(ctx context.Context, userID string, afterMessageID string) ([]WebSocketMessage, error)
+	// Contacts returns the users among userIDs whose presence the user may
+	// follow: those sharing a group or a conversation with them
+	Contacts(ctx context.Context, userID string, userIDs []string) ([]string, error)
+}
+
+// SetMessageHandler sets the handler used for inbound chat frames
+func (m *Manager) SetMessageHandler(handler MessageHandler) {
+	m.handler = handler
+}
+
+// handleChat stores a chat frame and answers the sender with an ack or an
+// error frame
+func (c *Client) handleChat(msg WebSocketMessage) {
+	if c.Manager.handler == nil {
+		c.sendError(msg.ClientMessageID, "chat messages are not supported")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	messageID, timestamp, err := c.Manager.handler.HandleChatMessage(ctx, msg)
+	if err != nil {
+		c.Manager.logger.WithError(err).WithField("user_id", c.ID).Warn("Rejected chat message")
+		c.sendError(msg.ClientMessageID, err.Error())
+		return
+	}
+
+	// Sending a message ends the typing indicator for the conversation
+	if key := typingKey(msg.RecipientID, msg.GroupID); key != "" {
+		c.stopTyping(key)
+	}
+
+	c.sendFrame(WebSocketMessage{
+		Type:            MessageTypeAck,
+		SenderID:        c.ID,
+		ClientMessageID: msg.ClientMessageID,
+		MessageID:       messageID,
+		Timestamp:       timestamp,
+	})
+}
+
+// handleRead marks the conversation read up to the frame's message. Only
+// failures are answered; the read event itself reaches every participant,
+// this connection included.
+func (c *Client) handleRead(msg WebSocketMessage) {
+	if c.Manager.handler == nil {
+		c.sendError(msg.ClientMessageID, "read receipts are not supported")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	if err := c.Manager.handler.HandleReadMessage(ctx, msg); err != nil {
+		c.Manager.logger.WithError(err).WithField("user_id", c.ID).Warn("Rejected read frame")
+		c.sendError(msg.ClientMessageID, err.Error())
+	}
+}
+
+func (c *Client) sendError(clientMessageID, reason string) {
+	c.sendFrame(WebSocketMessage{
+		Type:            MessageTypeError,
+		SenderID:        c.ID,
+		ClientMessageID: clientMessageID,
+		Error:           reason,
+		Timestamp:       time.Now(),
+	})
+}
+
+// sendFrame queues a frame for this connection only
+func (c *Client) sendFrame(frame WebSocketMessage) {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		c.Manager.logger.WithError(err).Error("Failed to marshal frame")
+		return
+	}
+
+	if !c.trySend(data) {
+		c.Manager.logger.WithField("user_id", c.ID).Warn("Dropped frame for slow client")
+	}
+}
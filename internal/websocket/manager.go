@@ -3,7 +3,9 @@ package websocket
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,62 +13,128 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 	"github.com/sourcegraph/conc"
+
+	"github.com/chat-backend/internal/repository"
 )
 
 type Client struct {
-	ID       string
+	ID       string // User ID
+	ConnID   string // Unique per connection, so a user can connect from several devices
+	DeviceID string // Optional device identifier supplied by the client
 	Conn     *websocket.Conn
 	Send     chan []byte
 	Manager  *Manager
-	mu       sync.Mutex
-	isClosed bool
+	Since    string // Resume cursor: last seen delivery seq or message ID
+	typing   typingTracker
+	watching map[string]struct{} // User IDs whose presence this connection follows, guarded by Manager.presenceMu
+	// Unix nanoseconds of the last frame received, used to detect idle users
+	lastActivity atomic.Int64
+	registered   chan struct{} // Closed once the manager has registered the connection
+	mu           sync.Mutex
+	isClosed     bool
+	// While missed messages are replayed, live frames are held back here so
+	// they arrive after the replay instead of interleaved with it
+	holding bool
+	held    [][]byte
 }
 
 type Manager struct {
-	clients     map[string]*Client // Local cache of active connections
-	clientStore *ClientStore       // Redis-based client store
-	broadcast   chan []byte
+	clients     map[string]map[string]*Client // Local cache of active connections by user ID and connection ID
+	clientStore *ClientStore                  // Redis-based client store
+	deliveries  *DeliveryStore                // Redis-based store of unacknowledged frames
+	redis       *redis.Client                 // Used to relay messages between server instances
+	groupRepo   repository.GroupRepository
+	handler     MessageHandler  // Persists chat frames received from clients
+	presence    PresenceHandler // Records online/offline transitions
+	config      Config
 	register    chan *Client
 	unregister  chan *Client
 	mu          sync.RWMutex
 	logger      *logrus.Logger
 	wg          conc.WaitGroup
 	serverID    string // Unique identifier for this server instance
+
+	// Presence subscriptions: connections on this server following each user,
+	// and the last status any server published for each local user
+	watchers   map[string]map[*Client]struct{}
+	published  map[string]string
+	presenceMu sync.Mutex
 }
 
 type MessageType string
 
 const (
-	MessageTypeChat   MessageType = "chat"
-	MessageTypeTyping MessageType = "typing"
-	MessageTypeRead   MessageType = "read"
+	MessageTypeChat     MessageType = "chat"
+	MessageTypeTyping   MessageType = "typing"
+	MessageTypeRead     MessageType = "read"
+	MessageTypeAck      MessageType = "ack"
+	MessageTypeError    MessageType = "error"
+	MessageTypeMessage  MessageType = "message"  // A stored message delivered to a recipient
+	MessageTypeEdit     MessageType = "edit"     // A stored message was edited; payload holds the new version
+	MessageTypeDelete   MessageType = "delete"   // A stored message was deleted; scope is "me" or "everyone"
+	MessageTypeReaction MessageType = "reaction" // A reaction was added or removed; payload holds the emoji's new count
+
+	MessageTypePresence            MessageType = "presence" // A presence transition pushed to subscribers
+	MessageTypePresenceSubscribe   MessageType = "presence_subscribe"
+	MessageTypePresenceUnsubscribe MessageType = "presence_unsubscribe"
 )
 
 type WebSocketMessage struct {
-	Type        MessageType `json:"type"`
-	SenderID    string      `json:"sender_id"`
-	RecipientID *string     `json:"recipient_id,omitempty"`
-	GroupID     *string     `json:"group_id,omitempty"`
-	Content     string      `json:"content"`
-	Timestamp   time.Time   `json:"timestamp"`
+	Type            MessageType     `json:"type"`
+	SenderID        string          `json:"sender_id"`
+	RecipientID     *string         `json:"recipient_id,omitempty"`
+	GroupID         *string         `json:"group_id,omitempty"`
+	Content         string          `json:"content"`
+	ContentType     string          `json:"content_type,omitempty"`
+	ReplyToID       *string         `json:"reply_to_id,omitempty"`
+	Attachments     []string        `json:"attachments,omitempty"`
+	ClientMessageID string          `json:"client_message_id,omitempty"` // Set by the client to correlate acks and errors
+	MessageID       string          `json:"message_id,omitempty"`
+	ConversationID  string          `json:"conversation_id,omitempty"` // Conversation of a read, edit, delete or reaction frame
+	Scope           string          `json:"scope,omitempty"`           // Delete scope: "me" or "everyone"
+	State           string          `json:"state,omitempty"`           // Typing state: "start" or "stop"; reaction state: "added" or "removed"
+	Emoji           string          `json:"emoji,omitempty"`           // Emoji of a reaction frame
+	Seq             int64           `json:"seq,omitempty"`             // Per-user delivery sequence number, echoed back in acks
+	UserID          string          `json:"user_id,omitempty"`         // Subject of a presence frame
+	UserIDs         []string        `json:"user_ids,omitempty"`        // Users to (un)subscribe presence for
+	Status          string          `json:"status,omitempty"`          // Presence status: online, away or offline
+	Payload         json.RawMessage `json:"payload,omitempty"`
+	Error           string          `json:"error,omitempty"`
+	Timestamp       time.Time       `json:"timestamp"`
 }
 
-func NewManager(logger *logrus.Logger, redisClient *redis.Client) *Manager {
+func NewManager(logger *logrus.Logger, redisClient *redis.Client, groupRepo repository.GroupRepository, config Config) *Manager {
 	serverID := uuid.New().String() // Generate unique server ID
+	config = config.withDefaults()
 	return &Manager{
-		clients:     make(map[string]*Client),
-		clientStore: NewClientStore(redisClient),
-		broadcast:   make(chan []byte),
+		clients:     make(map[string]map[string]*Client),
+		clientStore: NewClientStore(redisClient, 2*config.PongWait),
+		deliveries:  NewDeliveryStore(redisClient),
+		redis:       redisClient,
+		groupRepo:   groupRepo,
 		register:    make(chan *Client),
 		unregister:  make(chan *Client),
 		logger:      logger,
 		serverID:    serverID,
+		config:      config,
+		watchers:    make(map[string]map[*Client]struct{}),
+		published:   make(map[string]string),
 	}
 }
 
+// Config returns the connection settings used by this manager
+func (m *Manager) Config() Config {
+	return m.config
+}
+
 func (m *Manager) Start(ctx context.Context) {
 	m.logger.WithField("server_id", m.serverID).Info("Starting WebSocket manager")
 
+	// Receive messages relayed from other server instances
+	m.wg.Go(func() {
+		m.subscribe(ctx)
+	})
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -76,134 +144,371 @@ func (m *Manager) Start(ctx context.Context) {
 
 		case client := <-m.register:
 			m.mu.Lock()
-			m.clients[client.ID] = client
+			if m.clients[client.ID] == nil {
+				m.clients[client.ID] = make(map[string]*Client)
+			}
+			m.clients[client.ID][client.ConnID] = client
+			total := m.countClients()
 			m.mu.Unlock()
 
 			// Register in Redis
-			if err := m.clientStore.AddClient(ctx, client.ID, m.serverID); err != nil {
+			connections, err := m.clientStore.AddClient(ctx, client.ID, client.ConnID, client.DeviceID, m.serverID)
+			if err != nil {
 				m.logger.WithError(err).Error("Failed to register client in Redis")
 			}
 
+			// Any new connection brings the user online
+			m.updatePresence(ctx, client.ID, StatusOnline)
+
 			m.logger.WithFields(logrus.Fields{
 				"client_id":     client.ID,
+				"conn_id":       client.ConnID,
 				"server_id":     m.serverID,
-				"total_clients": len(m.clients),
+				"connections":   connections,
+				"total_clients": total,
 			}).Info("Client connected")
 
-		case client := <-m.unregister:
-			m.mu.Lock()
-			if _, ok := m.clients[client.ID]; ok {
-				delete(m.clients, client.ID)
-				close(client.Send)
-
-				// Remove from Redis
-				if err := m.clientStore.RemoveClient(ctx, client.ID); err != nil {
-					m.logger.WithError(err).Error("Failed to remove client from Redis")
-				}
-
-				m.logger.WithFields(logrus.Fields{
-					"client_id":     client.ID,
-					"server_id":     m.serverID,
-					"total_clients": len(m.clients),
-				}).Info("Client disconnected")
+			if client.registered != nil {
+				close(client.registered)
 			}
-			m.mu.Unlock()
 
-		case message := <-m.broadcast:
-			m.mu.RLock()
-			for _, client := range m.clients {
-				select {
-				case client.Send <- message:
-				default:
-					close(client.Send)
-					m.mu.RUnlock()
-					m.mu.Lock()
-					delete(m.clients, client.ID)
-
-					// Remove from Redis
-					if err := m.clientStore.RemoveClient(ctx, client.ID); err != nil {
-						m.logger.WithError(err).Error("Failed to remove client from Redis")
-					}
-
-					m.mu.Unlock()
-					m.mu.RLock()
-				}
-			}
-			m.mu.RUnlock()
+			// Resend everything the client has not acknowledged yet
+			m.wg.Go(func() {
+				m.redeliverPending(ctx, client)
+			})
+
+		case client := <-m.unregister:
+			m.removeClient(ctx, client)
 		}
 	}
 }
 
-func (m *Manager) shutdown() {
+func (m *Manager) removeClient(ctx context.Context, client *Client) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	if _, ok := m.clients[client.ID][client.ConnID]; !ok {
+		m.mu.Unlock()
+		return
+	}
+	delete(m.clients[client.ID], client.ConnID)
+	if len(m.clients[client.ID]) == 0 {
+		delete(m.clients, client.ID)
+	}
+	client.close()
+	_, connected := m.clients[client.ID]
+	total := m.countClients()
+	m.mu.Unlock()
 
-	for _, client := range m.clients {
-		client.mu.Lock()
-		if !client.isClosed {
-			close(client.Send)
-			client.isClosed = true
+	m.unwatchAll(client)
+	if !connected {
+		m.forgetPublished(client.ID)
+	}
+
+	// Remove from Redis
+	remaining, err := m.clientStore.RemoveClient(ctx, client.ID, client.ConnID)
+	if err != nil {
+		m.logger.WithError(err).Error("Failed to remove client from Redis")
+	}
+
+	// A connection without a device ID cannot resume, so its frames go too
+	if client.DeviceID == "" {
+		if err := m.deliveries.RemoveDevice(ctx, client.ID, client.device()); err != nil {
+			m.logger.WithError(err).Error("Failed to remove device")
 		}
-		client.mu.Unlock()
-		client.Conn.Close()
 	}
 
+	// The user goes offline only when the last device disconnects
+	if err == nil && remaining == 0 {
+		m.setOffline(ctx, client.ID)
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"client_id":     client.ID,
+		"conn_id":       client.ConnID,
+		"server_id":     m.serverID,
+		"connections":   remaining,
+		"total_clients": total,
+	}).Info("Client disconnected")
+}
+
+// countClients returns the number of local connections. Callers must hold m.mu.
+func (m *Manager) countClients() int {
+	total := 0
+	for _, conns := range m.clients {
+		total += len(conns)
+	}
+	return total
+}
+
+func (m *Manager) shutdown() {
+	m.mu.Lock()
+	for _, conns := range m.clients {
+		for _, client := range conns {
+			client.close()
+			client.Conn.Close()
+		}
+	}
+	m.mu.Unlock()
+
 	// Wait for all goroutines to complete
 	m.wg.Wait()
 }
 
+// SendToUser delivers a message to every device the user is connected
+// from, on this server and on any other server instance
 func (m *Manager) SendToUser(userID string, message []byte) error {
-	// First check if user is connected to any server
-	connected, err := m.clientStore.IsConnected(context.Background(), userID)
+	// Deliver directly to connections on this server
+	sent, localErr := m.sendLocal(userID, message)
+
+	// Relay to other servers the user is connected to
+	ctx := context.Background()
+	infos, err := m.clientStore.GetClients(ctx, userID)
 	if err != nil {
-		m.logger.WithError(err).Error("Failed to check client connection status")
+		m.logger.WithError(err).WithField("user_id", userID).Error("Failed to check client connection status")
+		// The frame reached the user here; failing would make callers
+		// retry and send it twice
+		if sent > 0 {
+			return nil
+		}
 		return err
 	}
 
-	if !connected {
+	if len(infos) == 0 {
 		m.logger.WithField("user_id", userID).Debug("User is offline")
-		return nil
+		return localErr
 	}
 
-	// Check if client is connected to this server
-	m.mu.RLock()
-	client, exists := m.clients[userID]
-	m.mu.RUnlock()
-
-	if !exists {
-		m.logger.WithFields(logrus.Fields{
-			"user_id":   userID,
-			"server_id": m.serverID,
-			"connected": connected,
-		}).Debug("User is connected to a different server")
-		return nil
+	relayed := make(map[string]bool)
+	for _, info := range infos {
+		if info.ServerID == m.serverID || relayed[info.ServerID] {
+			continue
+		}
+		relayed[info.ServerID] = true
+
+		if err := m.publish(ctx, serverChannel(info.ServerID), relayMessage{
+			UserID:  userID,
+			Payload: message,
+		}); err != nil {
+			return err
+		}
 	}
 
-	select {
-	case client.Send <- message:
-		return nil
-	default:
-		return nil // Channel is full or closed
+	return localErr
+}
+
+// SendToGroup delivers a message to every member of the group except
+// excludeUserID, wherever they are connected
+func (m *Manager) SendToGroup(groupID string, message []byte, excludeUserID string) error {
+	memberIDs, err := m.groupMemberIDs(groupID, excludeUserID)
+	if err != nil {
+		return err
 	}
+
+	for _, memberID := range memberIDs {
+		if err := m.SendToUser(memberID, message); err != nil {
+			m.logger.WithError(err).WithFields(logrus.Fields{
+				"group_id": groupID,
+				"user_id":  memberID,
+			}).Error("Failed to deliver group message")
+		}
+	}
+
+	return nil
 }
 
-func (m *Manager) SendToGroup(groupID string, message []byte, excludeUserID string) {
-	m.broadcast <- message
+// groupMemberIDs returns the IDs of all group members except excludeUserID
+func (m *Manager) groupMemberIDs(groupID string, excludeUserID string) ([]string, error) {
+	groupUUID, err := uuid.Parse(groupID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid group ID: %w", err)
+	}
+
+	members, err := m.groupRepo.GetMembers(context.Background(), groupUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group members: %w", err)
+	}
+
+	memberIDs := make([]string, 0, len(members))
+	for _, member := range members {
+		memberID := member.UserID.String()
+		if memberID != excludeUserID {
+			memberIDs = append(memberIDs, memberID)
+		}
+	}
+	return memberIDs, nil
+}
+
+// isGroupMember reports whether the user belongs to the group
+func (m *Manager) isGroupMember(groupID, userID string) (bool, error) {
+	memberIDs, err := m.groupMemberIDs(groupID, "")
+	if err != nil {
+		return false, err
+	}
+	for _, memberID := range memberIDs {
+		if memberID == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// sendFrameToUser marshals a frame and sends it to all of the user's connections
+func (m *Manager) sendFrameToUser(userID string, frame WebSocketMessage) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	return m.SendToUser(userID, data)
+}
+
+// sendFrameToGroup marshals a frame and sends it to the group's members
+func (m *Manager) sendFrameToGroup(groupID string, frame WebSocketMessage, excludeUserID string) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	return m.SendToGroup(groupID, data, excludeUserID)
+}
+
+// sendLocal delivers a message to every connection the user has on this
+// server and reports how many it reached. A connection that cannot keep
+// up is disconnected so that it reconnects and receives its pending frames
+// again.
+func (m *Manager) sendLocal(userID string, message []byte) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sent := 0
+	var sendErr error
+	for _, client := range m.clients[userID] {
+		if !client.trySend(message) {
+			m.logger.WithFields(logrus.Fields{
+				"user_id": userID,
+				"conn_id": client.ConnID,
+			}).Warn("Send buffer full, disconnecting client")
+			client.Conn.Close()
+			sendErr = ErrSendBufferFull
+			continue
+		}
+		sent++
+	}
+	return sent, sendErr
 }
 
 func (m *Manager) HandleClient(client *Client) {
-	// Register the client first
-	m.register <- client
+	client.touch()
 
-	// Start client handlers in the wait group
+	// Start writing first so missed messages can be streamed
 	m.wg.Go(func() {
 		go client.writePump()
+	})
+
+	// Frames the client has already seen are dropped before registering,
+	// so redelivery does not resend them
+	m.addDevice(client)
+	m.ackSeen(client)
+
+	// Register before replaying so nothing sent in between is lost. Live
+	// frames are held back until the replay has been streamed.
+	replay := m.replaysHistory(client)
+	if replay {
+		client.holdLive()
+	}
+	client.registered = make(chan struct{})
+	m.register <- client
+	<-client.registered
+	if replay {
+		client.releaseLive(m.replayMissed(client))
+	}
+
+	m.wg.Go(func() {
 		go client.readPump()
 	})
 }
 
+// trySend queues a message without blocking and reports whether it was queued
+func (c *Client) trySend(message []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.isClosed {
+		return false
+	}
+
+	if c.holding {
+		if len(c.held) >= maxHeldFrames {
+			return false
+		}
+		c.held = append(c.held, message)
+		return true
+	}
+	return c.enqueue(message)
+}
+
+// trySendNow queues a message ahead of any held live frames
+func (c *Client) trySendNow(message []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.isClosed {
+		return false
+	}
+	return c.enqueue(message)
+}
+
+// enqueue puts a message on the send channel without blocking. Callers must hold c.mu.
+func (c *Client) enqueue(message []byte) bool {
+	select {
+	case c.Send <- message:
+		return true
+	default:
+		return false // Channel is full
+	}
+}
+
+// sendWait queues a message, waiting up to timeout for room in the buffer.
+// It reports false if the client was closed or stayed full.
+func (c *Client) sendWait(message []byte, timeout time.Duration) bool {
+	return c.waitFor(timeout, func() bool { return c.trySend(message) })
+}
+
+// sendNowWait is sendWait for frames that must go out ahead of held live frames
+func (c *Client) sendNowWait(message []byte, timeout time.Duration) bool {
+	return c.waitFor(timeout, func() bool { return c.trySendNow(message) })
+}
+
+// waitFor retries send until it succeeds, the client closes or timeout passes
+func (c *Client) waitFor(timeout time.Duration, send func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if send() {
+			return true
+		}
+
+		c.mu.Lock()
+		closed := c.isClosed
+		c.mu.Unlock()
+
+		if closed || time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// close closes the send channel once, stopping the write pump
+func (c *Client) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.isClosed {
+		close(c.Send)
+		c.isClosed = true
+	}
+}
+
 func (c *Client) writePump() {
-	ticker := time.NewTicker(time.Second * 30)
+	config := c.Manager.config
+	ticker := time.NewTicker(config.PingPeriod)
 	defer func() {
 		ticker.Stop()
 		c.Conn.Close()
@@ -212,28 +517,19 @@ func (c *Client) writePump() {
 	for {
 		select {
 		case message, ok := <-c.Send:
+			c.Conn.SetWriteDeadline(time.Now().Add(config.WriteWait))
 			if !ok {
 				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-
-			n := len(c.Send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.Send)
-			}
-
-			if err := w.Close(); err != nil {
+			// Each frame carries exactly one JSON message
+			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				return
 			}
 
 		case <-ticker.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(config.WriteWait))
 			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
@@ -243,14 +539,18 @@ func (c *Client) writePump() {
 
 func (c *Client) readPump() {
 	defer func() {
+		c.stopAllTyping()
 		c.Manager.unregister <- c
 		c.Conn.Close()
 	}()
 
-	c.Conn.SetReadLimit(512)
-	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	config := c.Manager.config
+	c.Conn.SetReadLimit(config.MaxMessageSize)
+	c.Conn.SetReadDeadline(time.Now().Add(config.PongWait))
 	c.Conn.SetPongHandler(func(string) error {
-		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		c.Conn.SetReadDeadline(time.Now().Add(config.PongWait))
+		// Each pong proves the connection is alive and refreshes presence
+		c.Manager.heartbeat(c)
 		return nil
 	})
 
@@ -262,6 +562,7 @@ func (c *Client) readPump() {
 			}
 			break
 		}
+		c.touch()
 
 		var wsMessage WebSocketMessage
 		if err := json.Unmarshal(message, &wsMessage); err != nil {
@@ -275,30 +576,22 @@ func (c *Client) readPump() {
 
 		switch wsMessage.Type {
 		case MessageTypeChat:
-			// For 1-to-1 chat
-			if wsMessage.RecipientID != nil {
-				if err := c.Manager.SendToUser(*wsMessage.RecipientID, message); err != nil {
-					c.Manager.logger.Errorf("Failed to send message: %v", err)
-				}
-			}
-			// For group chat
-			if wsMessage.GroupID != nil {
-				c.Manager.SendToGroup(*wsMessage.GroupID, message, c.ID)
-			}
+			// Persist and deliver through the message service
+			c.handleChat(wsMessage)
+		case MessageTypeAck:
+			// Client confirms it received a delivered message
+			c.handleAck(wsMessage)
 		case MessageTypeTyping:
-			// Handle typing indicators
-			if wsMessage.RecipientID != nil {
-				if err := c.Manager.SendToUser(*wsMessage.RecipientID, message); err != nil {
-					c.Manager.logger.Errorf("Failed to send typing indicator: %v", err)
-				}
-			}
+			// Handle typing indicators, expired by the server if no stop arrives
+			c.handleTyping(wsMessage)
+		case MessageTypePresenceSubscribe:
+			// Follow presence changes for a set of users
+			c.handlePresenceSubscribe(wsMessage)
+		case MessageTypePresenceUnsubscribe:
+			c.handlePresenceUnsubscribe(wsMessage)
 		case MessageTypeRead:
-			// Handle read receipts
-			if wsMessage.RecipientID != nil {
-				if err := c.Manager.SendToUser(*wsMessage.RecipientID, message); err != nil {
-					c.Manager.logger.Errorf("Failed to send read receipt: %v", err)
-				}
-			}
+			// Move the read pointer; participants hear about it from the service
+			c.handleRead(wsMessage)
 		}
 	}
 }
@@ -0,0 +1,92 @@
+package websocket
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"github.com/chat-backend/internal/models"
+	"github.com/chat-backend/internal/repository"
+)
+
+func TestSendToUserSucceedsWhenRelayLookupFails(t *testing.T) {
+	server := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	m := NewManager(logger, redisClient, nil, Config{})
+
+	userID := uuid.New().String()
+	client := &Client{ID: userID, ConnID: uuid.New().String(), Send: make(chan []byte, 1), Manager: m}
+	m.clients[userID] = map[string]*Client{client.ConnID: client}
+
+	// Redis is gone, so the other servers cannot be looked up
+	server.Close()
+
+	if err := m.SendToUser(userID, []byte(`{}`)); err != nil {
+		t.Fatalf("SendToUser: got %v, want nil after local delivery", err)
+	}
+	if len(client.Send) != 1 {
+		t.Fatal("frame was not delivered to the local connection")
+	}
+
+	// Without a local connection the lookup failure is reported
+	if err := m.SendToUser(uuid.New().String(), []byte(`{}`)); err == nil {
+		t.Fatal("SendToUser: got nil, want the lookup error")
+	}
+}
+
+// staticGroups serves fixed group members
+type staticGroups struct {
+	repository.GroupRepository
+	members map[uuid.UUID][]uuid.UUID
+}
+
+func (r staticGroups) GetMembers(ctx context.Context, groupID uuid.UUID) ([]models.GroupMember, error) {
+	members := make([]models.GroupMember, len(r.members[groupID]))
+	for i, userID := range r.members[groupID] {
+		members[i] = models.GroupMember{GroupID: groupID, UserID: userID}
+	}
+	return members, nil
+}
+
+func TestSendToGroupReachesOnlyOtherMembers(t *testing.T) {
+	server := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	groupID, sender, member, outsider := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	groups := staticGroups{members: map[uuid.UUID][]uuid.UUID{groupID: {sender, member}}}
+	m := NewManager(logger, redisClient, groups, Config{})
+
+	clients := make(map[uuid.UUID]*Client)
+	for _, userID := range []uuid.UUID{sender, member, outsider} {
+		client := &Client{ID: userID.String(), ConnID: uuid.New().String(), Send: make(chan []byte, 1), Manager: m}
+		m.clients[client.ID] = map[string]*Client{client.ConnID: client}
+		clients[userID] = client
+	}
+
+	if err := m.SendToGroup(groupID.String(), []byte(`{}`), sender.String()); err != nil {
+		t.Fatalf("SendToGroup: %v", err)
+	}
+
+	if len(clients[member].Send) != 1 {
+		t.Error("member did not receive the group message")
+	}
+	if len(clients[sender].Send) != 0 {
+		t.Error("sender received their own group message")
+	}
+	if len(clients[outsider].Send) != 0 {
+		t.Error("non-member received the group message")
+	}
+}
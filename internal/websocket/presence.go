@@ -0,0 +1,111 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	StatusOnline  = "online"
+	StatusAway    = "away"
+	StatusOffline = "offline"
+)
+
+// PresenceHandler records presence derived from live connections. Status is
+// refreshed on every heartbeat and set offline when the last connection closes.
+type PresenceHandler interface {
+	UpdateStatus(ctx context.Context, userID string, status string) error
+	SetOffline(ctx context.Context, userID string) error
+	GetMultiUserStatus(ctx context.Context, userIDs []string) (map[string]string, error)
+}
+
+// SetPresenceHandler sets the handler notified of presence changes
+func (m *Manager) SetPresenceHandler(handler PresenceHandler) {
+	m.presence = handler
+}
+
+// touch records client activity, used to tell online from away
+func (c *Client) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (c *Client) idleFor() time.Duration {
+	return time.Since(c.lastActive())
+}
+
+func (c *Client) lastActive() time.Time {
+	return time.Unix(0, c.lastActivity.Load())
+}
+
+// heartbeat records the connection's activity and refreshes the user's
+// presence from all of their connections
+func (m *Manager) heartbeat(client *Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	if err := m.clientStore.UpdateLastSeen(ctx, client.ID, client.ConnID, client.lastActive()); err != nil {
+		m.logger.WithError(err).WithField("user_id", client.ID).Warn("Failed to refresh client registration")
+	}
+	m.updatePresence(ctx, client.ID, m.userStatus(ctx, client.ID))
+}
+
+// userStatus is online if any of the user's connections on any server has
+// been active recently, and away otherwise. Every server derives it from the
+// same connection entries, so they agree on the user's status.
+func (m *Manager) userStatus(ctx context.Context, userID string) string {
+	clients, err := m.clientStore.GetClients(ctx, userID)
+	if err != nil || len(clients) == 0 {
+		if err != nil {
+			m.logger.WithError(err).WithField("user_id", userID).Warn("Failed to get client info")
+		}
+		return m.localStatus(userID)
+	}
+
+	for _, info := range clients {
+		if time.Since(info.LastActive) < m.config.AwayAfter {
+			return StatusOnline
+		}
+	}
+	return StatusAway
+}
+
+// localStatus is online if any of the user's connections on this server has
+// been active recently, and away otherwise
+func (m *Manager) localStatus(userID string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, client := range m.clients[userID] {
+		if client.idleFor() < m.config.AwayAfter {
+			return StatusOnline
+		}
+	}
+	return StatusAway
+}
+
+func (m *Manager) updatePresence(ctx context.Context, userID, status string) {
+	if m.presence == nil {
+		return
+	}
+	if err := m.presence.UpdateStatus(ctx, userID, status); err != nil {
+		m.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"status":  status,
+		}).Error("Failed to update user status")
+		return
+	}
+	m.announcePresence(ctx, userID, status)
+}
+
+func (m *Manager) setOffline(ctx context.Context, userID string) {
+	if m.presence == nil {
+		return
+	}
+	if err := m.presence.SetOffline(ctx, userID); err != nil {
+		m.logger.WithError(err).WithField("user_id", userID).Error("Failed to set user offline")
+		return
+	}
+	m.announcePresence(ctx, userID, StatusOffline)
+}
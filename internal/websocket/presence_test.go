@@ -0,0 +1,62 @@
+package websocket
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+func TestStatusAgreesAcrossServers(t *testing.T) {
+	server := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	config := Config{AwayAfter: time.Minute}
+	active := NewManager(logger, redisClient, nil, config)
+	idle := NewManager(logger, redisClient, nil, config)
+
+	ctx := context.Background()
+	userID := uuid.New().String()
+
+	// The user is active on one server and idle on the other
+	activeClient := &Client{ID: userID, ConnID: uuid.New().String(), Manager: active}
+	activeClient.touch()
+	active.clients[userID] = map[string]*Client{activeClient.ConnID: activeClient}
+	if _, err := active.clientStore.AddClient(ctx, userID, activeClient.ConnID, "", active.serverID); err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+
+	idleClient := &Client{ID: userID, ConnID: uuid.New().String(), Manager: idle}
+	idleClient.lastActivity.Store(time.Now().Add(-time.Hour).UnixNano())
+	idle.clients[userID] = map[string]*Client{idleClient.ConnID: idleClient}
+	if _, err := idle.clientStore.AddClient(ctx, userID, idleClient.ConnID, "", idle.serverID); err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+	if err := idle.clientStore.UpdateLastSeen(ctx, userID, idleClient.ConnID, idleClient.lastActive()); err != nil {
+		t.Fatalf("UpdateLastSeen: %v", err)
+	}
+
+	for name, m := range map[string]*Manager{"active": active, "idle": idle} {
+		if status := m.userStatus(ctx, userID); status != StatusOnline {
+			t.Errorf("%s server: got %s, want %s", name, status, StatusOnline)
+		}
+	}
+
+	// Once the active connection goes idle too, both report away
+	if err := active.clientStore.UpdateLastSeen(ctx, userID, activeClient.ConnID, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("UpdateLastSeen: %v", err)
+	}
+	for name, m := range map[string]*Manager{"active": active, "idle": idle} {
+		if status := m.userStatus(ctx, userID); status != StatusAway {
+			t.Errorf("%s server: got %s, want %s", name, status, StatusAway)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// presenceChannel carries presence transitions to every server instance
+const presenceChannel = "ws:presence"
+
+// maxPresenceSubscriptions caps how many users one connection can follow
+const maxPresenceSubscriptions = 1000
+
+// presenceEvent is published on presenceChannel when a user's status changes
+type presenceEvent struct {
+	UserID    string    `json:"user_id"`
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// announcePresence publishes a status transition for a user connected to
+// this server. Heartbeats that do not change the status are not published;
+// the last status is taken from ws:presence, so transitions announced by
+// other servers count too.
+func (m *Manager) announcePresence(ctx context.Context, userID, status string) {
+	m.presenceMu.Lock()
+	if m.published[userID] == status {
+		m.presenceMu.Unlock()
+		return
+	}
+	if status == StatusOffline {
+		delete(m.published, userID)
+	} else {
+		m.published[userID] = status
+	}
+	m.presenceMu.Unlock()
+
+	data, err := json.Marshal(presenceEvent{
+		UserID:    userID,
+		Status:    status,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		m.logger.WithError(err).Error("Failed to marshal presence event")
+		return
+	}
+
+	if err := m.redis.Publish(ctx, presenceChannel, data).Err(); err != nil {
+		m.logger.WithError(err).WithField("user_id", userID).Error("Failed to publish presence event")
+	}
+}
+
+// handlePresenceEvent pushes a transition from any server to the local
+// connections following that user
+func (m *Manager) handlePresenceEvent(msg *redis.Message) {
+	var event presenceEvent
+	if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+		m.logger.WithError(err).Error("Failed to unmarshal presence event")
+		return
+	}
+
+	m.mu.RLock()
+	_, connected := m.clients[event.UserID]
+	m.mu.RUnlock()
+
+	m.presenceMu.Lock()
+	if connected && event.Status != StatusOffline {
+		m.published[event.UserID] = event.Status
+	} else {
+		delete(m.published, event.UserID)
+	}
+	watchers := make([]*Client, 0, len(m.watchers[event.UserID]))
+	for client := range m.watchers[event.UserID] {
+		watchers = append(watchers, client)
+	}
+	m.presenceMu.Unlock()
+
+	if len(watchers) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(presenceFrame(event.UserID, event.Status, event.Timestamp))
+	if err != nil {
+		m.logger.WithError(err).Error("Failed to marshal presence frame")
+		return
+	}
+
+	for _, client := range watchers {
+		if !client.trySend(data) {
+			m.logger.WithFields(logrus.Fields{
+				"user_id": client.ID,
+				"conn_id": client.ConnID,
+			}).Debug("Dropped presence update for slow client")
+		}
+	}
+}
+
+// forgetPublished drops the last status of a user with no connections left
+// on this server, so that their next connection here announces itself
+func (m *Manager) forgetPublished(userID string) {
+	m.presenceMu.Lock()
+	defer m.presenceMu.Unlock()
+	delete(m.published, userID)
+}
+
+func presenceFrame(userID, status string, timestamp time.Time) WebSocketMessage {
+	return WebSocketMessage{
+		Type:      MessageTypePresence,
+		UserID:    userID,
+		Status:    status,
+		Timestamp: timestamp,
+	}
+}
+
+// handlePresenceSubscribe starts following the requested users and replies
+// with their current status so the client does not miss earlier transitions.
+// Only contacts can be followed; the other IDs are listed in an error frame.
+func (c *Client) handlePresenceSubscribe(msg WebSocketMessage) {
+	userIDs, ok := c.validPresenceIDs(msg)
+	if !ok {
+		return
+	}
+
+	m := c.Manager
+	if m.handler == nil {
+		c.sendError(msg.ClientMessageID, "presence subscriptions are not supported")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	contacts, err := m.handler.Contacts(ctx, c.ID, userIDs)
+	if err != nil {
+		m.logger.WithError(err).WithField("user_id", c.ID).Error("Failed to check presence subscriptions")
+		c.sendError(msg.ClientMessageID, "failed to subscribe to presence")
+		return
+	}
+	if rejected := without(userIDs, contacts); len(rejected) > 0 {
+		c.sendFrame(WebSocketMessage{
+			Type:            MessageTypeError,
+			SenderID:        c.ID,
+			ClientMessageID: msg.ClientMessageID,
+			UserIDs:         rejected,
+			Error:           "not allowed to follow these users",
+			Timestamp:       time.Now(),
+		})
+	}
+	userIDs = contacts
+
+	m.presenceMu.Lock()
+	if c.watching == nil {
+		c.watching = make(map[string]struct{})
+	}
+	added := make([]string, 0, len(userIDs))
+	full := false
+	for _, userID := range userIDs {
+		if _, ok := c.watching[userID]; ok {
+			continue
+		}
+		if len(c.watching) >= maxPresenceSubscriptions {
+			full = true
+			break
+		}
+		c.watching[userID] = struct{}{}
+		if m.watchers[userID] == nil {
+			m.watchers[userID] = make(map[*Client]struct{})
+		}
+		m.watchers[userID][c] = struct{}{}
+		added = append(added, userID)
+	}
+	m.presenceMu.Unlock()
+
+	if full {
+		c.sendError(msg.ClientMessageID, "too many presence subscriptions")
+	}
+	if len(added) == 0 || m.presence == nil {
+		return
+	}
+
+	statuses, err := m.presence.GetMultiUserStatus(ctx, added)
+	if err != nil {
+		m.logger.WithError(err).WithField("user_id", c.ID).Error("Failed to load presence snapshot")
+		c.sendError(msg.ClientMessageID, "failed to load presence")
+		return
+	}
+
+	now := time.Now()
+	for _, userID := range added {
+		status := statuses[userID]
+		if status == "" {
+			status = StatusOffline
+		}
+		c.sendFrame(presenceFrame(userID, status, now))
+	}
+}
+
+// handlePresenceUnsubscribe stops following the requested users
+func (c *Client) handlePresenceUnsubscribe(msg WebSocketMessage) {
+	userIDs, ok := c.validPresenceIDs(msg)
+	if !ok {
+		return
+	}
+
+	m := c.Manager
+	m.presenceMu.Lock()
+	defer m.presenceMu.Unlock()
+
+	for _, userID := range userIDs {
+		delete(c.watching, userID)
+		m.removeWatcher(userID, c)
+	}
+}
+
+// validPresenceIDs checks the user IDs of a subscription frame, answering the
+// client with an error frame if they are not usable
+func (c *Client) validPresenceIDs(msg WebSocketMessage) ([]string, bool) {
+	if len(msg.UserIDs) == 0 {
+		c.sendError(msg.ClientMessageID, "user_ids is required")
+		return nil, false
+	}
+	for _, userID := range msg.UserIDs {
+		if _, err := uuid.Parse(userID); err != nil {
+			c.sendError(msg.ClientMessageID, "invalid user ID: "+userID)
+			return nil, false
+		}
+	}
+	return msg.UserIDs, true
+}
+
+// without returns the IDs in userIDs that are not in keep
+func without(userIDs, keep []string) []string {
+	kept := make(map[string]struct{}, len(keep))
+	for _, userID := range keep {
+		kept[userID] = struct{}{}
+	}
+	var rest []string
+	for _, userID := range userIDs {
+		if _, ok := kept[userID]; !ok {
+			rest = append(rest, userID)
+		}
+	}
+	return rest
+}
+
+// unwatchAll drops every presence subscription held by a closing connection
+func (m *Manager) unwatchAll(client *Client) {
+	m.presenceMu.Lock()
+	defer m.presenceMu.Unlock()
+
+	for userID := range client.watching {
+		m.removeWatcher(userID, client)
+	}
+	client.watching = nil
+}
+
+// removeWatcher unlinks a connection from a user. Callers must hold m.presenceMu.
+func (m *Manager) removeWatcher(userID string, client *Client) {
+	delete(m.watchers[userID], client)
+	if len(m.watchers[userID]) == 0 {
+		delete(m.watchers, userID)
+	}
+}
@@ -0,0 +1,211 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+func TestPresenceChangeReachesSubscriber(t *testing.T) {
+	server := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	// The watcher and the watched user are connected to different servers
+	watching := NewManager(logger, redisClient, nil, Config{})
+	watched := NewManager(logger, redisClient, nil, Config{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watching.subscribe(ctx)
+
+	userID := uuid.New().String()
+	client := &Client{ID: uuid.New().String(), ConnID: uuid.New().String(), Send: make(chan []byte, 1), Manager: watching}
+	watching.watchers[userID] = map[*Client]struct{}{client: {}}
+
+	// Publish only once the subscription is in place
+	deadline := time.Now().Add(time.Second)
+	for server.PubSubNumSub(presenceChannel)[presenceChannel] == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("presence subscription not established")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	watched.announcePresence(ctx, userID, StatusOnline)
+
+	select {
+	case data := <-client.Send:
+		var frame WebSocketMessage
+		if err := json.Unmarshal(data, &frame); err != nil {
+			t.Fatalf("unmarshal frame: %v", err)
+		}
+		if frame.Type != MessageTypePresence || frame.UserID != userID || frame.Status != StatusOnline {
+			t.Fatalf("got frame %+v, want %s presence for %s", frame, StatusOnline, userID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscriber did not receive the presence frame")
+	}
+}
+
+func TestReconnectAnnouncedAfterGoingOfflineElsewhere(t *testing.T) {
+	server := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	a := NewManager(logger, redisClient, nil, Config{})
+	b := NewManager(logger, redisClient, nil, Config{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.subscribe(ctx)
+	go b.subscribe(ctx)
+
+	deadline := time.Now().Add(time.Second)
+	for server.PubSubNumSub(presenceChannel)[presenceChannel] < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("presence subscriptions not established")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	userID := uuid.New().String()
+	watcher := &Client{ID: uuid.New().String(), ConnID: uuid.New().String(), Send: make(chan []byte, 16), Manager: a}
+	a.presenceMu.Lock()
+	a.watchers[userID] = map[*Client]struct{}{watcher: {}}
+	a.presenceMu.Unlock()
+
+	// expect waits for a frame with the status; B may repeat A's status
+	// before it hears about it, so other frames are skipped
+	expect := func(status string) {
+		t.Helper()
+		for {
+			select {
+			case data := <-watcher.Send:
+				var frame WebSocketMessage
+				if err := json.Unmarshal(data, &frame); err != nil {
+					t.Fatalf("unmarshal frame: %v", err)
+				}
+				if frame.Status == status {
+					return
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("watcher did not receive %s", status)
+			}
+		}
+	}
+	connect := func(m *Manager) *Client {
+		client := &Client{ID: userID, ConnID: uuid.New().String(), Send: make(chan []byte, 16), Manager: m}
+		m.mu.Lock()
+		m.clients[userID] = map[string]*Client{client.ConnID: client}
+		m.mu.Unlock()
+		if _, err := m.clientStore.AddClient(ctx, userID, client.ConnID, "", m.serverID); err != nil {
+			t.Fatalf("AddClient: %v", err)
+		}
+		m.announcePresence(ctx, userID, StatusOnline)
+		return client
+	}
+
+	// Online on A, then on B as well; B's announcement is a no-op for watchers
+	onA := connect(a)
+	expect(StatusOnline)
+	onB := connect(b)
+
+	// The user leaves A while still on B, then goes offline through B
+	a.removeClient(ctx, onA)
+	b.removeClient(ctx, onB)
+	b.announcePresence(ctx, userID, StatusOffline)
+	expect(StatusOffline)
+
+	// Reconnecting to A must be announced again
+	connect(a)
+	expect(StatusOnline)
+}
+
+// contactsHandler lets the user follow only the given contacts
+type contactsHandler struct {
+	MessageHandler
+	contacts map[string]bool
+}
+
+func (h *contactsHandler) Contacts(ctx context.Context, userID string, userIDs []string) ([]string, error) {
+	var contacts []string
+	for _, id := range userIDs {
+		if h.contacts[id] {
+			contacts = append(contacts, id)
+		}
+	}
+	return contacts, nil
+}
+
+// staticPresence reports every user as online
+type staticPresence struct {
+	PresenceHandler
+}
+
+func (staticPresence) GetMultiUserStatus(ctx context.Context, userIDs []string) (map[string]string, error) {
+	statuses := make(map[string]string, len(userIDs))
+	for _, userID := range userIDs {
+		statuses[userID] = StatusOnline
+	}
+	return statuses, nil
+}
+
+func TestPresenceSubscribeRejectsStrangers(t *testing.T) {
+	server := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	m := NewManager(logger, redisClient, nil, Config{})
+
+	contact, stranger := uuid.New().String(), uuid.New().String()
+	m.SetMessageHandler(&contactsHandler{contacts: map[string]bool{contact: true}})
+	m.SetPresenceHandler(staticPresence{})
+
+	client := &Client{ID: uuid.New().String(), ConnID: uuid.New().String(), Send: make(chan []byte, 16), Manager: m}
+	client.handlePresenceSubscribe(WebSocketMessage{
+		Type:            MessageTypePresenceSubscribe,
+		ClientMessageID: "sub-1",
+		UserIDs:         []string{stranger, contact},
+	})
+
+	var frames []WebSocketMessage
+	for len(client.Send) > 0 {
+		var frame WebSocketMessage
+		if err := json.Unmarshal(<-client.Send, &frame); err != nil {
+			t.Fatalf("unmarshal frame: %v", err)
+		}
+		frames = append(frames, frame)
+	}
+	if len(frames) != 2 {
+		t.Fatalf("got %d frames, want an error and a presence frame", len(frames))
+	}
+	if rejected := frames[0]; rejected.Type != MessageTypeError || rejected.ClientMessageID != "sub-1" ||
+		len(rejected.UserIDs) != 1 || rejected.UserIDs[0] != stranger {
+		t.Fatalf("got %+v, want an error listing %s", rejected, stranger)
+	}
+	if snapshot := frames[1]; snapshot.Type != MessageTypePresence || snapshot.UserID != contact {
+		t.Fatalf("got %+v, want the presence of %s", snapshot, contact)
+	}
+
+	if _, ok := m.watchers[stranger]; ok {
+		t.Fatal("the stranger is being followed")
+	}
+	if _, ok := m.watchers[contact][client]; !ok {
+		t.Fatal("the contact is not being followed")
+	}
+}
@@ -0,0 +1,83 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+const wsServerChannelPrefix = "ws:server:"
+
+// relayMessage is the envelope published between server instances
+type relayMessage struct {
+	UserID  string `json:"user_id"`
+	Payload []byte `json:"payload"`
+}
+
+func serverChannel(serverID string) string {
+	return fmt.Sprintf("%s%s", wsServerChannelPrefix, serverID)
+}
+
+// subscribe listens on this server's channel and the shared presence channel
+// until the context is cancelled
+func (m *Manager) subscribe(ctx context.Context) {
+	pubsub := m.redis.Subscribe(ctx, serverChannel(m.serverID), presenceChannel)
+	defer pubsub.Close()
+
+	// Wait for the subscription to be confirmed before accepting traffic
+	if _, err := pubsub.Receive(ctx); err != nil {
+		m.logger.WithError(err).Error("Failed to subscribe to relay channel")
+		return
+	}
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			if msg.Channel == presenceChannel {
+				m.handlePresenceEvent(msg)
+			} else {
+				m.handleRelayMessage(msg)
+			}
+		}
+	}
+}
+
+func (m *Manager) handleRelayMessage(msg *redis.Message) {
+	var relay relayMessage
+	if err := json.Unmarshal([]byte(msg.Payload), &relay); err != nil {
+		m.logger.WithError(err).Error("Failed to unmarshal relay message")
+		return
+	}
+
+	sent, err := m.sendLocal(relay.UserID, relay.Payload)
+	if sent == 0 && err == nil {
+		m.logger.WithFields(logrus.Fields{
+			"user_id":   relay.UserID,
+			"server_id": m.serverID,
+		}).Debug("Relayed message for user not connected to this server")
+	}
+	if err != nil {
+		m.logger.WithError(err).WithField("user_id", relay.UserID).Warn("Failed to deliver relayed message")
+	}
+}
+
+func (m *Manager) publish(ctx context.Context, channel string, relay relayMessage) error {
+	data, err := json.Marshal(relay)
+	if err != nil {
+		return fmt.Errorf("failed to marshal relay message: %w", err)
+	}
+
+	if err := m.redis.Publish(ctx, channel, data).Err(); err != nil {
+		return fmt.Errorf("failed to publish relay message: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package websocket
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	TypingStart = "start"
+	TypingStop  = "stop"
+)
+
+// typingTracker remembers which conversations a connection is typing in so
+// that repeated starts are coalesced and a stop is always sent eventually
+type typingTracker struct {
+	mu      sync.Mutex
+	targets map[string]*typingTarget
+}
+
+type typingTarget struct {
+	recipientID *string
+	groupID     *string
+	timer       *time.Timer
+}
+
+// typingKey names the conversation of a frame, or returns "" if the frame
+// has neither a recipient nor a group
+func typingKey(recipientID, groupID *string) string {
+	switch {
+	case groupID != nil:
+		return "group:" + *groupID
+	case recipientID != nil:
+		return "user:" + *recipientID
+	}
+	return ""
+}
+
+// handleTyping forwards typing start/stop events for direct and group conversations
+func (c *Client) handleTyping(msg WebSocketMessage) {
+	if (msg.RecipientID == nil) == (msg.GroupID == nil) {
+		c.sendError(msg.ClientMessageID, "typing requires either recipient_id or group_id")
+		return
+	}
+
+	switch msg.State {
+	case "", TypingStart:
+		c.startTyping(msg)
+	case TypingStop:
+		if key := typingKey(msg.RecipientID, msg.GroupID); key != "" {
+			c.stopTyping(key)
+		}
+	default:
+		c.sendError(msg.ClientMessageID, "invalid typing state")
+	}
+}
+
+func (c *Client) startTyping(msg WebSocketMessage) {
+	key := typingKey(msg.RecipientID, msg.GroupID)
+	if key == "" {
+		return
+	}
+	timeout := c.Manager.config.TypingTimeout
+
+	c.typing.mu.Lock()
+	if c.typing.targets == nil {
+		c.typing.targets = make(map[string]*typingTarget)
+	}
+	if target, ok := c.typing.targets[key]; ok {
+		// Already typing here, just push back the expiry
+		target.timer.Reset(timeout)
+		c.typing.mu.Unlock()
+		return
+	}
+	c.typing.mu.Unlock()
+
+	if msg.GroupID != nil {
+		member, err := c.Manager.isGroupMember(*msg.GroupID, c.ID)
+		if err != nil || !member {
+			c.sendError(msg.ClientMessageID, "sender is not a member of this group")
+			return
+		}
+	}
+
+	c.typing.mu.Lock()
+	if _, ok := c.typing.targets[key]; ok {
+		c.typing.mu.Unlock()
+		return
+	}
+	c.typing.targets[key] = &typingTarget{
+		recipientID: msg.RecipientID,
+		groupID:     msg.GroupID,
+		timer: time.AfterFunc(timeout, func() {
+			c.stopTyping(key)
+		}),
+	}
+	c.typing.mu.Unlock()
+
+	c.emitTyping(msg.RecipientID, msg.GroupID, TypingStart)
+}
+
+// stopTyping ends a typing indicator if it is active and tells the other participants
+func (c *Client) stopTyping(key string) {
+	c.typing.mu.Lock()
+	target, ok := c.typing.targets[key]
+	if ok {
+		target.timer.Stop()
+		delete(c.typing.targets, key)
+	}
+	c.typing.mu.Unlock()
+
+	if ok {
+		c.emitTyping(target.recipientID, target.groupID, TypingStop)
+	}
+}
+
+// stopAllTyping ends every active typing indicator, used when the connection drops
+func (c *Client) stopAllTyping() {
+	c.typing.mu.Lock()
+	keys := make([]string, 0, len(c.typing.targets))
+	for key := range c.typing.targets {
+		keys = append(keys, key)
+	}
+	c.typing.mu.Unlock()
+
+	for _, key := range keys {
+		c.stopTyping(key)
+	}
+}
+
+func (c *Client) emitTyping(recipientID, groupID *string, state string) {
+	frame := WebSocketMessage{
+		Type:        MessageTypeTyping,
+		SenderID:    c.ID,
+		RecipientID: recipientID,
+		GroupID:     groupID,
+		State:       state,
+		Timestamp:   time.Now(),
+	}
+
+	var err error
+	if groupID != nil {
+		err = c.Manager.sendFrameToGroup(*groupID, frame, c.ID)
+	} else {
+		err = c.Manager.sendFrameToUser(*recipientID, frame)
+	}
+	if err != nil && !errors.Is(err, ErrSendBufferFull) {
+		c.Manager.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": c.ID,
+			"state":   state,
+		}).Error("Failed to send typing indicator")
+	}
+}
@@ -0,0 +1,63 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+func TestTypingKey(t *testing.T) {
+	recipientID, groupID := "r", "g"
+
+	tests := []struct {
+		name        string
+		recipientID *string
+		groupID     *string
+		want        string
+	}{
+		{name: "direct", recipientID: &recipientID, want: "user:r"},
+		{name: "group", groupID: &groupID, want: "group:g"},
+		{name: "group wins", recipientID: &recipientID, groupID: &groupID, want: "group:g"},
+		{name: "neither", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := typingKey(tt.recipientID, tt.groupID); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// acceptingHandler stores every chat frame it is given
+type acceptingHandler struct {
+	MessageHandler
+}
+
+func (acceptingHandler) HandleChatMessage(ctx context.Context, msg WebSocketMessage) (string, time.Time, error) {
+	return uuid.New().String(), time.Now(), nil
+}
+
+func TestChatWithoutConversationDoesNotPanic(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	m := NewManager(logger, nil, nil, Config{})
+	m.SetMessageHandler(acceptingHandler{})
+
+	client := &Client{ID: uuid.New().String(), ConnID: uuid.New().String(), Send: make(chan []byte, 1), Manager: m}
+	client.handleChat(WebSocketMessage{Type: MessageTypeChat, ClientMessageID: "c-1", Content: "hi"})
+
+	var frame WebSocketMessage
+	if err := json.Unmarshal(<-client.Send, &frame); err != nil {
+		t.Fatalf("unmarshal frame: %v", err)
+	}
+	if frame.Type != MessageTypeAck || frame.ClientMessageID != "c-1" {
+		t.Fatalf("got %+v, want an ack", frame)
+	}
+}
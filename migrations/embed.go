@@ -0,0 +1,15 @@
+// Package migrations embeds the schema migrations so that the server finds
+// them whatever directory it is started from.
+package migrations
+
+import "embed"
+
+// Postgres holds the golang-migrate files under postgres/
+//
+//go:embed postgres/*.sql
+var Postgres embed.FS
+
+// Cassandra holds the CQL files under cassandra/
+//
+//go:embed cassandra/*.cql
+var Cassandra embed.FS
@@ -0,0 +1,29 @@
+-- Create messages table
+CREATE TABLE IF NOT EXISTS messages (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4 (),
+    sender_id UUID NOT NULL REFERENCES users (id),
+    recipient_id UUID REFERENCES users (id),
+    group_id UUID REFERENCES groups (id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    content_type VARCHAR(50) NOT NULL,
+    timestamp TIMESTAMP
+    WITH
+        TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        read_by TEXT[] DEFAULT '{}',
+        delivered_to TEXT[] DEFAULT '{}',
+        reply_to_id UUID REFERENCES messages (id) ON DELETE SET NULL,
+        attachments TEXT[] DEFAULT '{}',
+        is_edited BOOLEAN NOT NULL DEFAULT FALSE,
+        edit_timestamp TIMESTAMP
+    WITH
+        TIME ZONE,
+        -- A message goes either to a user or to a group
+        CONSTRAINT chk_messages_target CHECK ((recipient_id IS NULL) <> (group_id IS NULL))
+);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages (sender_id, recipient_id, timestamp);
+
+CREATE INDEX IF NOT EXISTS idx_messages_group_timestamp ON messages (group_id, timestamp);
+
+CREATE INDEX IF NOT EXISTS idx_messages_reply_to ON messages (reply_to_id);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS user_conversations;
\ No newline at end of file
@@ -0,0 +1,24 @@
+-- Create user_conversations table, one inbox entry per user and conversation
+CREATE TABLE IF NOT EXISTS user_conversations (
+    user_id UUID NOT NULL REFERENCES users (id),
+    conversation_id VARCHAR(255) NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    peer_id UUID REFERENCES users (id),
+    group_id UUID REFERENCES groups (id) ON DELETE CASCADE,
+    last_message_id UUID,
+    last_sender_id UUID,
+    last_message TEXT,
+    last_content_type VARCHAR(50),
+    last_activity_at TIMESTAMP
+    WITH
+        TIME ZONE NOT NULL,
+        unread_count INTEGER NOT NULL DEFAULT 0,
+        PRIMARY KEY (user_id, conversation_id)
+);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_user_conversations_activity ON user_conversations (
+    user_id,
+    last_activity_at DESC,
+    conversation_id DESC
+);
@@ -0,0 +1,4 @@
+ALTER TABLE user_conversations
+    ADD COLUMN IF NOT EXISTS unread_count INTEGER NOT NULL DEFAULT 0,
+    DROP COLUMN IF EXISTS last_read_at,
+    DROP COLUMN IF EXISTS last_read_message_id;
@@ -0,0 +1,6 @@
+-- Track how far each user has read in a conversation. Unread counts are kept
+-- in Redis from now on.
+ALTER TABLE user_conversations
+    ADD COLUMN IF NOT EXISTS last_read_message_id UUID,
+    ADD COLUMN IF NOT EXISTS last_read_at TIMESTAMP WITH TIME ZONE,
+    DROP COLUMN IF EXISTS unread_count;
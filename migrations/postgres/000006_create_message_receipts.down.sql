@@ -0,0 +1,29 @@
+ALTER TABLE messages
+ADD COLUMN IF NOT EXISTS read_by TEXT[] DEFAULT '{}',
+ADD COLUMN IF NOT EXISTS delivered_to TEXT[] DEFAULT '{}';
+
+UPDATE messages m
+SET
+    delivered_to = r.delivered_to,
+    read_by = r.read_by
+FROM (
+        SELECT
+            message_id, COALESCE(
+                array_agg(user_id::TEXT) FILTER (
+                    WHERE
+                        delivered_at IS NOT NULL
+                ), '{}'
+            ) AS delivered_to, COALESCE(
+                array_agg(user_id::TEXT) FILTER (
+                    WHERE
+                        read_at IS NOT NULL
+                ), '{}'
+            ) AS read_by
+        FROM message_receipts
+        GROUP BY
+            message_id
+    ) r
+WHERE
+    m.id = r.message_id;
+
+DROP TABLE IF EXISTS message_receipts;
@@ -0,0 +1,36 @@
+-- Create message_receipts table, one row per message and recipient
+CREATE TABLE IF NOT EXISTS message_receipts (
+    message_id UUID NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    delivered_at TIMESTAMP
+    WITH
+        TIME ZONE,
+        read_at TIMESTAMP
+    WITH
+        TIME ZONE,
+        PRIMARY KEY (message_id, user_id)
+);
+
+-- Move the existing receipt arrays over. They carry no times, so the
+-- message's own timestamp stands in. Senders get no receipt for their own
+-- messages.
+INSERT INTO
+    message_receipts (message_id, user_id, delivered_at, read_at)
+SELECT m.id, r.user_id::UUID, m.timestamp, CASE
+        WHEN r.user_id = ANY (m.read_by) THEN m.timestamp
+    END
+FROM messages m
+    CROSS JOIN LATERAL (
+        SELECT DISTINCT
+            unnest(
+                COALESCE(m.delivered_to, '{}') || COALESCE(m.read_by, '{}')
+            ) AS user_id
+    ) r
+    JOIN users u ON u.id::TEXT = r.user_id
+WHERE
+    r.user_id <> m.sender_id::TEXT ON CONFLICT DO NOTHING;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS read_by, DROP COLUMN IF EXISTS delivered_to;
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_message_receipts_user ON message_receipts (user_id);
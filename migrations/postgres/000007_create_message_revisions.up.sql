@@ -0,0 +1,21 @@
+-- Create message_revisions table, holding the earlier versions of edited
+-- messages.
+--
+-- Messages may be stored in Cassandra or MongoDB instead of this database
+-- (messages.backend), so message_id has no foreign key to messages. The same
+-- holds for every later table that refers to messages.
+CREATE TABLE IF NOT EXISTS message_revisions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4 (),
+    message_id UUID NOT NULL,
+    content TEXT NOT NULL,
+    content_type VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP
+    WITH
+        TIME ZONE NOT NULL,
+        replaced_at TIMESTAMP
+    WITH
+        TIME ZONE NOT NULL
+);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_message_revisions_message ON message_revisions (message_id, created_at);
@@ -0,0 +1,16 @@
+-- Messages deleted for everyone stay behind as tombstones
+ALTER TABLE messages
+ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP
+WITH
+    TIME ZONE;
+
+-- Create hidden_messages table, one row per message a user deleted for
+-- themselves
+CREATE TABLE IF NOT EXISTS hidden_messages (
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    message_id UUID NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+    hidden_at TIMESTAMP
+    WITH
+        TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (user_id, message_id)
+);
@@ -0,0 +1,10 @@
+-- Create message_reactions table, one row per user and emoji on a message
+CREATE TABLE IF NOT EXISTS message_reactions (
+    message_id UUID NOT NULL,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    emoji VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP
+    WITH
+        TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (message_id, user_id, emoji)
+);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_messages_reply_to;
+
+CREATE INDEX IF NOT EXISTS idx_messages_reply_to ON messages (reply_to_id);
+
+DROP TABLE IF EXISTS thread_followers;
+
+DROP TABLE IF EXISTS message_threads;
@@ -0,0 +1,47 @@
+-- Create message_threads table, one row per message that has replies
+CREATE TABLE IF NOT EXISTS message_threads (
+    parent_id UUID PRIMARY KEY,
+    reply_count INTEGER NOT NULL DEFAULT 0,
+    last_reply_id UUID NOT NULL,
+    last_reply_at TIMESTAMP
+    WITH
+        TIME ZONE NOT NULL
+);
+
+-- Create thread_followers table. A row with following = false records that
+-- the user unfollowed, so replies do not make them follow again by default.
+CREATE TABLE IF NOT EXISTS thread_followers (
+    parent_id UUID NOT NULL,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    following BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at TIMESTAMP
+    WITH
+        TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (parent_id, user_id)
+);
+
+-- Summarise the replies already stored in messages
+INSERT INTO
+    message_threads (
+        parent_id,
+        reply_count,
+        last_reply_id,
+        last_reply_at
+    )
+SELECT DISTINCT
+    ON (reply_to_id) reply_to_id,
+    COUNT(*) OVER (
+        PARTITION BY
+            reply_to_id
+    ),
+    id,
+    timestamp
+FROM messages
+WHERE
+    reply_to_id IS NOT NULL
+ORDER BY reply_to_id, timestamp DESC, id DESC ON CONFLICT DO NOTHING;
+
+-- Thread pages read replies in timestamp order
+DROP INDEX IF EXISTS idx_messages_reply_to;
+
+CREATE INDEX IF NOT EXISTS idx_messages_reply_to ON messages (reply_to_id, timestamp, id);
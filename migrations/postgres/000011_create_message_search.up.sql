@@ -0,0 +1,61 @@
+-- Create message_search table, the full-text index of message content.
+-- It is kept apart from messages so that it works whichever store holds
+-- them. hidden_for lists the users who deleted the message for themselves.
+CREATE TABLE IF NOT EXISTS message_search (
+    message_id UUID PRIMARY KEY,
+    conversation_id TEXT NOT NULL,
+    sender_id UUID NOT NULL,
+    recipient_id UUID,
+    group_id UUID,
+    content TEXT NOT NULL,
+    content_type VARCHAR(50) NOT NULL,
+    timestamp TIMESTAMP
+    WITH
+        TIME ZONE NOT NULL,
+        hidden_for UUID[] NOT NULL DEFAULT '{}',
+        search_vector TSVECTOR GENERATED ALWAYS AS (to_tsvector('simple', content)) STORED
+);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_message_search_vector ON message_search USING GIN (search_vector);
+
+CREATE INDEX IF NOT EXISTS idx_message_search_timestamp ON message_search (timestamp DESC, message_id DESC);
+
+-- Index the messages already stored in messages
+INSERT INTO
+    message_search (
+        message_id,
+        conversation_id,
+        sender_id,
+        recipient_id,
+        group_id,
+        content,
+        content_type,
+        timestamp,
+        hidden_for
+    )
+SELECT
+    m.id,
+    CASE
+        WHEN m.group_id IS NOT NULL THEN 'group:' || m.group_id::text
+        WHEN m.sender_id::text < m.recipient_id::text THEN 'dm:' || m.sender_id::text || ':' || m.recipient_id::text
+        ELSE 'dm:' || m.recipient_id::text || ':' || m.sender_id::text
+    END,
+    m.sender_id,
+    m.recipient_id,
+    m.group_id,
+    m.content,
+    m.content_type,
+    m.timestamp,
+    COALESCE(
+        (
+            SELECT ARRAY_AGG(h.user_id)
+            FROM hidden_messages h
+            WHERE
+                h.message_id = m.id
+        ),
+        '{}'
+    )
+FROM messages m
+WHERE
+    m.deleted_at IS NULL ON CONFLICT DO NOTHING;
@@ -0,0 +1,19 @@
+-- Create attachments table
+CREATE TABLE IF NOT EXISTS attachments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4 (),
+    owner_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    message_id UUID,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    size BIGINT NOT NULL,
+    checksum CHAR(64) NOT NULL,
+    storage_key TEXT NOT NULL,
+    created_at TIMESTAMP
+    WITH
+        TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_attachments_owner ON attachments (owner_id, created_at);
+
+CREATE INDEX IF NOT EXISTS idx_attachments_message ON attachments (message_id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS upload_chunks;
+
+DROP TABLE IF EXISTS uploads;
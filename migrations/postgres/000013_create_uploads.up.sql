@@ -0,0 +1,35 @@
+-- Create uploads table for resumable uploads in progress
+CREATE TABLE IF NOT EXISTS uploads (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4 (),
+    owner_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL DEFAULT '',
+    size BIGINT NOT NULL CHECK (size > 0),
+    upload_offset BIGINT NOT NULL DEFAULT 0 CHECK (upload_offset BETWEEN 0 AND size),
+    expires_at TIMESTAMP
+    WITH
+        TIME ZONE NOT NULL,
+        created_at TIMESTAMP
+    WITH
+        TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        updated_at TIMESTAMP
+    WITH
+        TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create upload_chunks table; each chunk's content is a separate blob
+CREATE TABLE IF NOT EXISTS upload_chunks (
+    upload_id UUID NOT NULL REFERENCES uploads (id) ON DELETE CASCADE,
+    chunk_offset BIGINT NOT NULL,
+    size BIGINT NOT NULL,
+    storage_key TEXT NOT NULL,
+    created_at TIMESTAMP
+    WITH
+        TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (upload_id, chunk_offset)
+);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_uploads_owner ON uploads (owner_id);
+
+CREATE INDEX IF NOT EXISTS idx_uploads_expires_at ON uploads (expires_at);